@@ -50,6 +50,7 @@ type RunTimeRepresentation struct {
 type Handler struct {
 	dashboard       bool
 	debug           bool
+	canaryToken     string
 	staticConfig    static.Configuration
 	dashboardAssets *assetfs.AssetFS
 
@@ -78,6 +79,7 @@ func New(staticConfig static.Configuration, runtimeConfig *runtime.Configuration
 		runtimeConfiguration: rConfig,
 		staticConfig:         staticConfig,
 		debug:                staticConfig.API.Debug,
+		canaryToken:          staticConfig.API.CanaryToken,
 	}
 }
 
@@ -116,6 +118,14 @@ func (h Handler) createRouter() *mux.Router {
 	router.Methods(http.MethodGet).Path("/api/udp/services").HandlerFunc(h.getUDPServices)
 	router.Methods(http.MethodGet).Path("/api/udp/services/{serviceID}").HandlerFunc(h.getUDPService)
 
+	if h.canaryToken != "" {
+		router.Methods(http.MethodPost).Path("/api/canary/invalidate").HandlerFunc(h.withCanaryToken(h.invalidateCanaryLabels))
+		router.Methods(http.MethodPut).Path("/api/canary/labels/{product}/{uid}").HandlerFunc(h.withCanaryToken(h.updateCanaryLabels))
+		router.Methods(http.MethodPost).Path("/api/canary/decision").HandlerFunc(h.withCanaryToken(h.getCanaryDecision))
+		router.Methods(http.MethodGet).Path("/api/canary/stores").HandlerFunc(h.withCanaryToken(h.getCanaryStores))
+		router.Methods(http.MethodGet).Path("/api/canary/analyses").HandlerFunc(h.withCanaryToken(h.getCanaryAnalyses))
+	}
+
 	version.Handler{}.Append(router)
 
 	if h.dashboard {
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/traefik/traefik/v2/pkg/log"
+	"github.com/traefik/traefik/v2/pkg/middlewares/canary"
+	"github.com/traefik/traefik/v2/pkg/server/service/loadbalancer/lrr"
+)
+
+type canaryInvalidation struct {
+	Product string   `json:"product"`
+	UIDs    []string `json:"uids,omitempty"`
+}
+
+type canaryResult struct {
+	Count int `json:"count"`
+}
+
+// canaryDecisionRequest describes a synthetic request to explain the canary decision for.
+type canaryDecisionRequest struct {
+	Middleware string            `json:"middleware"`
+	Service    string            `json:"service,omitempty"`
+	UID        string            `json:"uid,omitempty"`
+	Method     string            `json:"method,omitempty"`
+	URL        string            `json:"url,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Cookies    map[string]string `json:"cookies,omitempty"`
+	RemoteAddr string            `json:"remoteAddr,omitempty"`
+}
+
+type canaryDecisionResult struct {
+	*canary.Decision
+	Service        string `json:"service,omitempty"`
+	ChosenService  string `json:"chosenService,omitempty"`
+	DefaultService bool   `json:"defaultService"`
+}
+
+// withCanaryToken checks the bearer token of canary endpoints.
+func (h Handler) withCanaryToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, request *http.Request) {
+		auth := request.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(auth[7:]), []byte(h.canaryToken)) != 1 {
+			writeError(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next(rw, request)
+	}
+}
+
+// invalidateCanaryLabels drops the cached labels of uids, or all the cached labels of the product when uids is empty.
+func (h Handler) invalidateCanaryLabels(rw http.ResponseWriter, request *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	inv := canaryInvalidation{}
+	if err := json.NewDecoder(request.Body).Decode(&inv); err != nil {
+		writeError(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if inv.Product == "" {
+		writeError(rw, "product required", http.StatusBadRequest)
+		return
+	}
+
+	result := canaryResult{Count: canary.InvalidateLabels(inv.Product, inv.UIDs...)}
+	log.FromContext(request.Context()).Debugf("Invalidate canary labels of %s %v: %d", inv.Product, inv.UIDs, result.Count)
+
+	if err := json.NewEncoder(rw).Encode(result); err != nil {
+		log.FromContext(request.Context()).Error(err)
+		writeError(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// updateCanaryLabels replaces the cached labels of a user.
+func (h Handler) updateCanaryLabels(rw http.ResponseWriter, request *http.Request) {
+	product := mux.Vars(request)["product"]
+	uid := mux.Vars(request)["uid"]
+
+	rw.Header().Set("Content-Type", "application/json")
+
+	labels := []canary.Label{}
+	if err := json.NewDecoder(request.Body).Decode(&labels); err != nil {
+		writeError(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := canaryResult{Count: canary.UpdateLabels(product, uid, labels)}
+
+	if err := json.NewEncoder(rw).Encode(result); err != nil {
+		log.FromContext(request.Context()).Error(err)
+		writeError(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// getCanaryDecision explains the decision of a canary middleware for a synthetic request,
+// and which child service of a labeled service would serve it.
+func (h Handler) getCanaryDecision(rw http.ResponseWriter, request *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	dr := canaryDecisionRequest{}
+	if err := json.NewDecoder(request.Body).Decode(&dr); err != nil {
+		writeError(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if dr.Middleware == "" {
+		writeError(rw, "middleware required", http.StatusBadRequest)
+		return
+	}
+	if mi, ok := h.runtimeConfiguration.Middlewares[dr.Middleware]; !ok || mi.Canary == nil {
+		writeError(rw, fmt.Sprintf("canary middleware not found: %s", dr.Middleware), http.StatusNotFound)
+		return
+	}
+
+	req, err := newSyntheticRequest(dr)
+	if err != nil {
+		writeError(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	decision, err := canary.Explain(dr.Middleware, req)
+	if err != nil {
+		writeError(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	result := canaryDecisionResult{Decision: decision}
+	if dr.Service != "" {
+		si, ok := h.runtimeConfiguration.Services[dr.Service]
+		if !ok || si.Labeled == nil {
+			writeError(rw, fmt.Sprintf("labeled service not found: %s", dr.Service), http.StatusNotFound)
+			return
+		}
+
+		// the handlers of the balancer are never called, it only picks a service.
+		var defaultHandler http.Handler
+		if si.Labeled.Default != "" {
+			defaultHandler = http.NotFoundHandler()
+		}
+		balancer := lrr.New(si.Labeled.ServiceName, defaultHandler, nil)
+		if weight, ok := si.Labeled.Weights[si.Labeled.Default]; ok {
+			balancer.SetDefaultWeight(weight)
+		}
+		mapped := make(map[string]bool, len(si.Labeled.Labels))
+		for label, name := range si.Labeled.Labels {
+			mapped[name] = true
+			balancer.AddLabeledService(label, name, http.NotFoundHandler(), weightOf(si.Labeled.Weights, name))
+		}
+		for _, name := range si.Labeled.Services {
+			if !mapped[name] {
+				balancer.AddService(name, http.NotFoundHandler(), weightOf(si.Labeled.Weights, name))
+			}
+		}
+
+		header := http.Header{}
+		header.Set("X-Canary", decision.XCanary)
+		result.Service = dr.Service
+		result.ChosenService, result.DefaultService = balancer.Pick(header)
+		if result.DefaultService {
+			result.ChosenService = si.Labeled.Default
+		}
+	}
+
+	if err := json.NewEncoder(rw).Encode(result); err != nil {
+		log.FromContext(request.Context()).Error(err)
+		writeError(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// getCanaryStores returns the cache size stats of the canary label stores.
+func (h Handler) getCanaryStores(rw http.ResponseWriter, request *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(rw).Encode(canary.GetStoreStats()); err != nil {
+		log.FromContext(request.Context()).Error(err)
+		writeError(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// getCanaryAnalyses returns the status and the events of the analyses of the labeled services.
+func (h Handler) getCanaryAnalyses(rw http.ResponseWriter, request *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(rw).Encode(lrr.GetAnalyses()); err != nil {
+		log.FromContext(request.Context()).Error(err)
+		writeError(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func weightOf(weights map[string]int, name string) *int {
+	if w, ok := weights[name]; ok {
+		return &w
+	}
+	return nil
+}
+
+// newSyntheticRequest builds the request described by dr, its uid is set where the canary middleware reads it.
+func newSyntheticRequest(dr canaryDecisionRequest) (*http.Request, error) {
+	if dr.Method == "" {
+		dr.Method = http.MethodGet
+	}
+	if dr.URL == "" {
+		dr.URL = "/"
+	}
+	if dr.RemoteAddr == "" {
+		dr.RemoteAddr = "127.0.0.1:0"
+	}
+
+	req, err := http.NewRequest(dr.Method, dr.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.RemoteAddr = dr.RemoteAddr
+	for k, v := range dr.Headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range dr.Cookies {
+		req.AddCookie(&http.Cookie{Name: k, Value: v})
+	}
+	if dr.UID != "" {
+		if err = canary.SetUID(dr.Middleware, req, dr.UID); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
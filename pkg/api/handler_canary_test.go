@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ptypes "github.com/traefik/paerser/types"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/config/runtime"
+	"github.com/traefik/traefik/v2/pkg/config/static"
+	"github.com/traefik/traefik/v2/pkg/middlewares/canary"
+	"github.com/traefik/traefik/v2/pkg/server/service/loadbalancer/lrr"
+)
+
+func TestHandler_Canary(t *testing.T) {
+	_, err := canary.New(context.Background(), http.NotFoundHandler(), dynamic.Canary{
+		Product: "api-test",
+		Server:  "http://127.0.0.1",
+	}, "api-test-canary", nil)
+	require.NoError(t, err)
+
+	analysis, err := lrr.NewAnalysis("core", dynamic.LabeledAnalysis{Label: "beta", Interval: ptypes.Duration(time.Hour)})
+	require.NoError(t, err)
+	lrr.LaunchAnalyses(context.Background(), map[string]*lrr.Analysis{"core": analysis})
+	defer lrr.LaunchAnalyses(context.Background(), nil)
+
+	rtConf := &runtime.Configuration{
+		Middlewares: map[string]*runtime.MiddlewareInfo{
+			"api-test-canary": {Middleware: &dynamic.Middleware{Canary: &dynamic.Canary{Product: "api-test"}}},
+		},
+		Services: map[string]*runtime.ServiceInfo{
+			"core": {Service: &dynamic.Service{Labeled: &dynamic.LabeledRoundRobin{
+				ServiceName: "core",
+				Default:     "core-80",
+				Services:    []string{"core-beta-80"},
+			}}},
+		},
+	}
+
+	testCases := []struct {
+		desc       string
+		token      string
+		method     string
+		path       string
+		auth       string
+		body       string
+		statusCode int
+		expected   string
+	}{
+		{
+			desc:       "disabled without token",
+			method:     http.MethodPost,
+			path:       "/api/canary/invalidate",
+			auth:       "Bearer ",
+			body:       `{"product": "api-test"}`,
+			statusCode: http.StatusNotFound,
+		},
+		{
+			desc:       "unauthorized",
+			token:      "secret",
+			method:     http.MethodPost,
+			path:       "/api/canary/invalidate",
+			auth:       "Bearer wrong",
+			body:       `{"product": "api-test"}`,
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:       "unauthorized without bearer",
+			token:      "secret",
+			method:     http.MethodPost,
+			path:       "/api/canary/invalidate",
+			auth:       "secret",
+			body:       `{"product": "api-test"}`,
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			desc:       "product required",
+			token:      "secret",
+			method:     http.MethodPost,
+			path:       "/api/canary/invalidate",
+			auth:       "Bearer secret",
+			body:       `{"uids": ["u1"]}`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			desc:       "update labels",
+			token:      "secret",
+			method:     http.MethodPut,
+			path:       "/api/canary/labels/api-test/u1",
+			auth:       "Bearer secret",
+			body:       `[{"l": "beta"}]`,
+			statusCode: http.StatusOK,
+			expected:   `{"count":1}`,
+		},
+		{
+			desc:       "decision from server labels",
+			token:      "secret",
+			method:     http.MethodPost,
+			path:       "/api/canary/decision",
+			auth:       "Bearer secret",
+			body:       `{"middleware": "api-test-canary", "service": "core", "uid": "u1"}`,
+			statusCode: http.StatusOK,
+			expected: `{"middleware":"api-test-canary","xCanary":"label=beta,product=api-test,uid=u1","label":"beta","product":"api-test","uid":"u1",
+				"nofallback":false,"testing":false,"source":"server","sticky":false,"cached":true,"unverified":false,"labels":[{"l":"beta"}],
+				"service":"core","chosenService":"core-beta-80","defaultService":false}`,
+		},
+		{
+			desc:       "decision from header",
+			token:      "secret",
+			method:     http.MethodPost,
+			path:       "/api/canary/decision",
+			auth:       "Bearer secret",
+			body:       `{"middleware": "api-test-canary", "service": "core", "headers": {"X-Canary": "dev"}}`,
+			statusCode: http.StatusOK,
+			expected: `{"middleware":"api-test-canary","xCanary":"label=dev,product=api-test","label":"dev","product":"api-test",
+				"nofallback":false,"testing":false,"source":"header","sticky":false,"cached":false,"unverified":false,
+				"service":"core","chosenService":"core-80","defaultService":true}`,
+		},
+		{
+			desc:       "decision of unknown middleware",
+			token:      "secret",
+			method:     http.MethodPost,
+			path:       "/api/canary/decision",
+			auth:       "Bearer secret",
+			body:       `{"middleware": "unknown"}`,
+			statusCode: http.StatusNotFound,
+		},
+		{
+			desc:       "decision of unknown service",
+			token:      "secret",
+			method:     http.MethodPost,
+			path:       "/api/canary/decision",
+			auth:       "Bearer secret",
+			body:       `{"middleware": "api-test-canary", "service": "unknown"}`,
+			statusCode: http.StatusNotFound,
+		},
+		{
+			desc:       "invalidate uids",
+			token:      "secret",
+			method:     http.MethodPost,
+			path:       "/api/canary/invalidate",
+			auth:       "Bearer secret",
+			body:       `{"product": "api-test", "uids": ["u1", "u2"]}`,
+			statusCode: http.StatusOK,
+			expected:   `{"count":1}`,
+		},
+		{
+			desc:       "invalidate unknown product",
+			token:      "secret",
+			method:     http.MethodPost,
+			path:       "/api/canary/invalidate",
+			auth:       "Bearer secret",
+			body:       `{"product": "unknown"}`,
+			statusCode: http.StatusOK,
+			expected:   `{"count":0}`,
+		},
+		{
+			desc:       "stores",
+			token:      "secret",
+			method:     http.MethodGet,
+			path:       "/api/canary/stores",
+			auth:       "Bearer secret",
+			statusCode: http.StatusOK,
+			expected:   `[{"name":"api-test-canary","product":"api-test","live":0,"stale":0,"maxCacheSize":100000}]`,
+		},
+		{
+			desc:       "analyses",
+			token:      "secret",
+			method:     http.MethodGet,
+			path:       "/api/canary/analyses",
+			auth:       "Bearer secret",
+			statusCode: http.StatusOK,
+			expected:   `[{"service":"core","label":"beta","phase":"progressing","weight":10}]`,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			handler := New(static.Configuration{API: &static.API{CanaryToken: test.token}, Global: &static.Global{}}, rtConf)
+			server := httptest.NewServer(handler.createRouter())
+			defer server.Close()
+
+			req, err := http.NewRequest(test.method, server.URL+test.path, strings.NewReader(test.body))
+			require.NoError(t, err)
+			req.Header.Set("Authorization", test.auth)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, test.statusCode, resp.StatusCode)
+			if test.expected == "" {
+				return
+			}
+
+			contents, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.JSONEq(t, test.expected, string(contents))
+		})
+	}
+}
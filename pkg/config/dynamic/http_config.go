@@ -109,11 +109,55 @@ type WRRService struct {
 
 // LabeledRoundRobin defines a labeled load-balancer of services, which select service by label.
 // Label will be extract from request header or cookie, with key `X-Canary`.
-// services should be named as `{defaultService}-{label}`. Ex. "myservice-stable", "myservice-beta", "myservice-dev"
+// Services are mapped to labels by Labels, or the label of a service is inferred from its name,
+// then services should be named as `{defaultService}-{label}`. Ex. "myservice-stable", "myservice-beta", "myservice-dev"
 type LabeledRoundRobin struct {
 	ServiceName string   `json:"serviceName,omitempty" toml:"serviceName,omitempty" yaml:"serviceName,omitempty" export:"true"`
 	Default     string   `json:"default,omitempty" toml:"default,omitempty" yaml:"default,omitempty" export:"true"`
 	Services    []string `json:"services,omitempty" toml:"services,omitempty" yaml:"services,omitempty" export:"true"`
+	// Labels maps labels to services, the label of a service in Services which is not mapped is inferred from its name.
+	Labels map[string]string `json:"labels,omitempty" toml:"labels,omitempty" yaml:"labels,omitempty" export:"true"`
+	// Weights are the weights of the services keyed by service name, Default included.
+	// Requests without label are split by weight between Default and the weighted services,
+	// the weight of Default is 1 when not set. Labeled requests are split by weight between
+	// the services of the label, the weight of a service is 1 when not set.
+	Weights map[string]int `json:"weights,omitempty" toml:"weights,omitempty" yaml:"weights,omitempty" export:"true"`
+	// HealthCheck enables automatic self-healthcheck for this service, i.e.
+	// whenever a labeled service is reported as down, its requests fall back to Default,
+	// unless they are `nofallback`. In addition, if the parent of this service also has
+	// HealthCheck enabled, this service reports to its parent any status change.
+	HealthCheck *HealthCheck `json:"healthCheck,omitempty" toml:"healthCheck,omitempty" yaml:"healthCheck,omitempty" label:"allowEmpty" file:"allowEmpty" export:"true"`
+	// Analysis enables the automated analysis and promotion of the services of a label.
+	Analysis *LabeledAnalysis `json:"analysis,omitempty" toml:"analysis,omitempty" yaml:"analysis,omitempty" export:"true"`
+}
+
+// +k8s:deepcopy-gen=true
+
+// LabeledAnalysis defines the automated analysis of the services of Label (the canary) against Default.
+// At every Interval, the error ratio and the p99 latency of the canary over the interval are compared with the ones of Default:
+// the weight of the canary is increased by StepWeight until MaxWeight while they are under the thresholds,
+// or it is set to 0 as soon as one of them is breached. The weight of Default is 100 minus the weight of the canary.
+type LabeledAnalysis struct {
+	Label      string          `json:"label,omitempty" toml:"label,omitempty" yaml:"label,omitempty" export:"true"`
+	Interval   ptypes.Duration `json:"interval,omitempty" toml:"interval,omitempty" yaml:"interval,omitempty" export:"true"`
+	StepWeight int             `json:"stepWeight,omitempty" toml:"stepWeight,omitempty" yaml:"stepWeight,omitempty" export:"true"`
+	MaxWeight  int             `json:"maxWeight,omitempty" toml:"maxWeight,omitempty" yaml:"maxWeight,omitempty" export:"true"`
+	// MinRequests is the minimum number of requests of the canary in an interval to take a decision.
+	MinRequests int `json:"minRequests,omitempty" toml:"minRequests,omitempty" yaml:"minRequests,omitempty" export:"true"`
+	// MaxErrorRatio is the maximum difference between the 5xx ratio of the canary and the one of Default, ex. 0.01.
+	MaxErrorRatio float64 `json:"maxErrorRatio,omitempty" toml:"maxErrorRatio,omitempty" yaml:"maxErrorRatio,omitempty" export:"true"`
+	// MaxLatencyRatio is the maximum ratio of the p99 latency of the canary to the one of Default, ex. 1.5.
+	MaxLatencyRatio float64 `json:"maxLatencyRatio,omitempty" toml:"maxLatencyRatio,omitempty" yaml:"maxLatencyRatio,omitempty" export:"true"`
+}
+
+// SetDefaults Default values for a LabeledAnalysis.
+func (a *LabeledAnalysis) SetDefaults() {
+	a.Interval = ptypes.Duration(time.Minute)
+	a.StepWeight = 10
+	a.MaxWeight = 100
+	a.MinRequests = 20
+	a.MaxErrorRatio = 0.01
+	a.MaxLatencyRatio = 1.5
 }
 
 // SetDefaults Default values for a WRRService.
@@ -145,6 +189,16 @@ type Cookie struct {
 type ServersLoadBalancer struct {
 	Sticky  *Sticky  `json:"sticky,omitempty" toml:"sticky,omitempty" yaml:"sticky,omitempty" label:"allowEmpty" file:"allowEmpty" export:"true"`
 	Servers []Server `json:"servers,omitempty" toml:"servers,omitempty" yaml:"servers,omitempty" label-slice-as-struct:"server" export:"true"`
+	// Strategy is the load balancing strategy between the servers:
+	// RoundRobin (default), LeastRequest, PeakEWMA, P2C (power of two choices) or ConsistentHash.
+	Strategy string `json:"strategy,omitempty" toml:"strategy,omitempty" yaml:"strategy,omitempty" export:"true"`
+	// ConsistentHash configures the ConsistentHash strategy.
+	ConsistentHash *ConsistentHash `json:"consistentHash,omitempty" toml:"consistentHash,omitempty" yaml:"consistentHash,omitempty" label:"allowEmpty" file:"allowEmpty" export:"true"`
+	// Locality enables the locality-aware load balancing, which prefers the servers of the zone of the Traefik instance.
+	Locality *Locality `json:"locality,omitempty" toml:"locality,omitempty" yaml:"locality,omitempty" label:"allowEmpty" file:"allowEmpty" export:"true"`
+	// OutlierDetection enables the passive health check of the servers of this load-balancer,
+	// which ejects the servers whose responses fail for a while.
+	OutlierDetection *OutlierDetection `json:"outlierDetection,omitempty" toml:"outlierDetection,omitempty" yaml:"outlierDetection,omitempty" label:"allowEmpty" file:"allowEmpty" export:"true"`
 	// HealthCheck enables regular active checks of the responsiveness of the
 	// children servers of this load-balancer. To propagate status changes (e.g. all
 	// servers of this service are down) upwards, HealthCheck must also be enabled on
@@ -180,6 +234,43 @@ func (l *ServersLoadBalancer) SetDefaults() {
 
 // +k8s:deepcopy-gen=true
 
+// ConsistentHash holds the configuration of the consistent hashing of the requests to the servers.
+// The key of a request is one of its header, cookie, query parameter or path, or its client IP by default.
+// The requests without key are hashed by their client IP.
+type ConsistentHash struct {
+	Header     string      `json:"header,omitempty" toml:"header,omitempty" yaml:"header,omitempty" export:"true"`
+	Cookie     string      `json:"cookie,omitempty" toml:"cookie,omitempty" yaml:"cookie,omitempty" export:"true"`
+	Query      string      `json:"query,omitempty" toml:"query,omitempty" yaml:"query,omitempty" export:"true"`
+	Path       bool        `json:"path,omitempty" toml:"path,omitempty" yaml:"path,omitempty" export:"true"`
+	IPStrategy *IPStrategy `json:"ipStrategy,omitempty" toml:"ipStrategy,omitempty" yaml:"ipStrategy,omitempty" label:"allowEmpty" file:"allowEmpty" export:"true"`
+	// LoadFactor bounds the requests in flight of a server to LoadFactor times the average ones,
+	// the requests over the bound go to the next servers of the ring. It must be greater than 1.
+	LoadFactor float64 `json:"loadFactor,omitempty" toml:"loadFactor,omitempty" yaml:"loadFactor,omitempty" export:"true"`
+}
+
+// SetDefaults sets the default values.
+func (c *ConsistentHash) SetDefaults() {
+	c.LoadFactor = 1.25
+}
+
+// +k8s:deepcopy-gen=true
+
+// Locality holds the configuration of the locality-aware load balancing.
+// The requests are sent to the servers of the zone of the Traefik instance while they are under MaxLoad,
+// then to the servers of the failover zones of the instance in priority order, then to the servers of the other zones.
+type Locality struct {
+	// MaxLoad is the average number of requests in flight per server of a zone, relative to their weight,
+	// from which the requests spill over to the next zone.
+	MaxLoad float64 `json:"maxLoad,omitempty" toml:"maxLoad,omitempty" yaml:"maxLoad,omitempty" export:"true"`
+}
+
+// SetDefaults sets the default values.
+func (l *Locality) SetDefaults() {
+	l.MaxLoad = 10
+}
+
+// +k8s:deepcopy-gen=true
+
 // ResponseForwarding holds configuration for the forward of the response.
 type ResponseForwarding struct {
 	FlushInterval string `json:"flushInterval,omitempty" toml:"flushInterval,omitempty" yaml:"flushInterval,omitempty" export:"true"`
@@ -192,6 +283,11 @@ type Server struct {
 	URL    string `json:"url,omitempty" toml:"url,omitempty" yaml:"url,omitempty" label:"-"`
 	Scheme string `toml:"-" json:"-" yaml:"-" file:"-"`
 	Port   string `toml:"-" json:"-" yaml:"-" file:"-"`
+	// Weight is the relative weight of the server, 1 when not set. A server of weight 0 is drained.
+	Weight *int `json:"weight,omitempty" toml:"weight,omitempty" yaml:"weight,omitempty" export:"true"`
+	// Zone is the availability zone of the server.
+	Zone   string            `json:"zone,omitempty" toml:"zone,omitempty" yaml:"zone,omitempty" export:"true"`
+	Labels map[string]string `json:"labels,omitempty" toml:"labels,omitempty" yaml:"labels,omitempty" export:"true"`
 }
 
 // SetDefaults Default values for a Server.
@@ -223,6 +319,39 @@ func (h *ServerHealthCheck) SetDefaults() {
 
 // +k8s:deepcopy-gen=true
 
+// OutlierDetection holds the passive health check configuration of the servers of a load-balancer.
+// The responses of the servers are watched, and a server is ejected from the load-balancer when it has
+// ConsecutiveGatewayErrors consecutive 502, 503 or 504 responses, ConsecutiveConnectErrors consecutive connection failures,
+// or a 5xx ratio over MaxErrorRatio in an Interval of at least MinRequests requests.
+// An ejected server returns after BaseEjectionTime, which is doubled at each consecutive ejection of the server up to MaxEjectionTime.
+// A negative threshold disables its check.
+type OutlierDetection struct {
+	ConsecutiveGatewayErrors int     `json:"consecutiveGatewayErrors,omitempty" toml:"consecutiveGatewayErrors,omitempty" yaml:"consecutiveGatewayErrors,omitempty" export:"true"`
+	ConsecutiveConnectErrors int     `json:"consecutiveConnectErrors,omitempty" toml:"consecutiveConnectErrors,omitempty" yaml:"consecutiveConnectErrors,omitempty" export:"true"`
+	MaxErrorRatio            float64 `json:"maxErrorRatio,omitempty" toml:"maxErrorRatio,omitempty" yaml:"maxErrorRatio,omitempty" export:"true"`
+	MinRequests              int     `json:"minRequests,omitempty" toml:"minRequests,omitempty" yaml:"minRequests,omitempty" export:"true"`
+	// Interval is the period of the evaluation of the error ratios and of the return of the ejected servers.
+	Interval         ptypes.Duration `json:"interval,omitempty" toml:"interval,omitempty" yaml:"interval,omitempty" export:"true"`
+	BaseEjectionTime ptypes.Duration `json:"baseEjectionTime,omitempty" toml:"baseEjectionTime,omitempty" yaml:"baseEjectionTime,omitempty" export:"true"`
+	MaxEjectionTime  ptypes.Duration `json:"maxEjectionTime,omitempty" toml:"maxEjectionTime,omitempty" yaml:"maxEjectionTime,omitempty" export:"true"`
+	// MaxEjectionPercent is the maximum percentage of the servers which can be ejected at once, at least one server can be ejected.
+	MaxEjectionPercent int `json:"maxEjectionPercent,omitempty" toml:"maxEjectionPercent,omitempty" yaml:"maxEjectionPercent,omitempty" export:"true"`
+}
+
+// SetDefaults Default values for an OutlierDetection.
+func (o *OutlierDetection) SetDefaults() {
+	o.ConsecutiveGatewayErrors = 5
+	o.ConsecutiveConnectErrors = 3
+	o.MaxErrorRatio = 0.5
+	o.MinRequests = 20
+	o.Interval = ptypes.Duration(10 * time.Second)
+	o.BaseEjectionTime = ptypes.Duration(30 * time.Second)
+	o.MaxEjectionTime = ptypes.Duration(5 * time.Minute)
+	o.MaxEjectionPercent = 10
+}
+
+// +k8s:deepcopy-gen=true
+
 // HealthCheck controls healthcheck awareness and propagation at the services level.
 type HealthCheck struct{}
 
@@ -38,8 +38,9 @@ type Middleware struct {
 	Retry             *Retry             `json:"retry,omitempty" toml:"retry,omitempty" yaml:"retry,omitempty" export:"true"`
 	ContentType       *ContentType       `json:"contentType,omitempty" toml:"contentType,omitempty" yaml:"contentType,omitempty" export:"true"`
 
-	Plugin map[string]PluginConf `json:"plugin,omitempty" toml:"plugin,omitempty" yaml:"plugin,omitempty" export:"true"`
-	Canary *Canary               `json:"canary,omitempty" toml:"canary,omitempty" yaml:"canary,omitempty" export:"true"`
+	Plugin    map[string]PluginConf `json:"plugin,omitempty" toml:"plugin,omitempty" yaml:"plugin,omitempty" export:"true"`
+	Canary    *Canary               `json:"canary,omitempty" toml:"canary,omitempty" yaml:"canary,omitempty" export:"true"`
+	RequestID *RequestID            `json:"requestID,omitempty" toml:"requestID,omitempty" yaml:"requestID,omitempty" label:"allowEmpty" file:"allowEmpty" export:"true"`
 }
 
 // +k8s:deepcopy-gen=true
@@ -302,11 +303,30 @@ type PassTLSClientCert struct {
 
 // SourceCriterion defines what criterion is used to group requests as originating from a common source.
 // If none are set, the default is to use the request's remote address field.
-// All fields are mutually exclusive.
+// All fields are mutually exclusive, except IPStrategy which resolves the client IP of Key.
+// Key builds the source from several parts of the request, see SourceKey.
 type SourceCriterion struct {
 	IPStrategy        *IPStrategy `json:"ipStrategy,omitempty" toml:"ipStrategy,omitempty" yaml:"ipStrategy,omitempty" export:"true"`
 	RequestHeaderName string      `json:"requestHeaderName,omitempty" toml:"requestHeaderName,omitempty" yaml:"requestHeaderName,omitempty" export:"true"`
 	RequestHost       bool        `json:"requestHost,omitempty" toml:"requestHost,omitempty" yaml:"requestHost,omitempty" export:"true"`
+	Key               *SourceKey  `json:"key,omitempty" toml:"key,omitempty" yaml:"key,omitempty" export:"true"`
+}
+
+// +k8s:deepcopy-gen=true
+
+// SourceKey defines a source composed of several parts of the request, which are joined with ":".
+// The parts which are not set in the request are empty, and the client IP is used when none is set.
+// The client IP is resolved by the IPStrategy of the SourceCriterion.
+type SourceKey struct {
+	// UID is the user id resolved by the canary middleware, the client IP is used for the requests without user id.
+	UID    bool `json:"uid,omitempty" toml:"uid,omitempty" yaml:"uid,omitempty" export:"true"`
+	Method bool `json:"method,omitempty" toml:"method,omitempty" yaml:"method,omitempty" export:"true"`
+	Path   bool `json:"path,omitempty" toml:"path,omitempty" yaml:"path,omitempty" export:"true"`
+	// PathTemplates replace the path which matches one of them, ex. `/api/users/{id}` matches `/api/users/123`.
+	// A `{name}` segment matches any segment.
+	PathTemplates []string `json:"pathTemplates,omitempty" toml:"pathTemplates,omitempty" yaml:"pathTemplates,omitempty" export:"true"`
+	Host          bool     `json:"host,omitempty" toml:"host,omitempty" yaml:"host,omitempty" export:"true"`
+	Headers       []string `json:"headers,omitempty" toml:"headers,omitempty" yaml:"headers,omitempty" export:"true"`
 }
 
 // +k8s:deepcopy-gen=true
@@ -524,6 +544,119 @@ type Canary struct {
 	CacheCleanDuration   ptypes.Duration `json:"cacheCleanDuration,omitempty" toml:"cacheCleanDuration,omitempty" yaml:"cacheCleanDuration,omitempty" export:"true"`
 	Sticky               *Sticky         `json:"sticky,omitempty" toml:"sticky,omitempty" yaml:"sticky,omitempty" export:"true"`
 	LabelsMap            *LabelsMap      `json:"labelsMap,omitempty" toml:"labelsMap,omitempty" yaml:"labelsMap,omitempty" export:"true"`
+	Rules                []CanaryRule    `json:"rules,omitempty" toml:"rules,omitempty" yaml:"rules,omitempty" export:"true"`
+	Rollout              *CanaryRollout  `json:"rollout,omitempty" toml:"rollout,omitempty" yaml:"rollout,omitempty" export:"true"`
+	// Sources are label sources tried in order when the previous one fails, after Server if it is set.
+	Sources []LabelSource `json:"sources,omitempty" toml:"sources,omitempty" yaml:"sources,omitempty" export:"true"`
+	// InvalidationStream is the URL of a server-sent events stream which pushes the uids whose cached labels should be dropped.
+	InvalidationStream string `json:"invalidationStream,omitempty" toml:"invalidationStream,omitempty" yaml:"invalidationStream,omitempty" export:"true"`
+	// ServerOptions configures the client and the circuit breaker of the label servers.
+	ServerOptions *LabelServerOptions `json:"serverOptions,omitempty" toml:"serverOptions,omitempty" yaml:"serverOptions,omitempty" export:"true"`
+	// MaxStaleness is how long expired labels can still be served while they are refreshed in background, or while the label sources fail, default to CacheExpiration.
+	MaxStaleness ptypes.Duration `json:"maxStaleness,omitempty" toml:"maxStaleness,omitempty" yaml:"maxStaleness,omitempty" export:"true"`
+	// Warmup warms the labels cache up at startup and on reload, it requires Server.
+	Warmup *CanaryWarmup `json:"warmup,omitempty" toml:"warmup,omitempty" yaml:"warmup,omitempty" export:"true"`
+	// Signing signs the X-Canary header on public gateways, and verifies it on internal gateways with ForwardLabel.
+	Signing *CanarySigning `json:"signing,omitempty" toml:"signing,omitempty" yaml:"signing,omitempty" export:"true"`
+	// Identity declares the sources of the user id, instead of the Authorization header, UIDCookies,
+	// the X-Forwarded-User-Id header and the uid query parameter.
+	// The Sticky cookie is then signed with the Signing keys, which are required.
+	Identity *CanaryIdentity `json:"identity,omitempty" toml:"identity,omitempty" yaml:"identity,omitempty" export:"true"`
+}
+
+// +k8s:deepcopy-gen=true
+
+// RequestID holds the request id middleware configuration.
+// The request id is the one of the request headers, or a generated one when they are not set.
+type RequestID struct {
+	// RequestHeaders are the headers holding the request id of the incoming requests, in order, default to X-Request-Id.
+	// The trace-id of the W3C `traceparent` header is used as its request id.
+	RequestHeaders []string `json:"requestHeaders,omitempty" toml:"requestHeaders,omitempty" yaml:"requestHeaders,omitempty" export:"true"`
+	// HeaderName is the header of the request id forwarded to the services, default to X-Request-Id.
+	HeaderName string `json:"headerName,omitempty" toml:"headerName,omitempty" yaml:"headerName,omitempty" export:"true"`
+	// Format is the format of the generated request ids: `uuid` (UUIDv4, default), `ulid` or `traceid` (W3C trace-id).
+	Format string `json:"format,omitempty" toml:"format,omitempty" yaml:"format,omitempty" export:"true"`
+	// First keeps the request id of the first request header which is set, instead of joining all of them with ", ".
+	First bool `json:"first,omitempty" toml:"first,omitempty" yaml:"first,omitempty" export:"true"`
+	// AddResponseHeader adds the request id to the response headers.
+	AddResponseHeader bool `json:"addResponseHeader,omitempty" toml:"addResponseHeader,omitempty" yaml:"addResponseHeader,omitempty" export:"true"`
+}
+
+// +k8s:deepcopy-gen=true
+
+// CanaryIdentity holds the ordered sources of the user id, the first source which has one wins.
+type CanaryIdentity struct {
+	Sources []CanaryIdentitySource `json:"sources,omitempty" toml:"sources,omitempty" yaml:"sources,omitempty" export:"true"`
+	// JWKSFile is a JSON Web Key Set file, the JWTs of the sources with a Claim must be signed by one of its keys when it is set.
+	JWKSFile string `json:"jwksFile,omitempty" toml:"jwksFile,omitempty" yaml:"jwksFile,omitempty" export:"true"`
+}
+
+// +k8s:deepcopy-gen=true
+
+// CanaryIdentitySource is a header, a cookie or a query parameter holding the user id,
+// or holding a JWT with the user id when Claim is set.
+type CanaryIdentitySource struct {
+	Header string `json:"header,omitempty" toml:"header,omitempty" yaml:"header,omitempty" export:"true"`
+	Cookie string `json:"cookie,omitempty" toml:"cookie,omitempty" yaml:"cookie,omitempty" export:"true"`
+	Query  string `json:"query,omitempty" toml:"query,omitempty" yaml:"query,omitempty" export:"true"`
+	// Claim is the path of the user id in the claims of the JWT, ex. `user.id`.
+	Claim string `json:"claim,omitempty" toml:"claim,omitempty" yaml:"claim,omitempty" export:"true"`
+}
+
+// +k8s:deepcopy-gen=true
+
+// CanarySigning holds the HMAC-SHA256 keys of the X-Canary header signature.
+// The first key signs, all the keys verify, so that a new key can be rolled out to the internal gateways
+// before the public gateways sign with it.
+type CanarySigning struct {
+	Keys []CanarySigningKey `json:"keys,omitempty" toml:"keys,omitempty" yaml:"keys,omitempty" export:"true"`
+	// MaxAge is how long a signed X-Canary header is accepted after it was signed,
+	// including the clock skew between the gateways, default to 1m.
+	MaxAge ptypes.Duration `json:"maxAge,omitempty" toml:"maxAge,omitempty" yaml:"maxAge,omitempty" export:"true"`
+}
+
+// +k8s:deepcopy-gen=true
+
+// CanarySigningKey is a signing key of the X-Canary header.
+type CanarySigningKey struct {
+	// ID is sent with the signature to select the verifying key.
+	ID     string `json:"id,omitempty" toml:"id,omitempty" yaml:"id,omitempty" export:"true"`
+	Secret string `json:"secret,omitempty" toml:"secret,omitempty" yaml:"secret,omitempty"`
+}
+
+// +k8s:deepcopy-gen=true
+
+// CanaryWarmup persists the recently seen uids to a file,
+// and prefetches their labels with the batch endpoint of the label server.
+type CanaryWarmup struct {
+	// File is where the recently seen uids are persisted.
+	File string `json:"file,omitempty" toml:"file,omitempty" yaml:"file,omitempty" export:"true"`
+	// BatchURL is the batch endpoint of the label server, "%s" is replaced by the product,
+	// default to "{server}/users/labels:batch?product=%s" when Server is not a URL template.
+	BatchURL string `json:"batchURL,omitempty" toml:"batchURL,omitempty" yaml:"batchURL,omitempty" export:"true"`
+	// BatchSize is the max number of uids of a batch request, default to 100.
+	BatchSize int `json:"batchSize,omitempty" toml:"batchSize,omitempty" yaml:"batchSize,omitempty" export:"true"`
+	// MaxUIDs is the max number of uids persisted, default to 10000.
+	MaxUIDs int `json:"maxUIDs,omitempty" toml:"maxUIDs,omitempty" yaml:"maxUIDs,omitempty" export:"true"`
+	// PersistInterval is the interval of persisting the uids, default to 1m.
+	PersistInterval ptypes.Duration `json:"persistInterval,omitempty" toml:"persistInterval,omitempty" yaml:"persistInterval,omitempty" export:"true"`
+}
+
+// +k8s:deepcopy-gen=true
+
+// LabelServerOptions holds the client and circuit breaker options of the canary label servers.
+// Each label server of a middleware has its own circuit breaker.
+type LabelServerOptions struct {
+	// Timeout is the timeout of a label request, default to 1s.
+	Timeout ptypes.Duration `json:"timeout,omitempty" toml:"timeout,omitempty" yaml:"timeout,omitempty" export:"true"`
+	// DialTimeout is the timeout of establishing a connection to the label server, default to 5s.
+	DialTimeout ptypes.Duration `json:"dialTimeout,omitempty" toml:"dialTimeout,omitempty" yaml:"dialTimeout,omitempty" export:"true"`
+	// TLS configures the connection to the label server, the server certificate is verified by default.
+	TLS *ClientTLS `json:"tls,omitempty" toml:"tls,omitempty" yaml:"tls,omitempty" export:"true"`
+	// FailuresThreshold is the number of consecutive failures which opens the circuit breaker, default to 5.
+	FailuresThreshold int `json:"failuresThreshold,omitempty" toml:"failuresThreshold,omitempty" yaml:"failuresThreshold,omitempty" export:"true"`
+	// RetryInterval is how long the circuit breaker stays open before the next try, default to 10s.
+	RetryInterval ptypes.Duration `json:"retryInterval,omitempty" toml:"retryInterval,omitempty" yaml:"retryInterval,omitempty" export:"true"`
 }
 
 // +k8s:deepcopy-gen=true
@@ -533,3 +666,66 @@ type LabelsMap struct {
 	RequestHeaderName string            `json:"requestHeaderName,omitempty" toml:"requestHeaderName,omitempty" yaml:"requestHeaderName,omitempty" export:"true"`
 	Labels            map[string]string `json:"labels,omitempty" toml:"labels,omitempty" yaml:"labels,omitempty" export:"true"`
 }
+
+// +k8s:deepcopy-gen=true
+
+// LabelSource is a source of users' labels for the canary middleware, only one of its fields should be set.
+type LabelSource struct {
+	// Server is the label server, same as Canary.Server.
+	Server string `json:"server,omitempty" toml:"server,omitempty" yaml:"server,omitempty" export:"true"`
+	// File is a JSON file mapping uid to its labels, it is reloaded on change.
+	File string         `json:"file,omitempty" toml:"file,omitempty" yaml:"file,omitempty" export:"true"`
+	KV   *KVLabelSource `json:"kv,omitempty" toml:"kv,omitempty" yaml:"kv,omitempty" export:"true"`
+}
+
+// +k8s:deepcopy-gen=true
+
+// KVLabelSource loads users' labels from a KV store, the value of key `{rootKey}/{product}/{uid}` is a JSON array of labels.
+type KVLabelSource struct {
+	// Backend is one of consul, etcd, redis or zookeeper.
+	Backend   string     `json:"backend,omitempty" toml:"backend,omitempty" yaml:"backend,omitempty" export:"true"`
+	Endpoints []string   `json:"endpoints,omitempty" toml:"endpoints,omitempty" yaml:"endpoints,omitempty"`
+	RootKey   string     `json:"rootKey,omitempty" toml:"rootKey,omitempty" yaml:"rootKey,omitempty" export:"true"`
+	Username  string     `json:"username,omitempty" toml:"username,omitempty" yaml:"username,omitempty"`
+	Password  string     `json:"password,omitempty" toml:"password,omitempty" yaml:"password,omitempty"`
+	TLS       *ClientTLS `json:"tls,omitempty" toml:"tls,omitempty" yaml:"tls,omitempty" export:"true"`
+}
+
+// +k8s:deepcopy-gen=true
+
+// CanaryRule assigns labels to requests matching all of its conditions, empty conditions always match.
+// Rules are evaluated in order and the first matching rule wins.
+type CanaryRule struct {
+	PathPrefix  string            `json:"pathPrefix,omitempty" toml:"pathPrefix,omitempty" yaml:"pathPrefix,omitempty" export:"true"`
+	Host        string            `json:"host,omitempty" toml:"host,omitempty" yaml:"host,omitempty" export:"true"`
+	Query       map[string]string `json:"query,omitempty" toml:"query,omitempty" yaml:"query,omitempty" export:"true"`
+	Cookies     map[string]string `json:"cookies,omitempty" toml:"cookies,omitempty" yaml:"cookies,omitempty" export:"true"`
+	Headers     map[string]string `json:"headers,omitempty" toml:"headers,omitempty" yaml:"headers,omitempty" export:"true"`
+	SourceRange []string          `json:"sourceRange,omitempty" toml:"sourceRange,omitempty" yaml:"sourceRange,omitempty"`
+	IPStrategy  *IPStrategy       `json:"ipStrategy,omitempty" toml:"ipStrategy,omitempty" yaml:"ipStrategy,omitempty" label:"allowEmpty" file:"allowEmpty" export:"true"`
+	Client      string            `json:"client,omitempty" toml:"client,omitempty" yaml:"client,omitempty" export:"true"`
+	Channel     string            `json:"channel,omitempty" toml:"channel,omitempty" yaml:"channel,omitempty" export:"true"`
+	App         string            `json:"app,omitempty" toml:"app,omitempty" yaml:"app,omitempty" export:"true"`
+	// Version is a version constraint, ex. ">=10.2" or ">=10.0.0 <11", a bare version means equality.
+	Version string `json:"version,omitempty" toml:"version,omitempty" yaml:"version,omitempty" export:"true"`
+	// Labels is a comma separated list fed into the X-Canary header, ex. "beta,nofallback".
+	Labels string `json:"labels,omitempty" toml:"labels,omitempty" yaml:"labels,omitempty" export:"true"`
+}
+
+// +k8s:deepcopy-gen=true
+
+// CanaryRollout assigns labels to a percentage of users by stable hashing of their UID.
+type CanaryRollout struct {
+	// Seed is mixed into the hash, defaults to the product name.
+	// Changing it reshuffles the users selected by the rollout.
+	Seed   string         `json:"seed,omitempty" toml:"seed,omitempty" yaml:"seed,omitempty" export:"true"`
+	Labels []RolloutLabel `json:"labels,omitempty" toml:"labels,omitempty" yaml:"labels,omitempty" export:"true"`
+}
+
+// +k8s:deepcopy-gen=true
+
+// RolloutLabel is a label assigned to Percent percent of users.
+type RolloutLabel struct {
+	Label   string `json:"label,omitempty" toml:"label,omitempty" yaml:"label,omitempty" export:"true"`
+	Percent int    `json:"percent,omitempty" toml:"percent,omitempty" yaml:"percent,omitempty" export:"true"`
+}
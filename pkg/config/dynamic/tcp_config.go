@@ -21,6 +21,7 @@ type TCPConfiguration struct {
 type TCPService struct {
 	LoadBalancer *TCPServersLoadBalancer `json:"loadBalancer,omitempty" toml:"loadBalancer,omitempty" yaml:"loadBalancer,omitempty" export:"true"`
 	Weighted     *TCPWeightedRoundRobin  `json:"weighted,omitempty" toml:"weighted,omitempty" yaml:"weighted,omitempty" label:"-" export:"true"`
+	Labeled      *TCPLabeledRoundRobin   `json:"labeled,omitempty" toml:"labeled,omitempty" yaml:"labeled,omitempty" label:"-" export:"true"`
 }
 
 // +k8s:deepcopy-gen=true
@@ -38,6 +39,32 @@ type TCPWRRService struct {
 	Weight *int   `json:"weight,omitempty" toml:"weight,omitempty" yaml:"weight,omitempty" export:"true"`
 }
 
+// +k8s:deepcopy-gen=true
+
+// TCPLabeledRoundRobin is a labeled tcp load-balancer of services, which selects the service by the label of the connection.
+// The label is taken, in this order, from the PROXY protocol v2 TLV of type ProxyProtocolTLV,
+// from the first label of the SNI when SNIPrefix is enabled (Ex. "beta" for "beta.example.com"),
+// then from the first source range containing the client IP.
+// Connections without label, or whose label is not mapped to a service, are forwarded to Default.
+type TCPLabeledRoundRobin struct {
+	Default string `json:"default,omitempty" toml:"default,omitempty" yaml:"default,omitempty" export:"true"`
+	// Labels maps labels to services.
+	Labels       map[string]string    `json:"labels,omitempty" toml:"labels,omitempty" yaml:"labels,omitempty" export:"true"`
+	SNIPrefix    bool                 `json:"sniPrefix,omitempty" toml:"sniPrefix,omitempty" yaml:"sniPrefix,omitempty" export:"true"`
+	SourceRanges []LabeledSourceRange `json:"sourceRanges,omitempty" toml:"sourceRanges,omitempty" yaml:"sourceRanges,omitempty" export:"true"`
+	// ProxyProtocolTLV is the type of the PROXY protocol v2 TLV holding the label,
+	// it should be an application specific type, from 0xE0 to 0xEF. It is disabled when 0.
+	ProxyProtocolTLV int `json:"proxyProtocolTLV,omitempty" toml:"proxyProtocolTLV,omitempty" yaml:"proxyProtocolTLV,omitempty" export:"true"`
+}
+
+// +k8s:deepcopy-gen=true
+
+// LabeledSourceRange labels the connections whose client IP is in SourceRange.
+type LabeledSourceRange struct {
+	Label       string   `json:"label,omitempty" toml:"label,omitempty" yaml:"label,omitempty" export:"true"`
+	SourceRange []string `json:"sourceRange,omitempty" toml:"sourceRange,omitempty" yaml:"sourceRange,omitempty" export:"true"`
+}
+
 // SetDefaults Default values for a TCPWRRService.
 func (w *TCPWRRService) SetDefaults() {
 	defaultWeight := 1
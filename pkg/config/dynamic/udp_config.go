@@ -18,6 +18,7 @@ type UDPConfiguration struct {
 type UDPService struct {
 	LoadBalancer *UDPServersLoadBalancer `json:"loadBalancer,omitempty" toml:"loadBalancer,omitempty" yaml:"loadBalancer,omitempty" export:"true"`
 	Weighted     *UDPWeightedRoundRobin  `json:"weighted,omitempty" toml:"weighted,omitempty" yaml:"weighted,omitempty" label:"-" export:"true"`
+	Labeled      *UDPLabeledRoundRobin   `json:"labeled,omitempty" toml:"labeled,omitempty" yaml:"labeled,omitempty" label:"-" export:"true"`
 }
 
 // +k8s:deepcopy-gen=true
@@ -35,6 +36,18 @@ type UDPWRRService struct {
 	Weight *int   `json:"weight,omitempty" toml:"weight,omitempty" yaml:"weight,omitempty" export:"true"`
 }
 
+// +k8s:deepcopy-gen=true
+
+// UDPLabeledRoundRobin is a labeled UDP load-balancer of services, which selects the service by the label of the session.
+// The label is taken from the first source range containing the client IP.
+// Sessions without label, or whose label is not mapped to a service, are forwarded to Default.
+type UDPLabeledRoundRobin struct {
+	Default string `json:"default,omitempty" toml:"default,omitempty" yaml:"default,omitempty" export:"true"`
+	// Labels maps labels to services.
+	Labels       map[string]string    `json:"labels,omitempty" toml:"labels,omitempty" yaml:"labels,omitempty" export:"true"`
+	SourceRanges []LabeledSourceRange `json:"sourceRanges,omitempty" toml:"sourceRanges,omitempty" yaml:"sourceRanges,omitempty" export:"true"`
+}
+
 // SetDefaults sets the default values for a UDPWRRService.
 func (w *UDPWRRService) SetDefaults() {
 	defaultWeight := 1
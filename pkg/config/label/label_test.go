@@ -153,6 +153,9 @@ func TestDecodeConfiguration(t *testing.T) {
 		"traefik.http.services.Service0.loadbalancer.responseforwarding.flushinterval": "foobar",
 		"traefik.http.services.Service0.loadbalancer.server.scheme":                    "foobar",
 		"traefik.http.services.Service0.loadbalancer.server.port":                      "8080",
+		"traefik.http.services.Service0.loadbalancer.server.weight":                    "2",
+		"traefik.http.services.Service0.loadbalancer.server.zone":                      "foobar",
+		"traefik.http.services.Service0.loadbalancer.server.labels.name0":              "foobar",
 		"traefik.http.services.Service0.loadbalancer.sticky.cookie.name":               "foobar",
 		"traefik.http.services.Service0.loadbalancer.sticky.cookie.secure":             "true",
 		"traefik.http.services.Service1.loadbalancer.healthcheck.headers.name0":        "foobar",
@@ -623,6 +626,9 @@ func TestDecodeConfiguration(t *testing.T) {
 							{
 								Scheme: "foobar",
 								Port:   "8080",
+								Weight: func(i int) *int { return &i }(2),
+								Zone:   "foobar",
+								Labels: map[string]string{"name0": "foobar"},
 							},
 						},
 						HealthCheck: &dynamic.ServerHealthCheck{
@@ -1098,6 +1104,9 @@ func TestEncodeConfiguration(t *testing.T) {
 							{
 								Scheme: "foobar",
 								Port:   "8080",
+								Weight: func(i int) *int { return &i }(2),
+								Zone:   "foobar",
+								Labels: map[string]string{"name0": "foobar"},
 							},
 						},
 						HealthCheck: &dynamic.ServerHealthCheck{
@@ -1292,6 +1301,9 @@ func TestEncodeConfiguration(t *testing.T) {
 		"traefik.HTTP.Services.Service0.LoadBalancer.ResponseForwarding.FlushInterval": "foobar",
 		"traefik.HTTP.Services.Service0.LoadBalancer.server.Port":                      "8080",
 		"traefik.HTTP.Services.Service0.LoadBalancer.server.Scheme":                    "foobar",
+		"traefik.HTTP.Services.Service0.LoadBalancer.server.Weight":                    "2",
+		"traefik.HTTP.Services.Service0.LoadBalancer.server.Zone":                      "foobar",
+		"traefik.HTTP.Services.Service0.LoadBalancer.server.Labels.name0":              "foobar",
 		"traefik.HTTP.Services.Service0.LoadBalancer.Sticky.Cookie.Name":               "foobar",
 		"traefik.HTTP.Services.Service0.LoadBalancer.Sticky.Cookie.HTTPOnly":           "true",
 		"traefik.HTTP.Services.Service0.LoadBalancer.Sticky.Cookie.Secure":             "false",
@@ -37,6 +37,26 @@ type Balancer interface {
 	UpsertServer(u *url.URL, options ...roundrobin.ServerOption) error
 }
 
+// serverWeighter is implemented by the balancers which keep the weights of their servers,
+// so that the servers are restored with their weight once they are healthy again.
+type serverWeighter interface {
+	ServerWeight(u *url.URL) (int, bool)
+}
+
+// weightedUpserter is implemented by the balancers which take the weights of their servers as is,
+// rather than as options of the servers of oxy.
+type weightedUpserter interface {
+	UpsertWeightedServer(u *url.URL, weight int) error
+}
+
+// UpsertWeightedServer adds or updates the server of u in lb with weight.
+func UpsertWeightedServer(lb Balancer, u *url.URL, weight int) error {
+	if wu, ok := lb.(weightedUpserter); ok {
+		return wu.UpsertWeightedServer(u, weight)
+	}
+	return lb.UpsertServer(u, roundrobin.Weight(weight))
+}
+
 // BalancerHandler includes functionality for load-balancing management.
 type BalancerHandler interface {
 	ServeHTTP(w http.ResponseWriter, req *http.Request)
@@ -81,9 +101,23 @@ type backendURL struct {
 type BackendConfig struct {
 	Options
 	name         string
+	disabledMu   sync.RWMutex // guards disabledURLs, which the outlier detection of the backend reads
 	disabledURLs []backendURL
 }
 
+// isDisabled returns whether the server u is removed from the load-balancer by the health check.
+func (b *BackendConfig) isDisabled(u *url.URL) bool {
+	b.disabledMu.RLock()
+	defer b.disabledMu.RUnlock()
+
+	for _, disabled := range b.disabledURLs {
+		if disabled.url.String() == u.String() {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *BackendConfig) newRequest(serverURL *url.URL) (*http.Request, error) {
 	u, err := serverURL.Parse(b.Path)
 	if err != nil {
@@ -169,7 +203,7 @@ func (hc *HealthCheck) checkServersLB(ctx context.Context, backend *BackendConfi
 		if err := checkHealth(disabledURL.url, backend); err == nil {
 			logger.Warnf("Health check up: returning to server list. Backend: %q URL: %q Weight: %d",
 				backend.name, disabledURL.url.String(), disabledURL.weight)
-			if err = backend.LB.UpsertServer(disabledURL.url, roundrobin.Weight(disabledURL.weight)); err != nil {
+			if err = UpsertWeightedServer(backend.LB, disabledURL.url, disabledURL.weight); err != nil {
 				logger.Error(err)
 			}
 			serverUpMetricValue = 1
@@ -182,17 +216,18 @@ func (hc *HealthCheck) checkServersLB(ctx context.Context, backend *BackendConfi
 		hc.metrics.serverUpGauge.With(labelValues...).Set(serverUpMetricValue)
 	}
 
+	backend.disabledMu.Lock()
 	backend.disabledURLs = newDisabledURLs
+	backend.disabledMu.Unlock()
 
 	for _, enabledURL := range enabledURLs {
 		serverUpMetricValue := float64(1)
 
 		if err := checkHealth(enabledURL, backend); err != nil {
 			weight := 1
-			rr, ok := backend.LB.(*roundrobin.RoundRobin)
-			if ok {
+			if sw, ok := backend.LB.(serverWeighter); ok {
 				var gotWeight bool
-				weight, gotWeight = rr.ServerWeight(enabledURL)
+				weight, gotWeight = sw.ServerWeight(enabledURL)
 				if !gotWeight {
 					weight = 1
 				}
@@ -204,7 +239,9 @@ func (hc *HealthCheck) checkServersLB(ctx context.Context, backend *BackendConfi
 				logger.Error(err)
 			}
 
+			backend.disabledMu.Lock()
 			backend.disabledURLs = append(backend.disabledURLs, backendURL{enabledURL, weight})
+			backend.disabledMu.Unlock()
 			serverUpMetricValue = 0
 		}
 
@@ -348,9 +385,23 @@ func (lb *LbStatusUpdater) RemoveServer(u *url.URL) error {
 // UpsertServer adds the given server to the BalancerHandler,
 // and updates the status of the server to "UP".
 func (lb *LbStatusUpdater) UpsertServer(u *url.URL, options ...roundrobin.ServerOption) error {
+	return lb.upsertServer(u, func() error {
+		return lb.BalancerHandler.UpsertServer(u, options...)
+	})
+}
+
+// UpsertWeightedServer adds the given server to the BalancerHandler with weight,
+// and updates the status of the server to "UP".
+func (lb *LbStatusUpdater) UpsertWeightedServer(u *url.URL, weight int) error {
+	return lb.upsertServer(u, func() error {
+		return UpsertWeightedServer(lb.BalancerHandler, u, weight)
+	})
+}
+
+func (lb *LbStatusUpdater) upsertServer(u *url.URL, upsert func() error) error {
 	ctx := context.TODO()
 	upBefore := len(lb.BalancerHandler.Servers()) > 0
-	err := lb.BalancerHandler.UpsertServer(u, options...)
+	err := upsert()
 	if err != nil {
 		return err
 	}
@@ -372,9 +423,29 @@ func (lb *LbStatusUpdater) UpsertServer(u *url.URL, options ...roundrobin.Server
 	return nil
 }
 
+// ServerWeight returns the weight of the given server, when the BalancerHandler keeps the weights of its servers.
+func (lb *LbStatusUpdater) ServerWeight(u *url.URL) (int, bool) {
+	if sw, ok := lb.BalancerHandler.(serverWeighter); ok {
+		return sw.ServerWeight(u)
+	}
+	return -1, false
+}
+
 // Balancers is a list of Balancers(s) that implements the Balancer interface.
 type Balancers []Balancer
 
+// ServerWeight returns the weight of the given server in the first Balancer which has it.
+func (b Balancers) ServerWeight(u *url.URL) (int, bool) {
+	for _, lb := range b {
+		if sw, ok := lb.(serverWeighter); ok {
+			if weight, found := sw.ServerWeight(u); found {
+				return weight, true
+			}
+		}
+	}
+	return -1, false
+}
+
 // Servers returns the servers url from all the BalancerHandler.
 func (b Balancers) Servers() []*url.URL {
 	var servers []*url.URL
@@ -406,3 +477,13 @@ func (b Balancers) UpsertServer(u *url.URL, options ...roundrobin.ServerOption)
 	}
 	return nil
 }
+
+// UpsertWeightedServer adds the given server to all the BalancerHandler with weight.
+func (b Balancers) UpsertWeightedServer(u *url.URL, weight int) error {
+	for _, lb := range b {
+		if err := UpsertWeightedServer(lb, u, weight); err != nil {
+			return err
+		}
+	}
+	return nil
+}
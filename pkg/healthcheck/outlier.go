@@ -0,0 +1,381 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	gokitmetrics "github.com/go-kit/kit/metrics"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/config/runtime"
+	"github.com/traefik/traefik/v2/pkg/log"
+	"github.com/traefik/traefik/v2/pkg/safe"
+)
+
+// serverEjected is the status of the servers ejected by an outlier detection.
+const serverEjected = "EJECTED"
+
+// OutlierDetection is the passive health check of the servers of a service:
+// it watches the responses of the servers, and ejects the failing ones from the load-balancers of the service for a while.
+// The servers are identified by their host.
+type OutlierDetection struct {
+	name          string
+	config        dynamic.OutlierDetection
+	info          *runtime.ServiceInfo // can be nil
+	serverUpGauge gokitmetrics.Gauge
+
+	mu      sync.Mutex
+	lb      Balancer
+	backend *BackendConfig           // the active health check of the service, can be nil
+	servers map[string]*outlierStats // keyed by host
+}
+
+// outlierStats holds the responses of a server.
+type outlierStats struct {
+	// requests and errors are counted over the current interval.
+	requests int
+	errors   int
+	// gatewayErrors and connectErrors are the consecutive ones.
+	gatewayErrors int
+	connectErrors int
+	// ejections is the number of consecutive ejections of the server,
+	// it is decreased at each interval the server is not ejected.
+	ejections int
+	ejected   []backendURL
+	until     time.Time
+}
+
+// NewOutlierDetection creates the outlier detection of the service name, the zero values of config are defaulted.
+func NewOutlierDetection(name string, config dynamic.OutlierDetection, info *runtime.ServiceInfo, serverUpGauge gokitmetrics.Gauge) *OutlierDetection {
+	defaults := dynamic.OutlierDetection{}
+	defaults.SetDefaults()
+	if config.ConsecutiveGatewayErrors == 0 {
+		config.ConsecutiveGatewayErrors = defaults.ConsecutiveGatewayErrors
+	}
+	if config.ConsecutiveConnectErrors == 0 {
+		config.ConsecutiveConnectErrors = defaults.ConsecutiveConnectErrors
+	}
+	if config.MaxErrorRatio == 0 {
+		config.MaxErrorRatio = defaults.MaxErrorRatio
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = defaults.MinRequests
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaults.Interval
+	}
+	if config.BaseEjectionTime <= 0 {
+		config.BaseEjectionTime = defaults.BaseEjectionTime
+	}
+	if config.MaxEjectionTime <= 0 {
+		config.MaxEjectionTime = defaults.MaxEjectionTime
+	}
+	if config.MaxEjectionTime < config.BaseEjectionTime {
+		config.MaxEjectionTime = config.BaseEjectionTime
+	}
+	if config.MaxEjectionPercent <= 0 || config.MaxEjectionPercent > 100 {
+		config.MaxEjectionPercent = defaults.MaxEjectionPercent
+	}
+
+	return &OutlierDetection{
+		name:          name,
+		config:        config,
+		info:          info,
+		serverUpGauge: serverUpGauge,
+		servers:       make(map[string]*outlierStats),
+	}
+}
+
+// SetBalancer sets the load-balancers the servers are ejected from.
+func (o *OutlierDetection) SetBalancer(lb Balancer) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.lb = lb
+}
+
+// SetBackendConfig sets the active health check of the service,
+// the servers it removed from the load-balancers are left to it when their ejection is over.
+func (o *OutlierDetection) SetBackendConfig(backend *BackendConfig) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.backend = backend
+}
+
+// RoundTripper returns next, which records the responses of the servers in the outlier detection.
+func (o *OutlierDetection) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	return &outlierRoundTripper{next: next, detection: o}
+}
+
+type outlierRoundTripper struct {
+	next      http.RoundTripper
+	detection *OutlierDetection
+}
+
+func (t *outlierRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	switch {
+	case err == nil:
+		t.detection.observe(req.URL.Host, resp.StatusCode, false)
+	case errors.Is(err, context.Canceled):
+		// the client is gone, the server is not at fault.
+	case isConnectError(err):
+		t.detection.observe(req.URL.Host, http.StatusBadGateway, true)
+	default:
+		// the proxy answers with a 502 or a 504.
+		t.detection.observe(req.URL.Host, http.StatusBadGateway, false)
+	}
+	return resp, err
+}
+
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func isGatewayError(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// observe records a response of the server of host, and ejects the server when it reaches a consecutive errors threshold.
+func (o *OutlierDetection) observe(host string, status int, connectError bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	s, ok := o.servers[host]
+	if !ok {
+		s = &outlierStats{}
+		o.servers[host] = s
+	}
+	if len(s.ejected) > 0 {
+		// a response of a request sent before the ejection.
+		return
+	}
+
+	s.requests++
+	if status >= http.StatusInternalServerError {
+		s.errors++
+	}
+	if isGatewayError(status) {
+		s.gatewayErrors++
+	} else {
+		s.gatewayErrors = 0
+	}
+	if connectError {
+		s.connectErrors++
+	} else {
+		s.connectErrors = 0
+	}
+
+	switch {
+	case o.config.ConsecutiveConnectErrors > 0 && s.connectErrors >= o.config.ConsecutiveConnectErrors:
+		o.eject(host, s, time.Now(), fmt.Sprintf("%d consecutive connection failures", s.connectErrors))
+	case o.config.ConsecutiveGatewayErrors > 0 && s.gatewayErrors >= o.config.ConsecutiveGatewayErrors:
+		o.eject(host, s, time.Now(), fmt.Sprintf("%d consecutive gateway errors", s.gatewayErrors))
+	}
+}
+
+// step returns the servers whose ejection is over, and ejects the servers whose error ratio over the last interval is too high.
+func (o *OutlierDetection) step(now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for host, s := range o.servers {
+		requests, errs := s.requests, s.errors
+		s.requests, s.errors = 0, 0
+
+		if len(s.ejected) > 0 {
+			if now.Before(s.until) {
+				continue
+			}
+			o.restore(host, s)
+			continue
+		}
+
+		if s.ejections > 0 {
+			s.ejections--
+		}
+
+		if o.config.MaxErrorRatio > 0 && requests >= o.config.MinRequests {
+			if ratio := float64(errs) / float64(requests); ratio > o.config.MaxErrorRatio {
+				o.eject(host, s, now, fmt.Sprintf("error ratio %.4f of %d requests", ratio, requests))
+			}
+		}
+	}
+}
+
+// eject removes the servers of host from the load-balancers, unless the maximum of ejected servers is reached.
+// It should be called with the lock held.
+func (o *OutlierDetection) eject(host string, s *outlierStats, now time.Time, reason string) {
+	logger := log.WithoutContext().WithField(log.ServiceName, o.name)
+	s.gatewayErrors, s.connectErrors = 0, 0
+	if o.lb == nil {
+		return
+	}
+
+	seen := make(map[string]bool)
+	var servers []*url.URL
+	for _, u := range o.lb.Servers() {
+		if seen[u.String()] {
+			continue
+		}
+		seen[u.String()] = true
+		if u.Host == host {
+			servers = append(servers, u)
+		}
+	}
+	if len(servers) == 0 {
+		return
+	}
+
+	var ejected int
+	for _, other := range o.servers {
+		ejected += len(other.ejected)
+	}
+	maxEjected := (len(seen) + ejected) * o.config.MaxEjectionPercent / 100
+	if maxEjected < 1 {
+		maxEjected = 1
+	}
+	if ejected >= maxEjected {
+		logger.Warnf("Outlier detection: not ejecting %s, %d servers are ejected already. Reason: %s", host, ejected, reason)
+		return
+	}
+
+	s.ejections++
+	ejectionTime := time.Duration(o.config.BaseEjectionTime)
+	for i := 1; i < s.ejections && ejectionTime < time.Duration(o.config.MaxEjectionTime); i++ {
+		ejectionTime *= 2
+	}
+	if ejectionTime > time.Duration(o.config.MaxEjectionTime) {
+		ejectionTime = time.Duration(o.config.MaxEjectionTime)
+	}
+	s.until = now.Add(ejectionTime)
+
+	for _, u := range servers {
+		weight := 1
+		if sw, ok := o.lb.(serverWeighter); ok {
+			if w, found := sw.ServerWeight(u); found {
+				weight = w
+			}
+		}
+
+		logger.Warnf("Outlier detection: ejecting server for %s. URL: %q Weight: %d Reason: %s", ejectionTime, u.String(), weight, reason)
+		if err := o.lb.RemoveServer(u); err != nil {
+			logger.Error(err)
+			continue
+		}
+		if o.info != nil {
+			o.info.UpdateServerStatus(u.String(), serverEjected)
+		}
+		o.serverUpGauge.With("service", o.name, "url", u.String()).Set(0)
+		s.ejected = append(s.ejected, backendURL{url: u, weight: weight})
+	}
+}
+
+// restore returns the ejected servers of host to the load-balancers,
+// except the ones the active health check removed meanwhile: it returns them once they are healthy.
+// It should be called with the lock held.
+func (o *OutlierDetection) restore(host string, s *outlierStats) {
+	logger := log.WithoutContext().WithField(log.ServiceName, o.name)
+
+	for _, ejected := range s.ejected {
+		if o.backend != nil && o.backend.isDisabled(ejected.url) {
+			logger.Debugf("Outlier detection: not returning server to server list, the health check is failing. URL: %q", ejected.url.String())
+			continue
+		}
+
+		logger.Warnf("Outlier detection: returning server to server list. URL: %q Weight: %d", ejected.url.String(), ejected.weight)
+		if err := UpsertWeightedServer(o.lb, ejected.url, ejected.weight); err != nil {
+			logger.Error(err)
+			continue
+		}
+		o.serverUpGauge.With("service", o.name, "url", ejected.url.String()).Set(1)
+	}
+	s.ejected = nil
+	logger.Debugf("Outlier detection: %s returned after %d consecutive ejections", host, s.ejections)
+}
+
+// resume ejects from the new load-balancers the servers which are still ejected by prev, when it has the same configuration.
+func (o *OutlierDetection) resume(prev *OutlierDetection, now time.Time) {
+	prev.mu.Lock()
+	defer prev.mu.Unlock()
+	if prev.config != o.config {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.lb == nil {
+		return
+	}
+
+	for host, ps := range prev.servers {
+		s := &outlierStats{ejections: ps.ejections, until: ps.until}
+		o.servers[host] = s
+		if len(ps.ejected) == 0 || !now.Before(ps.until) {
+			continue
+		}
+
+		for _, ejected := range ps.ejected {
+			if err := o.lb.RemoveServer(ejected.url); err != nil {
+				// the server is not in the new configuration.
+				continue
+			}
+			if o.info != nil {
+				o.info.UpdateServerStatus(ejected.url.String(), serverEjected)
+			}
+			o.serverUpGauge.With("service", o.name, "url", ejected.url.String()).Set(0)
+			s.ejected = append(s.ejected, ejected)
+		}
+	}
+}
+
+func (o *OutlierDetection) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(o.config.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			o.step(now)
+		}
+	}
+}
+
+var (
+	outlierDetectionsMu     sync.Mutex
+	outlierDetections       = make(map[string]*OutlierDetection)
+	outlierDetectionsCancel context.CancelFunc
+)
+
+// LaunchOutlierDetections stops the running outlier detections and starts the given ones, keyed by service name.
+// The servers ejected by the running outlier detection of a service stay ejected when its configuration is the same.
+func LaunchOutlierDetections(parentCtx context.Context, next map[string]*OutlierDetection) {
+	outlierDetectionsMu.Lock()
+	defer outlierDetectionsMu.Unlock()
+
+	if outlierDetectionsCancel != nil {
+		outlierDetectionsCancel()
+	}
+	ctx, cancel := context.WithCancel(parentCtx)
+	outlierDetectionsCancel = cancel
+
+	now := time.Now()
+	for name, o := range next {
+		if prev, ok := outlierDetections[name]; ok {
+			o.resume(prev, now)
+		}
+
+		current := o
+		safe.Go(func() {
+			current.run(ctx)
+		})
+	}
+	outlierDetections = next
+}
@@ -0,0 +1,258 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ptypes "github.com/traefik/paerser/types"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/config/runtime"
+	"github.com/traefik/traefik/v2/pkg/testhelpers"
+)
+
+// response is the outcome of a request to a server: a status code, or an error.
+type response struct {
+	status int
+	err    error
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func newOutlierDetection(t *testing.T, config dynamic.OutlierDetection, servers ...string) (*OutlierDetection, *testLoadBalancer, *runtime.ServiceInfo, *testhelpers.CollectingGauge) {
+	t.Helper()
+
+	lb := &testLoadBalancer{RWMutex: &sync.RWMutex{}}
+	info := &runtime.ServiceInfo{}
+	lbsu := NewLBStatusUpdater(lb, info, nil)
+	for _, server := range servers {
+		require.NoError(t, lbsu.UpsertServer(testhelpers.MustParseURL(server)))
+	}
+
+	gauge := &testhelpers.CollectingGauge{}
+	o := NewOutlierDetection("foobar", config, info, gauge)
+	o.SetBalancer(Balancers{lbsu})
+	return o, lb, info, gauge
+}
+
+func serve(t *testing.T, o *OutlierDetection, host string, responses ...response) {
+	t.Helper()
+
+	for _, r := range responses {
+		r := r
+		rt := o.RoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if r.err != nil {
+				return nil, r.err
+			}
+			return &http.Response{StatusCode: r.status}, nil
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		_, _ = rt.RoundTrip(req)
+	}
+}
+
+func repeat(r response, n int) []response {
+	responses := make([]response, n)
+	for i := range responses {
+		responses[i] = r
+	}
+	return responses
+}
+
+func TestOutlierDetectionEjection(t *testing.T) {
+	connectErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	timeoutErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("i/o timeout")}
+
+	testCases := []struct {
+		desc            string
+		config          dynamic.OutlierDetection
+		responses       []response
+		step            bool
+		expectedEjected bool
+	}{
+		{
+			desc:            "consecutive gateway errors",
+			responses:       repeat(response{status: http.StatusBadGateway}, 5),
+			expectedEjected: true,
+		},
+		{
+			desc:      "gateway errors reset by a success",
+			responses: append(repeat(response{status: http.StatusServiceUnavailable}, 4), append([]response{{status: http.StatusOK}}, repeat(response{status: http.StatusGatewayTimeout}, 4)...)...),
+		},
+		{
+			desc:            "consecutive connect errors",
+			responses:       repeat(response{err: connectErr}, 3),
+			expectedEjected: true,
+		},
+		{
+			desc:            "proxy errors are gateway errors",
+			responses:       repeat(response{err: timeoutErr}, 5),
+			expectedEjected: true,
+		},
+		{
+			desc:      "canceled requests are ignored",
+			responses: repeat(response{err: context.Canceled}, 10),
+		},
+		{
+			desc:      "internal server errors are not gateway errors",
+			responses: repeat(response{status: http.StatusInternalServerError}, 10),
+		},
+		{
+			desc:            "error ratio",
+			responses:       append(repeat(response{status: http.StatusInternalServerError}, 11), repeat(response{status: http.StatusOK}, 9)...),
+			step:            true,
+			expectedEjected: true,
+		},
+		{
+			desc:      "error ratio under the threshold",
+			responses: append(repeat(response{status: http.StatusInternalServerError}, 10), repeat(response{status: http.StatusOK}, 10)...),
+			step:      true,
+		},
+		{
+			desc:      "error ratio under the min requests",
+			responses: repeat(response{status: http.StatusInternalServerError}, 19),
+			step:      true,
+		},
+		{
+			desc:      "disabled consecutive gateway errors",
+			config:    dynamic.OutlierDetection{ConsecutiveGatewayErrors: -1},
+			responses: repeat(response{status: http.StatusBadGateway}, 10),
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			o, lb, info, gauge := newOutlierDetection(t, test.config, "http://foo", "http://bar")
+
+			serve(t, o, "foo", test.responses...)
+			if test.step {
+				o.step(time.Now())
+			}
+
+			lb.Lock()
+			defer lb.Unlock()
+
+			if !test.expectedEjected {
+				assert.Equal(t, 0, lb.numRemovedServers)
+				assert.Len(t, lb.servers, 2)
+				return
+			}
+			assert.Equal(t, 1, lb.numRemovedServers)
+			assert.Equal(t, []*url.URL{testhelpers.MustParseURL("http://bar")}, lb.servers)
+			assert.Equal(t, serverEjected, info.GetAllStatus()["http://foo"])
+			assert.Equal(t, []string{"service", "foobar", "url", "http://foo"}, gauge.LastLabelValues)
+			assert.Equal(t, float64(0), gauge.GaugeValue)
+		})
+	}
+}
+
+func TestOutlierDetectionMaxEjectionPercent(t *testing.T) {
+	o, lb, _, _ := newOutlierDetection(t, dynamic.OutlierDetection{MaxEjectionPercent: 50}, "http://a", "http://b", "http://c", "http://d")
+
+	for _, host := range []string{"a", "b", "c"} {
+		serve(t, o, host, repeat(response{status: http.StatusBadGateway}, 5)...)
+	}
+
+	// 2 of the 4 servers at most are ejected.
+	assert.Equal(t, 2, lb.numRemovedServers)
+	assert.Equal(t, []*url.URL{testhelpers.MustParseURL("http://c"), testhelpers.MustParseURL("http://d")}, lb.servers)
+}
+
+func TestOutlierDetectionEjectionTime(t *testing.T) {
+	o, lb, info, gauge := newOutlierDetection(t, dynamic.OutlierDetection{
+		BaseEjectionTime: ptypes.Duration(time.Second),
+		MaxEjectionTime:  ptypes.Duration(3 * time.Second),
+	}, "http://foo", "http://bar")
+
+	// the ejection time doubles at each consecutive ejection, up to the max ejection time.
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		now := time.Now()
+		serve(t, o, "foo", repeat(response{status: http.StatusBadGateway}, 5)...)
+		require.Len(t, lb.servers, 1)
+
+		o.step(now.Add(expected - 100*time.Millisecond))
+		require.Len(t, lb.servers, 1, "returned before %s", expected)
+
+		o.step(now.Add(expected + 100*time.Millisecond))
+		require.Len(t, lb.servers, 2, "not returned after %s", expected)
+		assert.Equal(t, serverUp, info.GetAllStatus()["http://foo"])
+		assert.Equal(t, float64(1), gauge.GaugeValue)
+	}
+
+	// the ejection time decreases back at each interval the server is not ejected.
+	o.step(time.Now())
+	o.step(time.Now())
+	serve(t, o, "foo", repeat(response{status: http.StatusBadGateway}, 5)...)
+	assert.Equal(t, 2, o.servers["foo"].ejections)
+}
+
+func TestOutlierDetectionResume(t *testing.T) {
+	prev, _, _, _ := newOutlierDetection(t, dynamic.OutlierDetection{}, "http://foo", "http://bar")
+	serve(t, prev, "foo", repeat(response{status: http.StatusBadGateway}, 5)...)
+
+	// the server stays ejected from the load-balancers of the new configuration.
+	o, lb, info, gauge := newOutlierDetection(t, dynamic.OutlierDetection{}, "http://foo", "http://bar")
+	gauge.GaugeValue = 1
+	o.resume(prev, time.Now())
+	assert.Equal(t, []*url.URL{testhelpers.MustParseURL("http://bar")}, lb.servers)
+	assert.Equal(t, serverEjected, info.GetAllStatus()["http://foo"])
+	assert.Equal(t, []string{"service", "foobar", "url", "http://foo"}, gauge.LastLabelValues)
+	assert.Equal(t, float64(0), gauge.GaugeValue)
+
+	// but not when the configuration changed.
+	o, lb, _, _ = newOutlierDetection(t, dynamic.OutlierDetection{MaxEjectionPercent: 50}, "http://foo", "http://bar")
+	o.resume(prev, time.Now())
+	assert.Len(t, lb.servers, 2)
+}
+
+func TestOutlierDetectionRestoreDisabledServer(t *testing.T) {
+	var o *OutlierDetection
+	var healthy int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&healthy) == 1 {
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+		// the server is ejected while the health check is running.
+		serve(t, o, req.Host, repeat(response{status: http.StatusBadGateway}, 5)...)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer other.Close()
+
+	o, lb, _, _ := newOutlierDetection(t, dynamic.OutlierDetection{}, server.URL, other.URL)
+	backend := NewBackendConfig(Options{Path: "/health", Timeout: time.Second, LB: o.lb}, "foobar")
+	o.SetBackendConfig(backend)
+	hc := HealthCheck{metrics: metricsHealthcheck{serverUpGauge: &testhelpers.CollectingGauge{}}}
+
+	hc.checkServersLB(context.Background(), backend)
+	require.Equal(t, []*url.URL{testhelpers.MustParseURL(other.URL)}, lb.servers)
+
+	// the ejection is over, but the server is still down for the health check.
+	o.step(time.Now().Add(time.Minute))
+	assert.Equal(t, []*url.URL{testhelpers.MustParseURL(other.URL)}, lb.servers)
+
+	// the health check returns the server once.
+	atomic.StoreInt32(&healthy, 1)
+	hc.checkServersLB(context.Background(), backend)
+	o.step(time.Now().Add(time.Minute))
+	assert.Equal(t, []*url.URL{testhelpers.MustParseURL(other.URL), testhelpers.MustParseURL(server.URL)}, lb.servers)
+}
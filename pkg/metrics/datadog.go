@@ -42,6 +42,13 @@ const (
 	ddRetriesTotalName               = "service.retries.total"
 	ddOpenConnsName                  = "service.connections.open"
 	ddServerUpName                   = "service.server.up"
+	ddServiceZoneReqsName            = "service.zone.request.total"
+
+	ddCanaryLabelFetchDurationName = "canary.label.fetch.duration"
+	ddCanaryLabelFetchErrorsName   = "canary.label.fetch.errors.total"
+	ddCanaryCacheHitsName          = "canary.cache.hits.total"
+	ddCanaryCacheMissesName        = "canary.cache.misses.total"
+	ddCanaryBreakerOpenName        = "canary.breaker.open"
 )
 
 // RegisterDatadog registers the metrics pusher if this didn't happen yet and creates a datadog Registry instance.
@@ -56,7 +63,12 @@ func RegisterDatadog(ctx context.Context, config *types.Datadog) Registry {
 		lastConfigReloadSuccessGauge:   datadogClient.NewGauge(ddLastConfigReloadSuccessName),
 		lastConfigReloadFailureGauge:   datadogClient.NewGauge(ddLastConfigReloadFailureName),
 		tlsCertsNotAfterTimestampGauge: datadogClient.NewGauge(ddTLSCertsNotAfterTimestampName),
+		canaryLabelFetchErrorsCounter:  datadogClient.NewCounter(ddCanaryLabelFetchErrorsName, 1.0),
+		canaryCacheHitsCounter:         datadogClient.NewCounter(ddCanaryCacheHitsName, 1.0),
+		canaryCacheMissesCounter:       datadogClient.NewCounter(ddCanaryCacheMissesName, 1.0),
+		canaryBreakerOpenGauge:         datadogClient.NewGauge(ddCanaryBreakerOpenName),
 	}
+	registry.canaryLabelFetchDurationHistogram, _ = NewHistogramWithScale(datadogClient.NewHistogram(ddCanaryLabelFetchDurationName, 1.0), time.Second)
 
 	if config.AddEntryPointsLabels {
 		registry.epEnabled = config.AddEntryPointsLabels
@@ -82,6 +94,7 @@ func RegisterDatadog(ctx context.Context, config *types.Datadog) Registry {
 		registry.serviceRetriesCounter = datadogClient.NewCounter(ddRetriesTotalName, 1.0)
 		registry.serviceOpenConnsGauge = datadogClient.NewGauge(ddOpenConnsName)
 		registry.serviceServerUpGauge = datadogClient.NewGauge(ddServerUpName)
+		registry.serviceZoneReqsCounter = datadogClient.NewCounter(ddServiceZoneReqsName, 1.0)
 	}
 
 	return registry
@@ -49,6 +49,13 @@ const (
 	influxDBServiceRetriesTotalName = "traefik.service.retries.total"
 	influxDBServiceOpenConnsName    = "traefik.service.connections.open"
 	influxDBServiceServerUpName     = "traefik.service.server.up"
+	influxDBServiceZoneReqsName     = "traefik.service.zone.requests.total"
+
+	influxDBCanaryLabelFetchDurationName = "traefik.canary.label.fetch.duration"
+	influxDBCanaryLabelFetchErrorsName   = "traefik.canary.label.fetch.errors.total"
+	influxDBCanaryCacheHitsName          = "traefik.canary.cache.hits.total"
+	influxDBCanaryCacheMissesName        = "traefik.canary.cache.misses.total"
+	influxDBCanaryBreakerOpenName        = "traefik.canary.breaker.open"
 )
 
 const (
@@ -71,7 +78,12 @@ func RegisterInfluxDB(ctx context.Context, config *types.InfluxDB) Registry {
 		lastConfigReloadSuccessGauge:   influxDBClient.NewGauge(influxDBLastConfigReloadSuccessName),
 		lastConfigReloadFailureGauge:   influxDBClient.NewGauge(influxDBLastConfigReloadFailureName),
 		tlsCertsNotAfterTimestampGauge: influxDBClient.NewGauge(influxDBTLSCertsNotAfterTimestampName),
+		canaryLabelFetchErrorsCounter:  influxDBClient.NewCounter(influxDBCanaryLabelFetchErrorsName),
+		canaryCacheHitsCounter:         influxDBClient.NewCounter(influxDBCanaryCacheHitsName),
+		canaryCacheMissesCounter:       influxDBClient.NewCounter(influxDBCanaryCacheMissesName),
+		canaryBreakerOpenGauge:         influxDBClient.NewGauge(influxDBCanaryBreakerOpenName),
 	}
+	registry.canaryLabelFetchDurationHistogram, _ = NewHistogramWithScale(influxDBClient.NewHistogram(influxDBCanaryLabelFetchDurationName), time.Second)
 
 	if config.AddEntryPointsLabels {
 		registry.epEnabled = config.AddEntryPointsLabels
@@ -97,6 +109,7 @@ func RegisterInfluxDB(ctx context.Context, config *types.InfluxDB) Registry {
 		registry.serviceRetriesCounter = influxDBClient.NewCounter(influxDBServiceRetriesTotalName)
 		registry.serviceOpenConnsGauge = influxDBClient.NewGauge(influxDBServiceOpenConnsName)
 		registry.serviceServerUpGauge = influxDBClient.NewGauge(influxDBServiceServerUpName)
+		registry.serviceZoneReqsCounter = influxDBClient.NewCounter(influxDBServiceZoneReqsName)
 	}
 
 	return registry
@@ -16,6 +16,8 @@ type Registry interface {
 	IsRouterEnabled() bool
 	// IsSvcEnabled shows whether metrics instrumentation is enabled on services.
 	IsSvcEnabled() bool
+	// IsSvcCanaryEnabled shows whether the canary label is added to the requests metrics of services.
+	IsSvcCanaryEnabled() bool
 
 	// server metrics
 	ConfigReloadsCounter() metrics.Counter
@@ -45,6 +47,14 @@ type Registry interface {
 	ServiceOpenConnsGauge() metrics.Gauge
 	ServiceRetriesCounter() metrics.Counter
 	ServiceServerUpGauge() metrics.Gauge
+	ServiceZoneReqsCounter() metrics.Counter
+
+	// canary middleware metrics
+	CanaryLabelFetchDurationHistogram() ScalableHistogram
+	CanaryLabelFetchErrorsCounter() metrics.Counter
+	CanaryCacheHitsCounter() metrics.Counter
+	CanaryCacheMissesCounter() metrics.Counter
+	CanaryBreakerOpenGauge() metrics.Gauge
 }
 
 // NewVoidRegistry is a noop implementation of metrics.Registry.
@@ -76,8 +86,17 @@ func NewMultiRegistry(registries []Registry) Registry {
 	var serviceOpenConnsGauge []metrics.Gauge
 	var serviceRetriesCounter []metrics.Counter
 	var serviceServerUpGauge []metrics.Gauge
+	var serviceZoneReqsCounter []metrics.Counter
+	var canaryLabelFetchDurationHistogram []ScalableHistogram
+	var canaryLabelFetchErrorsCounter []metrics.Counter
+	var canaryCacheHitsCounter []metrics.Counter
+	var canaryCacheMissesCounter []metrics.Counter
+	var canaryBreakerOpenGauge []metrics.Gauge
+	var svcCanaryEnabled bool
 
 	for _, r := range registries {
+		// the canary label is added to all the registries as soon as one of them enables it.
+		svcCanaryEnabled = svcCanaryEnabled || r.IsSvcCanaryEnabled()
 		if r.ConfigReloadsCounter() != nil {
 			configReloadsCounter = append(configReloadsCounter, r.ConfigReloadsCounter())
 		}
@@ -135,57 +154,89 @@ func NewMultiRegistry(registries []Registry) Registry {
 		if r.ServiceServerUpGauge() != nil {
 			serviceServerUpGauge = append(serviceServerUpGauge, r.ServiceServerUpGauge())
 		}
+		if r.ServiceZoneReqsCounter() != nil {
+			serviceZoneReqsCounter = append(serviceZoneReqsCounter, r.ServiceZoneReqsCounter())
+		}
+		if r.CanaryLabelFetchDurationHistogram() != nil {
+			canaryLabelFetchDurationHistogram = append(canaryLabelFetchDurationHistogram, r.CanaryLabelFetchDurationHistogram())
+		}
+		if r.CanaryLabelFetchErrorsCounter() != nil {
+			canaryLabelFetchErrorsCounter = append(canaryLabelFetchErrorsCounter, r.CanaryLabelFetchErrorsCounter())
+		}
+		if r.CanaryCacheHitsCounter() != nil {
+			canaryCacheHitsCounter = append(canaryCacheHitsCounter, r.CanaryCacheHitsCounter())
+		}
+		if r.CanaryCacheMissesCounter() != nil {
+			canaryCacheMissesCounter = append(canaryCacheMissesCounter, r.CanaryCacheMissesCounter())
+		}
+		if r.CanaryBreakerOpenGauge() != nil {
+			canaryBreakerOpenGauge = append(canaryBreakerOpenGauge, r.CanaryBreakerOpenGauge())
+		}
 	}
 
 	return &standardRegistry{
-		epEnabled:                      len(entryPointReqsCounter) > 0 || len(entryPointReqDurationHistogram) > 0 || len(entryPointOpenConnsGauge) > 0,
-		svcEnabled:                     len(serviceReqsCounter) > 0 || len(serviceReqDurationHistogram) > 0 || len(serviceOpenConnsGauge) > 0 || len(serviceRetriesCounter) > 0 || len(serviceServerUpGauge) > 0,
-		routerEnabled:                  len(routerReqsCounter) > 0 || len(routerReqDurationHistogram) > 0 || len(routerOpenConnsGauge) > 0,
-		configReloadsCounter:           multi.NewCounter(configReloadsCounter...),
-		configReloadsFailureCounter:    multi.NewCounter(configReloadsFailureCounter...),
-		lastConfigReloadSuccessGauge:   multi.NewGauge(lastConfigReloadSuccessGauge...),
-		lastConfigReloadFailureGauge:   multi.NewGauge(lastConfigReloadFailureGauge...),
-		tlsCertsNotAfterTimestampGauge: multi.NewGauge(tlsCertsNotAfterTimestampGauge...),
-		entryPointReqsCounter:          multi.NewCounter(entryPointReqsCounter...),
-		entryPointReqsTLSCounter:       multi.NewCounter(entryPointReqsTLSCounter...),
-		entryPointReqDurationHistogram: NewMultiHistogram(entryPointReqDurationHistogram...),
-		entryPointOpenConnsGauge:       multi.NewGauge(entryPointOpenConnsGauge...),
-		routerReqsCounter:              multi.NewCounter(routerReqsCounter...),
-		routerReqsTLSCounter:           multi.NewCounter(routerReqsTLSCounter...),
-		routerReqDurationHistogram:     NewMultiHistogram(routerReqDurationHistogram...),
-		routerOpenConnsGauge:           multi.NewGauge(routerOpenConnsGauge...),
-		serviceReqsCounter:             multi.NewCounter(serviceReqsCounter...),
-		serviceReqsTLSCounter:          multi.NewCounter(serviceReqsTLSCounter...),
-		serviceReqDurationHistogram:    NewMultiHistogram(serviceReqDurationHistogram...),
-		serviceOpenConnsGauge:          multi.NewGauge(serviceOpenConnsGauge...),
-		serviceRetriesCounter:          multi.NewCounter(serviceRetriesCounter...),
-		serviceServerUpGauge:           multi.NewGauge(serviceServerUpGauge...),
+		epEnabled:                         len(entryPointReqsCounter) > 0 || len(entryPointReqDurationHistogram) > 0 || len(entryPointOpenConnsGauge) > 0,
+		svcEnabled:                        len(serviceReqsCounter) > 0 || len(serviceReqDurationHistogram) > 0 || len(serviceOpenConnsGauge) > 0 || len(serviceRetriesCounter) > 0 || len(serviceServerUpGauge) > 0,
+		svcCanaryEnabled:                  svcCanaryEnabled,
+		routerEnabled:                     len(routerReqsCounter) > 0 || len(routerReqDurationHistogram) > 0 || len(routerOpenConnsGauge) > 0,
+		configReloadsCounter:              multi.NewCounter(configReloadsCounter...),
+		configReloadsFailureCounter:       multi.NewCounter(configReloadsFailureCounter...),
+		lastConfigReloadSuccessGauge:      multi.NewGauge(lastConfigReloadSuccessGauge...),
+		lastConfigReloadFailureGauge:      multi.NewGauge(lastConfigReloadFailureGauge...),
+		tlsCertsNotAfterTimestampGauge:    multi.NewGauge(tlsCertsNotAfterTimestampGauge...),
+		entryPointReqsCounter:             multi.NewCounter(entryPointReqsCounter...),
+		entryPointReqsTLSCounter:          multi.NewCounter(entryPointReqsTLSCounter...),
+		entryPointReqDurationHistogram:    NewMultiHistogram(entryPointReqDurationHistogram...),
+		entryPointOpenConnsGauge:          multi.NewGauge(entryPointOpenConnsGauge...),
+		routerReqsCounter:                 multi.NewCounter(routerReqsCounter...),
+		routerReqsTLSCounter:              multi.NewCounter(routerReqsTLSCounter...),
+		routerReqDurationHistogram:        NewMultiHistogram(routerReqDurationHistogram...),
+		routerOpenConnsGauge:              multi.NewGauge(routerOpenConnsGauge...),
+		serviceReqsCounter:                multi.NewCounter(serviceReqsCounter...),
+		serviceReqsTLSCounter:             multi.NewCounter(serviceReqsTLSCounter...),
+		serviceReqDurationHistogram:       NewMultiHistogram(serviceReqDurationHistogram...),
+		serviceOpenConnsGauge:             multi.NewGauge(serviceOpenConnsGauge...),
+		serviceRetriesCounter:             multi.NewCounter(serviceRetriesCounter...),
+		serviceServerUpGauge:              multi.NewGauge(serviceServerUpGauge...),
+		serviceZoneReqsCounter:            multi.NewCounter(serviceZoneReqsCounter...),
+		canaryLabelFetchDurationHistogram: NewMultiHistogram(canaryLabelFetchDurationHistogram...),
+		canaryLabelFetchErrorsCounter:     multi.NewCounter(canaryLabelFetchErrorsCounter...),
+		canaryCacheHitsCounter:            multi.NewCounter(canaryCacheHitsCounter...),
+		canaryCacheMissesCounter:          multi.NewCounter(canaryCacheMissesCounter...),
+		canaryBreakerOpenGauge:            multi.NewGauge(canaryBreakerOpenGauge...),
 	}
 }
 
 type standardRegistry struct {
-	epEnabled                      bool
-	routerEnabled                  bool
-	svcEnabled                     bool
-	configReloadsCounter           metrics.Counter
-	configReloadsFailureCounter    metrics.Counter
-	lastConfigReloadSuccessGauge   metrics.Gauge
-	lastConfigReloadFailureGauge   metrics.Gauge
-	tlsCertsNotAfterTimestampGauge metrics.Gauge
-	entryPointReqsCounter          metrics.Counter
-	entryPointReqsTLSCounter       metrics.Counter
-	entryPointReqDurationHistogram ScalableHistogram
-	entryPointOpenConnsGauge       metrics.Gauge
-	routerReqsCounter              metrics.Counter
-	routerReqsTLSCounter           metrics.Counter
-	routerReqDurationHistogram     ScalableHistogram
-	routerOpenConnsGauge           metrics.Gauge
-	serviceReqsCounter             metrics.Counter
-	serviceReqsTLSCounter          metrics.Counter
-	serviceReqDurationHistogram    ScalableHistogram
-	serviceOpenConnsGauge          metrics.Gauge
-	serviceRetriesCounter          metrics.Counter
-	serviceServerUpGauge           metrics.Gauge
+	epEnabled                         bool
+	routerEnabled                     bool
+	svcEnabled                        bool
+	svcCanaryEnabled                  bool
+	configReloadsCounter              metrics.Counter
+	configReloadsFailureCounter       metrics.Counter
+	lastConfigReloadSuccessGauge      metrics.Gauge
+	lastConfigReloadFailureGauge      metrics.Gauge
+	tlsCertsNotAfterTimestampGauge    metrics.Gauge
+	entryPointReqsCounter             metrics.Counter
+	entryPointReqsTLSCounter          metrics.Counter
+	entryPointReqDurationHistogram    ScalableHistogram
+	entryPointOpenConnsGauge          metrics.Gauge
+	routerReqsCounter                 metrics.Counter
+	routerReqsTLSCounter              metrics.Counter
+	routerReqDurationHistogram        ScalableHistogram
+	routerOpenConnsGauge              metrics.Gauge
+	serviceReqsCounter                metrics.Counter
+	serviceReqsTLSCounter             metrics.Counter
+	serviceReqDurationHistogram       ScalableHistogram
+	serviceOpenConnsGauge             metrics.Gauge
+	serviceRetriesCounter             metrics.Counter
+	serviceServerUpGauge              metrics.Gauge
+	serviceZoneReqsCounter            metrics.Counter
+	canaryLabelFetchDurationHistogram ScalableHistogram
+	canaryLabelFetchErrorsCounter     metrics.Counter
+	canaryCacheHitsCounter            metrics.Counter
+	canaryCacheMissesCounter          metrics.Counter
+	canaryBreakerOpenGauge            metrics.Gauge
 }
 
 func (r *standardRegistry) IsEpEnabled() bool {
@@ -200,6 +251,10 @@ func (r *standardRegistry) IsSvcEnabled() bool {
 	return r.svcEnabled
 }
 
+func (r *standardRegistry) IsSvcCanaryEnabled() bool {
+	return r.svcCanaryEnabled
+}
+
 func (r *standardRegistry) ConfigReloadsCounter() metrics.Counter {
 	return r.configReloadsCounter
 }
@@ -276,6 +331,30 @@ func (r *standardRegistry) ServiceServerUpGauge() metrics.Gauge {
 	return r.serviceServerUpGauge
 }
 
+func (r *standardRegistry) ServiceZoneReqsCounter() metrics.Counter {
+	return r.serviceZoneReqsCounter
+}
+
+func (r *standardRegistry) CanaryLabelFetchDurationHistogram() ScalableHistogram {
+	return r.canaryLabelFetchDurationHistogram
+}
+
+func (r *standardRegistry) CanaryLabelFetchErrorsCounter() metrics.Counter {
+	return r.canaryLabelFetchErrorsCounter
+}
+
+func (r *standardRegistry) CanaryCacheHitsCounter() metrics.Counter {
+	return r.canaryCacheHitsCounter
+}
+
+func (r *standardRegistry) CanaryCacheMissesCounter() metrics.Counter {
+	return r.canaryCacheMissesCounter
+}
+
+func (r *standardRegistry) CanaryBreakerOpenGauge() metrics.Gauge {
+	return r.canaryBreakerOpenGauge
+}
+
 // ScalableHistogram is a Histogram with a predefined time unit,
 // used when producing observations without explicitly setting the observed value.
 type ScalableHistogram interface {
@@ -65,6 +65,14 @@ func TestNewMultiRegistry(t *testing.T) {
 	}
 }
 
+func TestNewMultiRegistryCanaryEnabled(t *testing.T) {
+	registry := NewMultiRegistry([]Registry{newCollectingRetryMetrics()})
+	assert.False(t, registry.IsSvcCanaryEnabled())
+
+	registry = NewMultiRegistry([]Registry{newCollectingRetryMetrics(), &standardRegistry{svcEnabled: true, svcCanaryEnabled: true}})
+	assert.True(t, registry.IsSvcCanaryEnabled())
+}
+
 func newCollectingRetryMetrics() Registry {
 	return &standardRegistry{
 		serviceReqsCounter:          &counterMock{},
@@ -55,6 +55,15 @@ const (
 	serviceOpenConnsName    = metricServicePrefix + "open_connections"
 	serviceRetriesTotalName = metricServicePrefix + "retries_total"
 	serviceServerUpName     = metricServicePrefix + "server_up"
+	serviceZoneReqsName     = metricServicePrefix + "zone_requests_total"
+
+	// canary middleware.
+	metricCanaryPrefix           = MetricNamePrefix + "canary_"
+	canaryLabelFetchDurationName = metricCanaryPrefix + "label_fetch_duration_seconds"
+	canaryLabelFetchErrorsName   = metricCanaryPrefix + "label_fetch_errors_total"
+	canaryCacheHitsTotalName     = metricCanaryPrefix + "cache_hits_total"
+	canaryCacheMissesTotalName   = metricCanaryPrefix + "cache_misses_total"
+	canaryBreakerOpenName        = metricCanaryPrefix + "breaker_open"
 )
 
 // promState holds all metric state internally and acts as the only Collector we register for Prometheus.
@@ -134,6 +143,27 @@ func initStandardRegistry(config *types.Prometheus) Registry {
 		Name: tlsCertsNotAfterTimestamp,
 		Help: "Certificate expiration timestamp",
 	}, []string{"cn", "serial", "sans"})
+	canaryLabelFetchDurations := newHistogramFrom(promState.collectors, stdprometheus.HistogramOpts{
+		Name:    canaryLabelFetchDurationName,
+		Help:    "How long it took to fetch labels from a canary label server, partitioned by middleware and server.",
+		Buckets: buckets,
+	}, []string{"middleware", "server"})
+	canaryLabelFetchErrors := newCounterFrom(promState.collectors, stdprometheus.CounterOpts{
+		Name: canaryLabelFetchErrorsName,
+		Help: "How many label fetches failed on a canary label server, partitioned by middleware and server.",
+	}, []string{"middleware", "server"})
+	canaryCacheHits := newCounterFrom(promState.collectors, stdprometheus.CounterOpts{
+		Name: canaryCacheHitsTotalName,
+		Help: "How many labels were loaded from the cache of a canary middleware.",
+	}, []string{"middleware"})
+	canaryCacheMisses := newCounterFrom(promState.collectors, stdprometheus.CounterOpts{
+		Name: canaryCacheMissesTotalName,
+		Help: "How many labels were missing or expired in the cache of a canary middleware.",
+	}, []string{"middleware"})
+	canaryBreakerOpen := newGaugeFrom(promState.collectors, stdprometheus.GaugeOpts{
+		Name: canaryBreakerOpenName,
+		Help: "canary label server circuit breaker is open, described by gauge value of 0 or 1.",
+	}, []string{"middleware", "server"})
 
 	promState.describers = []func(chan<- *stdprometheus.Desc){
 		configReloads.cv.Describe,
@@ -141,18 +171,29 @@ func initStandardRegistry(config *types.Prometheus) Registry {
 		lastConfigReloadSuccess.gv.Describe,
 		lastConfigReloadFailure.gv.Describe,
 		tlsCertsNotAfterTimesptamp.gv.Describe,
+		canaryLabelFetchDurations.hv.Describe,
+		canaryLabelFetchErrors.cv.Describe,
+		canaryCacheHits.cv.Describe,
+		canaryCacheMisses.cv.Describe,
+		canaryBreakerOpen.gv.Describe,
 	}
 
 	reg := &standardRegistry{
 		epEnabled:                      config.AddEntryPointsLabels,
 		routerEnabled:                  config.AddRoutersLabels,
 		svcEnabled:                     config.AddServicesLabels,
+		svcCanaryEnabled:               config.AddServicesLabels && config.AddCanaryLabels,
 		configReloadsCounter:           configReloads,
 		configReloadsFailureCounter:    configReloadsFailures,
 		lastConfigReloadSuccessGauge:   lastConfigReloadSuccess,
 		lastConfigReloadFailureGauge:   lastConfigReloadFailure,
 		tlsCertsNotAfterTimestampGauge: tlsCertsNotAfterTimesptamp,
+		canaryLabelFetchErrorsCounter:  canaryLabelFetchErrors,
+		canaryCacheHitsCounter:         canaryCacheHits,
+		canaryCacheMissesCounter:       canaryCacheMisses,
+		canaryBreakerOpenGauge:         canaryBreakerOpen,
 	}
+	reg.canaryLabelFetchDurationHistogram, _ = NewHistogramWithScale(canaryLabelFetchDurations, time.Second)
 
 	if config.AddEntryPointsLabels {
 		entryPointReqs := newCounterFrom(promState.collectors, stdprometheus.CounterOpts{
@@ -218,10 +259,14 @@ func initStandardRegistry(config *types.Prometheus) Registry {
 	}
 
 	if config.AddServicesLabels {
+		serviceReqsLabels := []string{"code", "method", "protocol", "service"}
+		if config.AddCanaryLabels {
+			serviceReqsLabels = append(serviceReqsLabels, "canary", "canary_fallback")
+		}
 		serviceReqs := newCounterFrom(promState.collectors, stdprometheus.CounterOpts{
 			Name: serviceReqsTotalName,
 			Help: "How many HTTP requests processed on a service, partitioned by status code, protocol, and method.",
-		}, []string{"code", "method", "protocol", "service"})
+		}, serviceReqsLabels)
 		serviceReqsTLS := newCounterFrom(promState.collectors, stdprometheus.CounterOpts{
 			Name: serviceReqsTLSTotalName,
 			Help: "How many HTTP requests with TLS processed on a service, partitioned by TLS version and TLS cipher.",
@@ -230,7 +275,7 @@ func initStandardRegistry(config *types.Prometheus) Registry {
 			Name:    serviceReqDurationName,
 			Help:    "How long it took to process the request on a service, partitioned by status code, protocol, and method.",
 			Buckets: buckets,
-		}, []string{"code", "method", "protocol", "service"})
+		}, serviceReqsLabels)
 		serviceOpenConns := newGaugeFrom(promState.collectors, stdprometheus.GaugeOpts{
 			Name: serviceOpenConnsName,
 			Help: "How many open connections exist on a service, partitioned by method and protocol.",
@@ -243,6 +288,10 @@ func initStandardRegistry(config *types.Prometheus) Registry {
 			Name: serviceServerUpName,
 			Help: "service server is up, described by gauge value of 0 or 1.",
 		}, []string{"service", "url"})
+		serviceZoneReqs := newCounterFrom(promState.collectors, stdprometheus.CounterOpts{
+			Name: serviceZoneReqsName,
+			Help: "How many HTTP requests a locality-aware service sent to the servers of a zone, partitioned by whether the zone is the one of the instance.",
+		}, []string{"service", "zone", "cross_zone"})
 
 		promState.describers = append(promState.describers, []func(chan<- *stdprometheus.Desc){
 			serviceReqs.cv.Describe,
@@ -251,6 +300,7 @@ func initStandardRegistry(config *types.Prometheus) Registry {
 			serviceOpenConns.gv.Describe,
 			serviceRetries.cv.Describe,
 			serviceServerUp.gv.Describe,
+			serviceZoneReqs.cv.Describe,
 		}...)
 
 		reg.serviceReqsCounter = serviceReqs
@@ -259,6 +309,7 @@ func initStandardRegistry(config *types.Prometheus) Registry {
 		reg.serviceOpenConnsGauge = serviceOpenConns
 		reg.serviceRetriesCounter = serviceRetries
 		reg.serviceServerUpGauge = serviceServerUp
+		reg.serviceZoneReqsCounter = serviceZoneReqs
 	}
 
 	return reg
@@ -296,6 +347,10 @@ func OnConfigurationUpdate(conf dynamic.Configuration, entryPoints []string) {
 		dynamicConfig.routers[name] = true
 	}
 
+	for name := range conf.HTTP.Middlewares {
+		dynamicConfig.middlewares[name] = true
+	}
+
 	for serviceName, service := range conf.HTTP.Services {
 		dynamicConfig.services[serviceName] = make(map[string]bool)
 		if service.LoadBalancer != nil {
@@ -380,6 +435,10 @@ func (ps *prometheusState) isOutdated(collector *collector) bool {
 		return true
 	}
 
+	if middlewareName, ok := labels["middleware"]; ok && !ps.dynamicConfig.hasMiddleware(middlewareName) {
+		return true
+	}
+
 	if serviceName, ok := labels["service"]; ok {
 		if !ps.dynamicConfig.hasService(serviceName) {
 			return true
@@ -396,6 +455,7 @@ func newDynamicConfig() *dynamicConfig {
 	return &dynamicConfig{
 		entryPoints: make(map[string]bool),
 		routers:     make(map[string]bool),
+		middlewares: make(map[string]bool),
 		services:    make(map[string]map[string]bool),
 	}
 }
@@ -407,6 +467,7 @@ func newDynamicConfig() *dynamicConfig {
 type dynamicConfig struct {
 	entryPoints map[string]bool
 	routers     map[string]bool
+	middlewares map[string]bool
 	services    map[string]map[string]bool
 }
 
@@ -415,6 +476,11 @@ func (d *dynamicConfig) hasEntryPoint(entrypointName string) bool {
 	return ok
 }
 
+func (d *dynamicConfig) hasMiddleware(middlewareName string) bool {
+	_, ok := d.middlewares[middlewareName]
+	return ok
+}
+
 func (d *dynamicConfig) hasService(serviceName string) bool {
 	_, ok := d.services[serviceName]
 	return ok
@@ -175,6 +175,31 @@ func TestPrometheus(t *testing.T) {
 		ServiceServerUpGauge().
 		With("service", "service1", "url", "http://127.0.0.10:80").
 		Set(1)
+	prometheusRegistry.
+		ServiceZoneReqsCounter().
+		With("service", "service1", "zone", "zone-b", "cross_zone", "true").
+		Add(1)
+
+	prometheusRegistry.
+		CanaryLabelFetchDurationHistogram().
+		With("middleware", "canary1", "server", "http://127.0.0.20:80").
+		Observe(1)
+	prometheusRegistry.
+		CanaryLabelFetchErrorsCounter().
+		With("middleware", "canary1", "server", "http://127.0.0.20:80").
+		Add(1)
+	prometheusRegistry.
+		CanaryCacheHitsCounter().
+		With("middleware", "canary1").
+		Add(1)
+	prometheusRegistry.
+		CanaryCacheMissesCounter().
+		With("middleware", "canary1").
+		Add(1)
+	prometheusRegistry.
+		CanaryBreakerOpenGauge().
+		With("middleware", "canary1", "server", "http://127.0.0.20:80").
+		Set(1)
 
 	delayForTrackingCompletion()
 
@@ -334,6 +359,53 @@ func TestPrometheus(t *testing.T) {
 			},
 			assert: buildGaugeAssert(t, serviceServerUpName, 1),
 		},
+		{
+			name: serviceZoneReqsName,
+			labels: map[string]string{
+				"service":    "service1",
+				"zone":       "zone-b",
+				"cross_zone": "true",
+			},
+			assert: buildCounterAssert(t, serviceZoneReqsName, 1),
+		},
+		{
+			name: canaryLabelFetchDurationName,
+			labels: map[string]string{
+				"middleware": "canary1",
+				"server":     "http://127.0.0.20:80",
+			},
+			assert: buildHistogramAssert(t, canaryLabelFetchDurationName, 1),
+		},
+		{
+			name: canaryLabelFetchErrorsName,
+			labels: map[string]string{
+				"middleware": "canary1",
+				"server":     "http://127.0.0.20:80",
+			},
+			assert: buildCounterAssert(t, canaryLabelFetchErrorsName, 1),
+		},
+		{
+			name: canaryCacheHitsTotalName,
+			labels: map[string]string{
+				"middleware": "canary1",
+			},
+			assert: buildCounterAssert(t, canaryCacheHitsTotalName, 1),
+		},
+		{
+			name: canaryCacheMissesTotalName,
+			labels: map[string]string{
+				"middleware": "canary1",
+			},
+			assert: buildCounterAssert(t, canaryCacheMissesTotalName, 1),
+		},
+		{
+			name: canaryBreakerOpenName,
+			labels: map[string]string{
+				"middleware": "canary1",
+				"server":     "http://127.0.0.20:80",
+			},
+			assert: buildGaugeAssert(t, canaryBreakerOpenName, 1),
+		},
 	}
 
 	for _, test := range testCases {
@@ -401,11 +473,15 @@ func TestPrometheusMetricRemoval(t *testing.T) {
 		ServiceServerUpGauge().
 		With("service", "service1", "url", "http://localhost:9999").
 		Set(1)
+	prometheusRegistry.
+		CanaryCacheHitsCounter().
+		With("middleware", "canary2").
+		Add(1)
 
 	delayForTrackingCompletion()
 
-	assertMetricsExist(t, mustScrape(), entryPointReqsTotalName, serviceReqsTotalName, serviceServerUpName)
-	assertMetricsAbsent(t, mustScrape(), entryPointReqsTotalName, serviceReqsTotalName, serviceServerUpName)
+	assertMetricsExist(t, mustScrape(), entryPointReqsTotalName, serviceReqsTotalName, serviceServerUpName, canaryCacheHitsTotalName)
+	assertMetricsAbsent(t, mustScrape(), entryPointReqsTotalName, serviceReqsTotalName, serviceServerUpName, canaryCacheHitsTotalName)
 
 	// To verify that metrics belonging to active configurations are not removed
 	// here the counter examples.
@@ -39,7 +39,14 @@ const (
 	statsdServiceReqsDurationName = "service.request.duration"
 	statsdServiceRetriesTotalName = "service.retries.total"
 	statsdServiceServerUpName     = "service.server.up"
+	statsdServiceZoneReqsName     = "service.zone.request.total"
 	statsdServiceOpenConnsName    = "service.connections.open"
+
+	statsdCanaryLabelFetchDurationName = "canary.label.fetch.duration"
+	statsdCanaryLabelFetchErrorsName   = "canary.label.fetch.errors.total"
+	statsdCanaryCacheHitsName          = "canary.cache.hits.total"
+	statsdCanaryCacheMissesName        = "canary.cache.misses.total"
+	statsdCanaryBreakerOpenName        = "canary.breaker.open"
 )
 
 // RegisterStatsd registers the metrics pusher if this didn't happen yet and creates a statsd Registry instance.
@@ -64,7 +71,12 @@ func RegisterStatsd(ctx context.Context, config *types.Statsd) Registry {
 		lastConfigReloadSuccessGauge:   statsdClient.NewGauge(statsdLastConfigReloadSuccessName),
 		lastConfigReloadFailureGauge:   statsdClient.NewGauge(statsdLastConfigReloadFailureName),
 		tlsCertsNotAfterTimestampGauge: statsdClient.NewGauge(statsdTLSCertsNotAfterTimestampName),
+		canaryLabelFetchErrorsCounter:  statsdClient.NewCounter(statsdCanaryLabelFetchErrorsName, 1.0),
+		canaryCacheHitsCounter:         statsdClient.NewCounter(statsdCanaryCacheHitsName, 1.0),
+		canaryCacheMissesCounter:       statsdClient.NewCounter(statsdCanaryCacheMissesName, 1.0),
+		canaryBreakerOpenGauge:         statsdClient.NewGauge(statsdCanaryBreakerOpenName),
 	}
+	registry.canaryLabelFetchDurationHistogram, _ = NewHistogramWithScale(statsdClient.NewTiming(statsdCanaryLabelFetchDurationName, 1.0), time.Millisecond)
 
 	if config.AddEntryPointsLabels {
 		registry.epEnabled = config.AddEntryPointsLabels
@@ -90,6 +102,7 @@ func RegisterStatsd(ctx context.Context, config *types.Statsd) Registry {
 		registry.serviceRetriesCounter = statsdClient.NewCounter(statsdServiceRetriesTotalName, 1.0)
 		registry.serviceOpenConnsGauge = statsdClient.NewGauge(statsdServiceOpenConnsName)
 		registry.serviceServerUpGauge = statsdClient.NewGauge(statsdServiceServerUpName)
+		registry.serviceZoneReqsCounter = statsdClient.NewCounter(statsdServiceZoneReqsName, 1.0)
 	}
 
 	return registry
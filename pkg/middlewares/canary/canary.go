@@ -5,6 +5,7 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -17,8 +18,10 @@ import (
 	"github.com/opentracing/opentracing-go/ext"
 	"github.com/traefik/traefik/v2/pkg/config/dynamic"
 	"github.com/traefik/traefik/v2/pkg/log"
+	"github.com/traefik/traefik/v2/pkg/metrics"
 	"github.com/traefik/traefik/v2/pkg/middlewares"
 	"github.com/traefik/traefik/v2/pkg/middlewares/accesslog"
+	"github.com/traefik/traefik/v2/pkg/middlewares/requestid"
 	"github.com/traefik/traefik/v2/pkg/server/cookie"
 )
 
@@ -31,6 +34,9 @@ const (
 	defaultCacheSize          = 100000
 	defaultExpiration         = time.Minute * 10
 	defaultCacheCleanDuration = time.Minute * 20
+	// otherLabel replaces the labels set by the clients which are not configured,
+	// so that they do not put arbitrary values into the tracing baggage.
+	otherLabel = "other"
 )
 
 // Should be subset of DNS-1035 label
@@ -43,18 +49,23 @@ type Canary struct {
 	product              string
 	uidCookies           []string
 	rateLimitKey         []string
-	addRequestID         bool
+	requestID            *requestid.Resolver
 	forwardLabel         bool
 	canaryResponseHeader bool
 	loadLabels           bool
 	ls                   *LabelStore
 	sticky               *dynamic.Sticky
 	labelsMap            *dynamic.LabelsMap
+	labels               map[string]bool // the labels of the configuration
+	rules                []*rule
+	rollout              *rollout
+	signer               *signer
+	identity             *identity
 	next                 http.Handler
 }
 
 // New returns a Canary instance.
-func New(ctx context.Context, next http.Handler, cfg dynamic.Canary, name string) (*Canary, error) {
+func New(ctx context.Context, next http.Handler, cfg dynamic.Canary, name string, metricsRegistry metrics.Registry) (*Canary, error) {
 	logger := log.FromContext(middlewares.GetLoggerCtx(ctx, name, typeName))
 
 	if cfg.Product == "" {
@@ -86,14 +97,24 @@ func New(ctx context.Context, next http.Handler, cfg dynamic.Canary, name string
 		product:              cfg.Product,
 		uidCookies:           cfg.UIDCookies,
 		rateLimitKey:         cfg.RateLimitKey,
-		loadLabels:           cfg.Server != "",
-		addRequestID:         cfg.AddRequestID,
+		loadLabels:           cfg.Server != "" || len(cfg.Sources) > 0,
 		forwardLabel:         cfg.ForwardLabel,
 		canaryResponseHeader: cfg.CanaryResponseHeader,
 		sticky:               cfg.Sticky,
 		labelsMap:            cfg.LabelsMap,
+		labels:               configuredLabels(cfg),
 	}
 
+	// the request ids of the incoming request headers are joined, as they may come from different tracing systems.
+	requestID, err := requestid.NewResolver(dynamic.RequestID{
+		RequestHeaders:    []string{headerXRequestID, "eagleeye-traceid", "traceparent"},
+		AddResponseHeader: cfg.AddRequestID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.requestID = requestID
+
 	if cfg.Sticky != nil {
 		c.sticky.Cookie.Name = cookie.GetName(cfg.Sticky.Cookie.Name, name)
 		if !strSliceHas(c.uidCookies, c.sticky.Cookie.Name) {
@@ -101,9 +122,62 @@ func New(ctx context.Context, next http.Handler, cfg dynamic.Canary, name string
 		}
 	}
 
+	if len(cfg.Rules) > 0 {
+		rules, err := newRules(cfg.Rules)
+		if err != nil {
+			return nil, err
+		}
+		c.rules = rules
+	}
+
+	if cfg.Rollout != nil {
+		r, err := newRollout(cfg.Rollout, cfg.Product)
+		if err != nil {
+			return nil, err
+		}
+		c.rollout = r
+	}
+
+	if cfg.Signing != nil {
+		s, err := newSigner(cfg.Signing)
+		if err != nil {
+			return nil, err
+		}
+		c.signer = s
+	}
+
+	if cfg.Identity != nil {
+		id, err := newIdentity(cfg.Identity)
+		if err != nil {
+			return nil, err
+		}
+		if c.sticky != nil && c.signer == nil {
+			// the sticky cookie is only trusted when it is signed, as it would otherwise bypass the identity sources.
+			return nil, errors.New("signing keys required by the sticky cookie with identity sources")
+		}
+		c.identity = id
+	}
+
 	if c.loadLabels {
-		c.ls = NewLabelStore(logger, cfg, expiration, cacheCleanDuration, name)
+		sources, err := newLabelSources(ctx, cfg, name, metricsRegistry)
+		if err != nil {
+			return nil, err
+		}
+		c.ls = NewLabelStore(logger, cfg, expiration, cacheCleanDuration, name, sources, metricsRegistry)
+
+		if cfg.Warmup != nil {
+			w, err := newWarmup(ctx, logger, cfg, name, c.ls, metricsRegistry)
+			if err != nil {
+				return nil, err
+			}
+			startWarmup(ctx, name, cfg, w)
+		}
 	}
+
+	if c.loadLabels && cfg.InvalidationStream != "" {
+		subscribeInvalidation(ctx, cfg.InvalidationStream, cfg.Product)
+	}
+	register(ctx, name, c)
 	logger.Debugf("Add canary middleware: %v, %v, %v", cfg, expiration, cacheCleanDuration)
 	return c, nil
 }
@@ -115,41 +189,18 @@ func (c *Canary) GetTracingInformation() (string, ext.SpanKindEnum) {
 
 func (c *Canary) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	c.processRequestID(rw, req)
-	c.processCanary(rw, req)
-	c.next.ServeHTTP(rw, req)
+	c.next.ServeHTTP(rw, c.processCanary(rw, req))
 }
 
 func (c *Canary) processRequestID(rw http.ResponseWriter, req *http.Request) {
-	requestIDs := make([]string, 0)
-	if v := req.Header.Get(headerXRequestID); len(v) > 0 {
-		requestIDs = append(requestIDs, v)
-	}
-	if v := req.Header.Get("eagleeye-traceid"); len(v) > 0 {
-		requestIDs = append(requestIDs, v)
-	}
-	if v := req.Header.Get("traceparent"); len(v) >= 55 {
-		// extract trace-id as x-request-id
-		// https://www.w3.org/TR/trace-context/#traceparent-header
-		requestIDs = append(requestIDs, v[3:35])
-	}
-	if len(requestIDs) == 0 {
-		requestIDs = append(requestIDs, generatorUUID())
-	}
-
-	requestID := strings.Join(requestIDs, ", ")
-	req.Header.Set(headerXRequestID, requestID)
-	if c.addRequestID {
-		rw.Header().Set(headerXRequestID, requestID)
-	}
+	c.requestID.Apply(rw, req)
 
 	if span := opentracing.SpanFromContext(req.Context()); span != nil {
 		span.SetTag("component", "Canary")
-		span.SetTag("x-request-id", requestID)
 	}
 
 	if logData := accesslog.GetLogData(req); logData != nil {
 		logData.Core["XRealIp"] = req.Header.Get("X-Real-Ip")
-		logData.Core["XRequestID"] = requestID
 		logData.Core["UserAgent"] = req.Header.Get(headerUA)
 		logData.Core["Referer"] = req.Header.Get("Referer")
 		if traceparent := req.Header.Get("traceparent"); traceparent != "" {
@@ -158,73 +209,36 @@ func (c *Canary) processRequestID(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func (c *Canary) processCanary(rw http.ResponseWriter, req *http.Request) {
-	info := &canaryHeader{}
-
-	if c.forwardLabel {
-		// just trust the canary header when work as internal gateway.
-		info.fromHeader(req.Header, true)
-	} else {
-		// load user's labels and update to header when work as public gateway.
-		info.fromHeader(req.Header, false)
-
-		// try load labels from cookie when not exists in request X-Canary header.
-		if info.label == "" {
-			if cookie, _ := req.Cookie(headerXCanary); cookie != nil && cookie.Value != "" {
-				info.feed(strings.Split(cookie.Value, ","), false)
-			}
-		}
-
-		// try load labels from config with header when not exists.
-		if info.label == "" && c.labelsMap != nil {
-			key := req.Header.Get(c.labelsMap.RequestHeaderName)
-			if vals := c.labelsMap.Labels[key]; vals != "" {
-				info.feed(strings.Split(vals, ","), false)
-			}
-		}
+// processCanary resolves the canary header of req,
+// it returns req with the user id in its context for the source keys of the next middlewares,
+// and with the resolved label for the requests metrics of services.
+func (c *Canary) processCanary(rw http.ResponseWriter, req *http.Request) *http.Request {
+	info, d := c.resolveCanary(req, false)
+	if d.newSticky {
+		c.addSticky(info.uid, rw)
+	}
 
-		info.product = c.product
-		if uid := extractUserID(req, c.uidCookies); len(uid) > 0 {
-			info.uid = uid
-		}
-		if info.uid == "" {
-			if uid := req.Header.Get("X-Forwarded-User-Id"); len(uid) > 0 {
-				info.uid = uid
-			} else if uid := req.URL.Query().Get("uid"); len(uid) > 0 {
-				info.uid = uid
-			}
-		}
+	if d.unverified {
+		// strip the header which is not signed by the public gateway, so that it is not trusted by the next hops.
+		req.Header.Del(headerXCanary)
+	}
 
-		// anonymous user
-		if info.uid == "" && c.sticky != nil {
-			addr := req.Header.Get("X-Real-Ip")
-			if addr == "" {
-				addr = req.Header.Get("X-Forwarded-For")
-			}
-			if addr == "" {
-				addr, _, _ = net.SplitHostPort(req.RemoteAddr)
-			}
-			info.uid = anonymousID(addr, req.Header.Get(headerUA), req.Header.Get("Cookie"), time.Now().Format(time.RFC822))
-			c.addSticky(info.uid, rw)
+	if !c.forwardLabel {
+		info.intoHeader(req.Header, c.signer)
+		if c.canaryResponseHeader {
+			info.intoHeader(rw.Header(), nil)
 		}
+	}
 
-		// try load labels from server
-		if c.loadLabels && info.label == "" && info.uid != "" {
-			labels := c.ls.MustLoadLabels(req.Context(), info.uid, req.Header.Get(headerXRequestID))
-			for _, l := range labels {
-				if !l.MatchClient(info.client) {
-					continue
-				}
-				if !l.MatchChannel(info.channel) {
-					continue
-				}
-				info.label = l.Label
-				break
-			}
+	// the baggage is propagated to the next hops by the tracer, as well as the fallback of the labeled balancer.
+	label := c.boundedLabel(info, d)
+	if span := opentracing.SpanFromContext(req.Context()); span != nil {
+		if label != "" {
+			span.SetTag("canary.label", label)
+			span.SetBaggageItem("canary-label", label)
 		}
-		info.intoHeader(req.Header)
-		if c.canaryResponseHeader {
-			info.intoHeader(rw.Header())
+		if info.product != "" {
+			span.SetBaggageItem("canary-product", info.product)
 		}
 	}
 
@@ -269,13 +283,233 @@ func (c *Canary) processCanary(rw http.ResponseWriter, req *http.Request) {
 			logData.Core["XRateLimitKey"] = rateLimitKey
 		}
 	}
+
+	ctx := middlewares.WithCanary(req.Context(), label)
+	if info.uid != "" {
+		ctx = middlewares.WithUID(ctx, info.uid)
+	}
+	return req.WithContext(ctx)
+}
+
+// boundedLabel returns the label of info, or otherLabel when it is set by the client of the public gateway
+// and it is not a label of the configuration.
+func (c *Canary) boundedLabel(info *canaryHeader, d decision) string {
+	if info.label == "" || c.forwardLabel || c.labels[info.label] {
+		return info.label
+	}
+	if d.source == sourceHeader || d.source == sourceCookie {
+		return otherLabel
+	}
+	return info.label
+}
+
+// configuredLabels returns the labels of the labels map, the rules and the rollout of cfg.
+func configuredLabels(cfg dynamic.Canary) map[string]bool {
+	labels := map[string]bool{"testing": true}
+	feed := func(vals string) {
+		ch := &canaryHeader{}
+		ch.feed(strings.Split(vals, ","), false)
+		if ch.label != "" {
+			labels[ch.label] = true
+		}
+	}
+
+	if cfg.LabelsMap != nil {
+		for _, vals := range cfg.LabelsMap.Labels {
+			feed(vals)
+		}
+	}
+	for _, r := range cfg.Rules {
+		feed(r.Labels)
+	}
+	if cfg.Rollout != nil {
+		for _, l := range cfg.Rollout.Labels {
+			labels[l.Label] = true
+		}
+	}
+	return labels
+}
+
+// decision records how the label of a request was resolved.
+type decision struct {
+	source     string
+	sticky     bool // the uid is an anonymous sticky uid
+	newSticky  bool // the sticky uid is generated by this request
+	labels     []Label
+	cached     bool
+	unverified bool // the X-Canary header is not signed, or is tampered
+}
+
+// sources of the label decision
+const (
+	sourceHeader    = "header"
+	sourceCookie    = "cookie"
+	sourceLabelsMap = "labelsMap"
+	sourceRule      = "rule"
+	sourceServer    = "server"
+	sourceRollout   = "rollout"
+)
+
+// resolveCanary resolves the canary header of req without modifying req,
+// the user's labels are only looked up in the cache when peek is true.
+func (c *Canary) resolveCanary(req *http.Request, peek bool) (*canaryHeader, decision) {
+	info := &canaryHeader{}
+	d := decision{}
+
+	if c.forwardLabel {
+		// just trust the canary header when work as internal gateway,
+		// or only trust the signed one when signing is configured.
+		if c.signer != nil {
+			val, ok := c.signer.verify(req.Header)
+			if !ok {
+				d.unverified = len(req.Header.Values(headerXCanary)) > 0
+				return info, d
+			}
+			info.feed(strings.Split(val, ","), true)
+		} else {
+			info.fromHeader(req.Header, true)
+		}
+		if info.label != "" {
+			d.source = sourceHeader
+		}
+		return info, d
+	}
+
+	// load user's labels and update to header when work as public gateway.
+	info.fromHeader(req.Header, false)
+	if info.label != "" {
+		d.source = sourceHeader
+	}
+
+	// try load labels from cookie when not exists in request X-Canary header.
+	if info.label == "" {
+		if cookie, _ := req.Cookie(headerXCanary); cookie != nil && cookie.Value != "" {
+			info.feed(strings.Split(cookie.Value, ","), false)
+			if info.label != "" {
+				d.source = sourceCookie
+			}
+		}
+	}
+
+	// try load labels from config with header when not exists.
+	if info.label == "" && c.labelsMap != nil {
+		key := req.Header.Get(c.labelsMap.RequestHeaderName)
+		if vals := c.labelsMap.Labels[key]; vals != "" {
+			info.feed(strings.Split(vals, ","), false)
+			if info.label != "" {
+				d.source = sourceLabelsMap
+			}
+		}
+	}
+
+	// try load labels from config with rules when not exists.
+	if info.label == "" {
+		for _, r := range c.rules {
+			if r.match(req, info) {
+				info.feed(r.labels, false)
+				d.source = sourceRule
+				break
+			}
+		}
+	}
+
+	info.product = c.product
+	info.uid = c.extractUID(req)
+
+	// anonymous user
+	if info.uid == "" && c.sticky != nil {
+		addr := req.Header.Get("X-Real-Ip")
+		if addr == "" {
+			addr = req.Header.Get("X-Forwarded-For")
+		}
+		if addr == "" {
+			addr, _, _ = net.SplitHostPort(req.RemoteAddr)
+		}
+		info.uid = anonymousID(addr, req.Header.Get(headerUA), req.Header.Get("Cookie"), time.Now().Format(time.RFC822))
+		d.sticky = true
+		d.newSticky = true
+	} else if c.sticky != nil && info.uid != "" {
+		d.sticky = c.stickyUID(req) == info.uid
+	}
+
+	// try load labels from server
+	if c.loadLabels && info.label == "" && info.uid != "" {
+		var labels []Label
+		if peek {
+			labels, d.cached = c.ls.s.peek(info.uid)
+		} else {
+			labels = c.ls.MustLoadLabels(req.Context(), info.uid, req.Header.Get(headerXRequestID))
+		}
+		d.labels = labels
+		for _, l := range labels {
+			if l.Match(info.client, info.channel, info.app, info.version) {
+				info.label = l.Label
+				d.source = sourceServer
+				break
+			}
+		}
+	}
+
+	// assign label by percentage-based rollout when user has no label.
+	if c.rollout != nil && info.label == "" {
+		info.label = c.rollout.label(info.uid)
+		if info.label != "" {
+			d.source = sourceRollout
+		}
+	}
+	return info, d
+}
+
+// extractUID returns the user id of req from the identity sources when they are configured,
+// or from the Authorization header, the uid cookies, the X-Forwarded-User-Id header and the uid query parameter.
+func (c *Canary) extractUID(req *http.Request) string {
+	if c.identity != nil {
+		if uid := c.identity.extract(req); uid != "" {
+			return uid
+		}
+		// the anonymous user keeps its sticky uid.
+		if c.sticky != nil {
+			return c.stickyUID(req)
+		}
+		return ""
+	}
+
+	if uid := extractUserID(req, c.uidCookies); len(uid) > 0 {
+		return uid
+	}
+	if uid := req.Header.Get("X-Forwarded-User-Id"); len(uid) > 0 {
+		return uid
+	}
+	return req.URL.Query().Get("uid")
+}
+
+// stickyUID returns the uid of the sticky cookie of req,
+// the cookie must be signed when the identity sources are configured.
+func (c *Canary) stickyUID(req *http.Request) string {
+	cookie, _ := req.Cookie(c.sticky.Cookie.Name)
+	if cookie == nil {
+		return ""
+	}
+	val := cookie.Value
+	if c.identity != nil {
+		v, ok := c.signer.verifyCookie(val)
+		if !ok {
+			return ""
+		}
+		val = v
+	}
+	return extractUserIDFromBase64(extractPayload(val))
 }
 
 func (c *Canary) addSticky(id string, rw http.ResponseWriter) {
 	if data, err := json.Marshal(userInfo{UID5: id}); err == nil {
+		val := base64.RawURLEncoding.EncodeToString(data)
+		if c.identity != nil {
+			val = c.signer.signCookie(val)
+		}
 		http.SetCookie(rw, &http.Cookie{
 			Name:     c.sticky.Cookie.Name,
-			Value:    base64.RawURLEncoding.EncodeToString(data),
+			Value:    val,
 			Path:     "/",
 			Secure:   c.sticky.Cookie.Secure,
 			HttpOnly: c.sticky.Cookie.HTTPOnly,
@@ -328,20 +562,22 @@ func extractPayload(s string) string {
 	return ""
 }
 
-func extractUserIDFromBase64(s string) string {
-	if s == "" {
-		return s
-	}
+// decodeSegment decodes a base64 segment, with or without padding, URL or standard encoded.
+func decodeSegment(s string) ([]byte, error) {
 	if i := strings.IndexRune(s, '='); i > 0 {
 		s = s[:i] // remove padding
 	}
-	var b []byte
-	var err error
 	if strings.ContainsAny(s, "+/") {
-		b, err = base64.RawStdEncoding.DecodeString(s)
-	} else {
-		b, err = base64.RawURLEncoding.DecodeString(s)
+		return base64.RawStdEncoding.DecodeString(s)
 	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+func extractUserIDFromBase64(s string) string {
+	if s == "" {
+		return s
+	}
+	b, err := decodeSegment(s)
 
 	if len(b) > 0 {
 		user := &userInfo{}
@@ -407,8 +643,12 @@ func (ch *canaryHeader) fromHeader(header http.Header, trust bool) {
 	ch.feed(vals, trust)
 }
 
-// label should not be empty
-func (ch *canaryHeader) intoHeader(header http.Header) {
+// label should not be empty, the header is signed when s is not nil.
+func (ch *canaryHeader) intoHeader(header http.Header, s *signer) {
+	if s != nil {
+		header.Set(headerXCanary, s.sign(ch.String()))
+		return
+	}
 	header.Set(headerXCanary, ch.String())
 }
 
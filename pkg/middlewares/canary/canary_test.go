@@ -8,8 +8,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/stretchr/testify/assert"
 	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/middlewares"
 )
 
 const testCookie = `eyJ1aWQiOiJzb21ldWlkIiwidXNlciI6eyJfaWQiOiJzb21ldWlkIiwibmFtZSI6InRlc3RlciJ9fQ==`
@@ -87,7 +90,7 @@ func TestCanaryHeader(t *testing.T) {
 
 		ch := &canaryHeader{}
 		h := http.Header{}
-		ch.intoHeader(h)
+		ch.intoHeader(h, nil)
 		a.Equal("", h.Get(headerXCanary))
 
 		ch = &canaryHeader{
@@ -97,7 +100,7 @@ func TestCanaryHeader(t *testing.T) {
 			channel: "channel",
 		}
 		h = http.Header{}
-		ch.intoHeader(h)
+		ch.intoHeader(h, nil)
 		a.Equal("label=label,product=product,uid=uid,channel=channel", h.Get(headerXCanary))
 
 		chn := &canaryHeader{}
@@ -116,7 +119,7 @@ func TestCanaryHeader(t *testing.T) {
 			testing:    true,
 		}
 		h = http.Header{}
-		ch.intoHeader(h)
+		ch.intoHeader(h, nil)
 		a.Equal("label=label,product=product,uid=uid,client=client,channel=channel,app=app,version=version,nofallback,testing", h.Get(headerXCanary))
 
 		chn = &canaryHeader{}
@@ -172,7 +175,7 @@ func TestCanary(t *testing.T) {
 		a := assert.New(t)
 
 		cfg := dynamic.Canary{MaxCacheSize: 3, Server: "localhost", Product: "T", AddRequestID: true}
-		c, err := New(context.Background(), next, cfg, "test")
+		c, err := New(context.Background(), next, cfg, "test", nil)
 
 		a.Nil(err)
 		req := httptest.NewRequest("GET", "http://example.com/foo", nil)
@@ -190,9 +193,9 @@ func TestCanary(t *testing.T) {
 		a := assert.New(t)
 
 		cfg := dynamic.Canary{MaxCacheSize: 3, Server: "localhost", Product: "Urbs", AddRequestID: true}
-		c, err := New(context.Background(), next, cfg, "test")
-		c.ls.mustFetchLabels = func(ctx context.Context, uid, requestID string) ([]Label, int64) {
-			return []Label{{Label: uid}}, time.Now().Unix()
+		c, err := New(context.Background(), next, cfg, "test", nil)
+		c.ls.fetch = func(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
+			return []Label{{Label: uid}}, time.Now().Unix(), nil
 		}
 		a.Nil(err)
 
@@ -221,6 +224,11 @@ func TestCanary(t *testing.T) {
 		a.Equal("beta", ch.label)
 		a.Equal("Urbs", ch.product)
 
+		req = httptest.NewRequest("GET", "http://example.com/foo", nil)
+		rw = httptest.NewRecorder()
+		req.Header.Set("X-Forwarded-User-Id", "u1")
+		a.Equal("u1", middlewares.UIDFromContext(c.processCanary(rw, req).Context()))
+
 		req = httptest.NewRequest("GET", "http://example.com/foo", nil)
 		rw = httptest.NewRecorder()
 		req.AddCookie(&http.Cookie{Name: headerXCanary, Value: "beta"})
@@ -303,15 +311,104 @@ func TestCanary(t *testing.T) {
 		a.Equal("someuid", ch.uid)
 	})
 
+	t.Run("rollout should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		cfg := dynamic.Canary{Product: "Urbs", Rollout: &dynamic.CanaryRollout{
+			Labels: []dynamic.RolloutLabel{{Label: "beta", Percent: 100}},
+		}}
+		c, err := New(context.Background(), next, cfg, "test", nil)
+		a.Nil(err)
+
+		req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+		rw := httptest.NewRecorder()
+		c.processCanary(rw, req)
+		ch := &canaryHeader{}
+		ch.fromHeader(req.Header, true)
+		a.Equal("", ch.label)
+
+		req = httptest.NewRequest("GET", "http://example.com/foo", nil)
+		rw = httptest.NewRecorder()
+		req.Header.Set("Authorization", fmt.Sprintf("OAuth %s", testToken))
+		c.processCanary(rw, req)
+		ch = &canaryHeader{}
+		ch.fromHeader(req.Header, true)
+		a.Equal("beta", ch.label)
+		a.Equal("someuid", ch.uid)
+
+		req = httptest.NewRequest("GET", "http://example.com/foo", nil)
+		rw = httptest.NewRecorder()
+		req.Header.Set("Authorization", fmt.Sprintf("OAuth %s", testToken))
+		req.Header.Set(headerXCanary, "stable")
+		c.processCanary(rw, req)
+		ch = &canaryHeader{}
+		ch.fromHeader(req.Header, true)
+		a.Equal("stable", ch.label)
+
+		cfg.Rollout.Labels[0].Percent = 101
+		_, err = New(context.Background(), next, cfg, "test", nil)
+		a.NotNil(err)
+	})
+
+	t.Run("rules should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		cfg := dynamic.Canary{Product: "Urbs", Rules: []dynamic.CanaryRule{
+			{PathPrefix: "/beta", Labels: "beta,nofallback"},
+			{Client: "iOS", Labels: "dev"},
+			{Labels: "stable"},
+		}}
+		c, err := New(context.Background(), next, cfg, "test", nil)
+		a.Nil(err)
+
+		req := httptest.NewRequest("GET", "http://example.com/beta/foo", nil)
+		rw := httptest.NewRecorder()
+		req.Header.Set(headerXCanary, "client=iOS")
+		c.processCanary(rw, req)
+		ch := &canaryHeader{}
+		ch.fromHeader(req.Header, true)
+		a.Equal("beta", ch.label)
+		a.True(ch.nofallback)
+		a.Equal("iOS", ch.client)
+
+		req = httptest.NewRequest("GET", "http://example.com/foo", nil)
+		rw = httptest.NewRecorder()
+		req.Header.Set(headerXCanary, "client=iOS")
+		c.processCanary(rw, req)
+		ch = &canaryHeader{}
+		ch.fromHeader(req.Header, true)
+		a.Equal("dev", ch.label)
+		a.False(ch.nofallback)
+
+		req = httptest.NewRequest("GET", "http://example.com/foo", nil)
+		rw = httptest.NewRecorder()
+		c.processCanary(rw, req)
+		ch = &canaryHeader{}
+		ch.fromHeader(req.Header, true)
+		a.Equal("stable", ch.label)
+
+		req = httptest.NewRequest("GET", "http://example.com/beta/foo", nil)
+		rw = httptest.NewRecorder()
+		req.Header.Set(headerXCanary, "label=canary")
+		c.processCanary(rw, req)
+		ch = &canaryHeader{}
+		ch.fromHeader(req.Header, true)
+		a.Equal("canary", ch.label)
+
+		cfg.Rules = []dynamic.CanaryRule{{PathPrefix: "/beta"}}
+		_, err = New(context.Background(), next, cfg, "test", nil)
+		a.NotNil(err)
+	})
+
 	t.Run("sticky should work", func(t *testing.T) {
 		a := assert.New(t)
 
 		cfg := dynamic.Canary{MaxCacheSize: 3, Server: "localhost", Product: "Urbs", Sticky: &dynamic.Sticky{
 			Cookie: &dynamic.Cookie{Name: "_urbs_"},
 		}}
-		c, err := New(context.Background(), next, cfg, "test")
-		c.ls.mustFetchLabels = func(ctx context.Context, uid, requestID string) ([]Label, int64) {
-			return []Label{{Label: uid}}, time.Now().Unix()
+		c, err := New(context.Background(), next, cfg, "test", nil)
+		c.ls.fetch = func(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
+			return []Label{{Label: uid}}, time.Now().Unix(), nil
 		}
 		a.Nil(err)
 
@@ -338,4 +435,137 @@ func TestCanary(t *testing.T) {
 		a.Equal(uid, ch.label)
 		a.Equal(ch.uid, ch.label)
 	})
+
+	t.Run("tracing baggage should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		cfg := dynamic.Canary{MaxCacheSize: 3, Server: "localhost", Product: "Urbs",
+			LabelsMap: &dynamic.LabelsMap{RequestHeaderName: "X-Env", Labels: map[string]string{"staging": "beta"}}}
+		c, err := New(context.Background(), next, cfg, "test-baggage", nil)
+		a.Nil(err)
+
+		span := mocktracer.New().StartSpan("test")
+		req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+		req.Header.Set(headerXCanary, "label=beta")
+		req = req.WithContext(opentracing.ContextWithSpan(req.Context(), span))
+		res := c.processCanary(httptest.NewRecorder(), req)
+		a.Equal("beta", span.BaggageItem("canary-label"))
+		a.Equal("Urbs", span.BaggageItem("canary-product"))
+		a.Equal("beta", middlewares.CanaryFromContext(res.Context()).Label)
+
+		// the labels set by the client which are not configured are not propagated as is.
+		span = mocktracer.New().StartSpan("test")
+		req = httptest.NewRequest("GET", "http://example.com/foo", nil)
+		req.Header.Set(headerXCanary, "label=x-5c4057f0be825b39")
+		req = req.WithContext(opentracing.ContextWithSpan(req.Context(), span))
+		res = c.processCanary(httptest.NewRecorder(), req)
+		a.Equal(otherLabel, span.BaggageItem("canary-label"))
+		a.Equal(otherLabel, middlewares.CanaryFromContext(res.Context()).Label)
+		a.Equal("label=x-5c4057f0be825b39,product=Urbs", res.Header.Get(headerXCanary))
+	})
+
+	t.Run("removed middlewares should be unregistered", func(t *testing.T) {
+		a := assert.New(t)
+
+		cfg := dynamic.Canary{MaxCacheSize: 10, Server: "localhost", Product: "Urbs"}
+		registered := func() bool {
+			_, err := Explain("test-unregister", httptest.NewRequest("GET", "http://example.com/foo", nil))
+			return err == nil
+		}
+		stored := func() bool {
+			storesMu.Lock()
+			defer storesMu.Unlock()
+			_, ok := stores["test-unregister"]
+			return ok
+		}
+
+		ctx1, cancel1 := context.WithCancel(context.Background())
+		_, err := New(ctx1, next, cfg, "test-unregister", nil)
+		a.Nil(err)
+		a.True(registered())
+
+		// the middleware of the new configuration replaces the previous one before it is removed.
+		ctx2, cancel2 := context.WithCancel(context.Background())
+		c2, err := New(ctx2, next, cfg, "test-unregister", nil)
+		a.Nil(err)
+		cancel1()
+		time.Sleep(50 * time.Millisecond)
+		a.True(registered())
+		a.True(stored())
+		canariesMu.RLock()
+		a.Equal(c2, canaries["test-unregister"])
+		canariesMu.RUnlock()
+
+		cancel2()
+		a.Eventually(func() bool {
+			return !registered() && !stored()
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Explain should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		cfg := dynamic.Canary{MaxCacheSize: 10, Server: "localhost", Product: "Urbs", Sticky: &dynamic.Sticky{
+			Cookie: &dynamic.Cookie{Name: "_urbs_"},
+		}}
+		c, err := New(context.Background(), next, cfg, "test-explain", nil)
+		a.Nil(err)
+		c.ls.fetch = func(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
+			return []Label{{Label: "beta"}}, time.Now().Unix(), nil
+		}
+
+		_, err = Explain("test-explain-unknown", httptest.NewRequest("GET", "http://example.com/foo", nil))
+		a.NotNil(err)
+
+		req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+		req.Header.Set("X-Forwarded-User-Id", "u1")
+		d, err := Explain("test-explain", req)
+		a.Nil(err)
+		a.Equal("u1", d.UID)
+		a.Equal("", d.Label)
+		a.Equal("", d.Source)
+		a.False(d.Cached)
+		a.Equal("product=Urbs,uid=u1", d.XCanary)
+		a.Equal("", req.Header.Get(headerXCanary))
+
+		c.ls.MustLoadLabels(context.Background(), "u1", "r1")
+		d, err = Explain("test-explain", req)
+		a.Nil(err)
+		a.Equal("beta", d.Label)
+		a.Equal(sourceServer, d.Source)
+		a.True(d.Cached)
+		a.Equal(1, len(d.Labels))
+		a.False(d.Sticky)
+
+		req.Header.Set(headerXCanary, "dev")
+		d, err = Explain("test-explain", req)
+		a.Nil(err)
+		a.Equal("dev", d.Label)
+		a.Equal(sourceHeader, d.Source)
+
+		req = httptest.NewRequest("GET", "http://example.com/foo", nil)
+		rw := httptest.NewRecorder()
+		c.processCanary(rw, req)
+		cookies := rw.Result().Cookies()
+		a.Equal(1, len(cookies))
+
+		req = httptest.NewRequest("GET", "http://example.com/foo", nil)
+		req.AddCookie(cookies[0])
+		d, err = Explain("test-explain", req)
+		a.Nil(err)
+		a.True(d.Sticky)
+		a.Equal(sourceServer, d.Source)
+
+		var stats *StoreStats
+		for _, st := range GetStoreStats() {
+			if st.Name == "test-explain" {
+				st := st
+				stats = &st
+			}
+		}
+		a.NotNil(stats)
+		a.Equal("Urbs", stats.Product)
+		a.Equal(2, stats.Live)
+		a.Equal(10, stats.MaxCacheSize)
+	})
 }
@@ -0,0 +1,129 @@
+package canary
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/traefik/traefik/v2/pkg/safe"
+)
+
+var canariesMu sync.RWMutex
+var canaries = make(map[string]*Canary)
+
+// register keeps the latest canary middleware of name, so that its decisions can be explained,
+// until ctx is done. Its label store is released then.
+func register(ctx context.Context, name string, c *Canary) {
+	canariesMu.Lock()
+	canaries[name] = c
+	canariesMu.Unlock()
+
+	safe.Go(func() {
+		<-ctx.Done()
+
+		canariesMu.Lock()
+		if canaries[name] == c {
+			delete(canaries, name)
+		}
+		canariesMu.Unlock()
+
+		if c.ls != nil {
+			c.ls.release()
+		}
+	})
+}
+
+// SetUID sets uid in req where the canary middleware name reads the user id from:
+// the first identity source which holds the user id itself, or the X-Forwarded-User-Id header without identity sources.
+// It fails when the identity sources only hold the user id in the claims of JWTs.
+func SetUID(name string, req *http.Request, uid string) error {
+	canariesMu.RLock()
+	c, ok := canaries[name]
+	canariesMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("canary middleware %s not found", name)
+	}
+
+	if c.identity == nil {
+		req.Header.Set("X-Forwarded-User-Id", uid)
+		return nil
+	}
+
+	for _, s := range c.identity.sources {
+		if s.claim != nil {
+			continue
+		}
+		switch {
+		case s.header != "":
+			req.Header.Set(s.header, uid)
+		case s.cookie != "":
+			req.AddCookie(&http.Cookie{Name: s.cookie, Value: uid})
+		case s.query != "":
+			q := req.URL.Query()
+			q.Set(s.query, uid)
+			req.URL.RawQuery = q.Encode()
+		}
+		return nil
+	}
+	return fmt.Errorf("canary middleware %s reads the user id from JWTs, the token should be in the headers or the cookies", name)
+}
+
+// Decision is the canary decision of a request.
+type Decision struct {
+	Middleware string  `json:"middleware"`
+	XCanary    string  `json:"xCanary"` // the X-Canary header forwarded to the services
+	Label      string  `json:"label"`
+	Product    string  `json:"product"`
+	UID        string  `json:"uid,omitempty"`
+	Client     string  `json:"client,omitempty"`
+	Channel    string  `json:"channel,omitempty"`
+	App        string  `json:"app,omitempty"`
+	Version    string  `json:"version,omitempty"`
+	Nofallback bool    `json:"nofallback"`
+	Testing    bool    `json:"testing"`
+	Source     string  `json:"source,omitempty"` // header, cookie, labelsMap, rule, server or rollout
+	Sticky     bool    `json:"sticky"`           // the uid is an anonymous sticky uid
+	Cached     bool    `json:"cached"`           // the user's labels are cached
+	Labels     []Label `json:"labels,omitempty"` // the cached labels of the user
+	Unverified bool    `json:"unverified"`       // the X-Canary header is stripped as it is not signed
+}
+
+// Explain returns the decision of the canary middleware name for req, as if req was served by it.
+// The labels are only looked up in the cache, req is not modified.
+func Explain(name string, req *http.Request) (*Decision, error) {
+	canariesMu.RLock()
+	c, ok := canaries[name]
+	canariesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("canary middleware %s not found", name)
+	}
+
+	info, d := c.resolveCanary(req, true)
+	xCanary := req.Header.Get(headerXCanary)
+	if d.unverified {
+		xCanary = ""
+	}
+	if !c.forwardLabel {
+		xCanary = info.String()
+	}
+
+	return &Decision{
+		Middleware: name,
+		XCanary:    xCanary,
+		Label:      info.label,
+		Product:    info.product,
+		UID:        info.uid,
+		Client:     info.client,
+		Channel:    info.channel,
+		App:        info.app,
+		Version:    info.version,
+		Nofallback: info.nofallback,
+		Testing:    info.testing,
+		Source:     d.source,
+		Sticky:     d.sticky,
+		Cached:     d.cached,
+		Labels:     d.labels,
+		Unverified: d.unverified,
+	}, nil
+}
@@ -0,0 +1,203 @@
+package canary
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// identity extracts the user id of requests from ordered sources.
+type identity struct {
+	sources []identitySource
+	keys    *jwks // verifies the JWTs when set
+}
+
+type identitySource struct {
+	header string
+	cookie string
+	query  string
+	claim  []string // the path of the user id in the claims of the JWT, nil when the value is the user id
+}
+
+func newIdentity(cfg *dynamic.CanaryIdentity) (*identity, error) {
+	if len(cfg.Sources) == 0 {
+		return nil, errors.New("identity sources required")
+	}
+
+	id := &identity{sources: make([]identitySource, 0, len(cfg.Sources))}
+	for i, s := range cfg.Sources {
+		n := 0
+		for _, name := range []string{s.Header, s.Cookie, s.Query} {
+			if name != "" {
+				n++
+			}
+		}
+		if n != 1 {
+			return nil, fmt.Errorf("identity source %d: one of header, cookie or query required", i)
+		}
+
+		src := identitySource{header: s.Header, cookie: s.Cookie, query: s.Query}
+		if s.Claim != "" {
+			src.claim = strings.Split(s.Claim, ".")
+			for _, key := range src.claim {
+				if key == "" {
+					return nil, fmt.Errorf("identity source %d: invalid claim %q", i, s.Claim)
+				}
+			}
+		}
+		id.sources = append(id.sources, src)
+	}
+
+	if cfg.JWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		id.keys = keys
+	}
+	return id, nil
+}
+
+// extract returns the user id of the first source which has one.
+func (id *identity) extract(req *http.Request) string {
+	for _, s := range id.sources {
+		val := s.value(req)
+		if val == "" {
+			continue
+		}
+		if s.claim == nil {
+			return val
+		}
+		if uid := id.claim(val, s.claim); uid != "" {
+			return uid
+		}
+	}
+	return ""
+}
+
+func (s identitySource) value(req *http.Request) string {
+	switch {
+	case s.header != "":
+		val := req.Header.Get(s.header)
+		if i := strings.IndexByte(val, ' '); i > 0 {
+			val = val[i+1:] // Authorization: Bearer {token}
+		}
+		return val
+	case s.cookie != "":
+		if cookie, _ := req.Cookie(s.cookie); cookie != nil {
+			return cookie.Value
+		}
+	case s.query != "":
+		return req.URL.Query().Get(s.query)
+	}
+	return ""
+}
+
+// claim returns the claim at path of token, the token must be verified by the keys when they are set.
+func (id *identity) claim(token string, path []string) string {
+	var payload []byte
+	if id.keys != nil {
+		p, err := id.keys.verify(token, time.Now())
+		if err != nil {
+			return ""
+		}
+		payload = p
+	} else if p, err := decodeSegment(extractPayload(token)); err == nil {
+		payload = p
+	}
+	if len(payload) == 0 {
+		return ""
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return ""
+	}
+	for _, key := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = m[key]
+	}
+
+	switch val := v.(type) {
+	case string:
+		return val
+	case json.Number:
+		return val.String()
+	}
+	return ""
+}
+
+// jwks holds the public signing keys of a JSON Web Key Set.
+type jwks struct {
+	keys []jose.JSONWebKey
+}
+
+func loadJWKS(filename string) (*jwks, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWKS file: %w", err)
+	}
+
+	set := jose.JSONWebKeySet{}
+	if err = json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing JWKS file: %w", err)
+	}
+
+	s := &jwks{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if !k.IsPublic() {
+			return nil, fmt.Errorf("JWKS key %q is not a public key", k.KeyID)
+		}
+		s.keys = append(s.keys, k)
+	}
+	if len(s.keys) == 0 {
+		return nil, fmt.Errorf("no signing key in JWKS file %s", filename)
+	}
+	return s, nil
+}
+
+// verify returns the payload of token when it is signed by one of the keys, and valid at now.
+func (s *jwks) verify(token string, now time.Time) ([]byte, error) {
+	tok, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(tok.Headers) != 1 {
+		return nil, errors.New("invalid JWT")
+	}
+	header := tok.Headers[0]
+
+	for _, k := range s.keys {
+		if (header.KeyID != "" && k.KeyID != header.KeyID) || (k.Algorithm != "" && k.Algorithm != header.Algorithm) {
+			continue
+		}
+
+		claims := jwt.Claims{}
+		var payload json.RawMessage
+		if err = tok.Claims(k.Key, &claims, &payload); err != nil {
+			continue
+		}
+		if err = claims.ValidateWithLeeway(jwt.Expected{Time: now}, 0); err != nil {
+			return nil, err
+		}
+		return payload, nil
+	}
+	return nil, errors.New("invalid JWT signature")
+}
@@ -0,0 +1,269 @@
+package canary
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+)
+
+func TestIdentity(t *testing.T) {
+	t.Run("newIdentity should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		_, err := newIdentity(&dynamic.CanaryIdentity{})
+		a.NotNil(err)
+		_, err = newIdentity(&dynamic.CanaryIdentity{Sources: []dynamic.CanaryIdentitySource{{}}})
+		a.NotNil(err)
+		_, err = newIdentity(&dynamic.CanaryIdentity{Sources: []dynamic.CanaryIdentitySource{{Header: "X-Uid", Cookie: "uid"}}})
+		a.NotNil(err)
+		_, err = newIdentity(&dynamic.CanaryIdentity{Sources: []dynamic.CanaryIdentitySource{{Header: "Authorization", Claim: "user..id"}}})
+		a.NotNil(err)
+		_, err = newIdentity(&dynamic.CanaryIdentity{Sources: []dynamic.CanaryIdentitySource{{Query: "uid"}}, JWKSFile: "not-exists.json"})
+		a.NotNil(err)
+
+		id, err := newIdentity(&dynamic.CanaryIdentity{Sources: []dynamic.CanaryIdentitySource{
+			{Header: "Authorization", Claim: "user.id"},
+			{Query: "uid"},
+		}})
+		a.Nil(err)
+		a.Equal(2, len(id.sources))
+		a.Equal([]string{"user", "id"}, id.sources[0].claim)
+		a.Nil(id.sources[1].claim)
+	})
+
+	t.Run("extract should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		id, err := newIdentity(&dynamic.CanaryIdentity{Sources: []dynamic.CanaryIdentitySource{
+			{Header: "Authorization", Claim: "user.id"},
+			{Cookie: "TOKEN", Claim: "uid"},
+			{Header: "X-Forwarded-User-Id"},
+			{Query: "user"},
+		}})
+		a.Nil(err)
+
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/?user=u4&uid=u5", nil)
+		a.Equal("u4", id.extract(req))
+
+		req.Header.Set("X-Forwarded-User-Id", "u3")
+		a.Equal("u3", id.extract(req))
+
+		req.AddCookie(&http.Cookie{Name: "TOKEN", Value: unsignedToken(`{"uid":"u2"}`)})
+		a.Equal("u2", id.extract(req))
+
+		req.Header.Set("Authorization", "Bearer "+unsignedToken(`{"user":{"id":123456}}`))
+		a.Equal("123456", id.extract(req))
+
+		// no claim at the path
+		req.Header.Set("Authorization", "Bearer "+unsignedToken(`{"user":"u1"}`))
+		a.Equal("u2", id.extract(req))
+		req.Header.Set("Authorization", "Bearer invalid")
+		a.Equal("u2", id.extract(req))
+	})
+
+	t.Run("JWKS should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		a.Nil(err)
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		a.Nil(err)
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		a.Nil(err)
+
+		filename := filepath.Join(t.TempDir(), "jwks.json")
+		jwksFile, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+			{"kty": "RSA", "kid": "enc1", "use": "enc", "n": b64(otherKey.N.Bytes()), "e": b64(big.NewInt(int64(otherKey.E)).Bytes())},
+		}})
+		a.Nil(err)
+		a.Nil(os.WriteFile(filename, jwksFile, 0o600))
+
+		id, err := newIdentity(&dynamic.CanaryIdentity{
+			Sources:  []dynamic.CanaryIdentitySource{{Header: "Authorization", Claim: "sub"}, {Query: "uid"}},
+			JWKSFile: filename,
+		})
+		a.Nil(err)
+		a.Equal(2, len(id.keys.keys))
+
+		exp := time.Now().Add(time.Hour).Unix()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/?uid=u0", nil)
+		req.Header.Set("Authorization", "Bearer "+signToken(t, "RS256", "rsa1", rsaKey, map[string]interface{}{"sub": "u1", "exp": exp}))
+		a.Equal("u1", id.extract(req))
+
+		req.Header.Set("Authorization", "Bearer "+signToken(t, "PS384", "", rsaKey, map[string]interface{}{"sub": "u1"}))
+		a.Equal("u1", id.extract(req))
+
+		req.Header.Set("Authorization", "Bearer "+signToken(t, "ES256", "ec1", ecKey, map[string]interface{}{"sub": "u2"}))
+		a.Equal("u2", id.extract(req))
+
+		// spoofed
+		req.Header.Set("Authorization", "Bearer "+unsignedToken(`{"sub":"u3"}`))
+		a.Equal("u0", id.extract(req))
+		req.Header.Set("Authorization", "Bearer "+signToken(t, "RS256", "rsa1", otherKey, map[string]interface{}{"sub": "u3"}))
+		a.Equal("u0", id.extract(req))
+		req.Header.Set("Authorization", "Bearer "+signToken(t, "ES256", "rsa1", ecKey, map[string]interface{}{"sub": "u3"}))
+		a.Equal("u0", id.extract(req))
+
+		// expired or not valid yet
+		req.Header.Set("Authorization", "Bearer "+signToken(t, "RS256", "rsa1", rsaKey, map[string]interface{}{"sub": "u4", "exp": time.Now().Add(-time.Minute).Unix()}))
+		a.Equal("u0", id.extract(req))
+		req.Header.Set("Authorization", "Bearer "+signToken(t, "RS256", "rsa1", rsaKey, map[string]interface{}{"sub": "u4", "nbf": time.Now().Add(time.Minute).Unix()}))
+		a.Equal("u0", id.extract(req))
+
+		// symmetric keys are not public keys
+		a.Nil(os.WriteFile(filename, []byte(`{"keys": [{"kty": "oct", "kid": "hs1", "k": "c2VjcmV0"}]}`), 0o600))
+		_, err = newIdentity(&dynamic.CanaryIdentity{
+			Sources:  []dynamic.CanaryIdentitySource{{Header: "Authorization", Claim: "sub"}},
+			JWKSFile: filename,
+		})
+		a.NotNil(err)
+	})
+
+	t.Run("canary identity should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		cfg := dynamic.Canary{
+			Product:  "Urbs",
+			Sticky:   &dynamic.Sticky{Cookie: &dynamic.Cookie{Name: "sticky"}},
+			Identity: &dynamic.CanaryIdentity{Sources: []dynamic.CanaryIdentitySource{{Header: "X-Auth-User", Claim: "user.id"}}},
+		}
+		_, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-identity", nil)
+		a.NotNil(err)
+
+		cfg.Signing = &dynamic.CanarySigning{Keys: []dynamic.CanarySigningKey{{ID: "k1", Secret: "secret1"}}}
+		c, err := New(context.Background(), http.NotFoundHandler(), cfg, "test-identity", nil)
+		a.Nil(err)
+
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/?uid=u2", nil)
+		req.Header.Set("X-Auth-User", unsignedToken(`{"user":{"id":"u1"}}`))
+		req.Header.Set("X-Forwarded-User-Id", "u3")
+		d, err := Explain("test-identity", req)
+		a.Nil(err)
+		a.Equal("u1", d.UID)
+
+		// the default sources are ignored, the anonymous user keeps its signed sticky uid.
+		req.Header.Del("X-Auth-User")
+		rw := httptest.NewRecorder()
+		c.processCanary(rw, req)
+		cookies := rw.Result().Cookies()
+		a.Equal(1, len(cookies))
+		sticky := cookies[0].Value
+
+		req = httptest.NewRequest(http.MethodGet, "http://example.com/?uid=u2", nil)
+		req.AddCookie(&http.Cookie{Name: "sticky", Value: sticky})
+		d, err = Explain("test-identity", req)
+		a.Nil(err)
+		a.NotEqual("", d.UID)
+		a.True(d.Sticky)
+		uid := d.UID
+
+		rw = httptest.NewRecorder()
+		c.processCanary(rw, req)
+		a.Equal(0, len(rw.Result().Cookies()))
+		a.Contains(req.Header.Get(headerXCanary), "uid="+uid)
+
+		// a forged sticky cookie is not trusted
+		req = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.AddCookie(&http.Cookie{Name: "sticky", Value: unsignedToken(`{"id":"u1"}`)})
+		d, err = Explain("test-identity", req)
+		a.Nil(err)
+		a.NotEqual("u1", d.UID)
+
+		req = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.AddCookie(&http.Cookie{Name: "sticky", Value: strings.Replace(sticky, ".", "x.", 1)})
+		d, err = Explain("test-identity", req)
+		a.Nil(err)
+		a.NotEqual(uid, d.UID)
+	})
+}
+
+func TestSetUID(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := New(context.Background(), http.NotFoundHandler(), dynamic.Canary{Product: "Urbs"}, "test-setuid-default", nil)
+	a.Nil(err)
+	_, err = New(context.Background(), http.NotFoundHandler(), dynamic.Canary{
+		Product: "Urbs",
+		Identity: &dynamic.CanaryIdentity{Sources: []dynamic.CanaryIdentitySource{
+			{Header: "Authorization", Claim: "sub"},
+			{Query: "user"},
+		}},
+	}, "test-setuid-query", nil)
+	a.Nil(err)
+	_, err = New(context.Background(), http.NotFoundHandler(), dynamic.Canary{
+		Product:  "Urbs",
+		Identity: &dynamic.CanaryIdentity{Sources: []dynamic.CanaryIdentitySource{{Header: "Authorization", Claim: "sub"}}},
+	}, "test-setuid-jwt", nil)
+	a.Nil(err)
+
+	for _, name := range []string{"test-setuid-default", "test-setuid-query"} {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/?uid=u2", nil)
+		a.Nil(SetUID(name, req, "u1"))
+		d, err := Explain(name, req)
+		a.Nil(err)
+		a.Equal("u1", d.UID)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	a.NotNil(SetUID("test-setuid-jwt", req, "u1"))
+	a.NotNil(SetUID("test-setuid-unknown", req, "u1"))
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func unsignedToken(payload string) string {
+	return b64([]byte(`{"alg":"none"}`)) + "." + b64([]byte(payload)) + "."
+}
+
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+	assert.Nil(t, err)
+	payload, err := json.Marshal(claims)
+	assert.Nil(t, err)
+	signingInput := b64(header) + "." + b64(payload)
+
+	hash := map[string]crypto.Hash{"RS256": crypto.SHA256, "PS384": crypto.SHA384, "ES256": crypto.SHA256}[alg]
+	h := hash.New()
+	h.Write([]byte(signingInput))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if alg[:2] == "PS" {
+			sig, err = rsa.SignPSS(rand.Reader, k, hash, digest, nil)
+		} else {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+		}
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest)
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	assert.Nil(t, err)
+	return signingInput + "." + b64(sig)
+}
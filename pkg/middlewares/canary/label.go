@@ -2,14 +2,17 @@ package canary
 
 import (
 	"context"
-	"fmt"
-	"strings"
+	"sort"
 	"sync"
 	"time"
 
+	gokitmetrics "github.com/go-kit/kit/metrics"
+	goversion "github.com/hashicorp/go-version"
 	"github.com/opentracing/opentracing-go"
 	"github.com/traefik/traefik/v2/pkg/config/dynamic"
 	"github.com/traefik/traefik/v2/pkg/log"
+	"github.com/traefik/traefik/v2/pkg/metrics"
+	"github.com/traefik/traefik/v2/pkg/safe"
 )
 
 var storesMu sync.Mutex
@@ -17,15 +20,22 @@ var stores = make(map[string]*Store)
 
 // LabelStore ...
 type LabelStore struct {
-	s               *Store
-	logger          log.Logger
-	expiration      time.Duration
-	mustFetchLabels func(ctx context.Context, uid, requestID string) (labels []Label, timestamp int64)
+	name         string
+	s            *Store
+	logger       log.Logger
+	expiration   time.Duration
+	maxStaleness time.Duration
+	fetch        func(ctx context.Context, uid, requestID string) (labels []Label, timestamp int64, err error)
+	cacheHits    gokitmetrics.Counter
+	cacheMisses  gokitmetrics.Counter
 }
 
 // Store ...
 type Store struct {
+	refs               int // the label stores sharing the store, guarded by storesMu
 	mu                 sync.RWMutex
+	product            string
+	expiration         time.Duration
 	maxCacheSize       int
 	cacheCleanDuration time.Duration
 	shouldRound        time.Time
@@ -34,9 +44,11 @@ type Store struct {
 }
 
 type entry struct {
-	mu       sync.Mutex
-	value    []Label
-	expireAt time.Time
+	mu         sync.Mutex
+	value      []Label
+	expireAt   time.Time
+	refreshing bool
+	version    uint64 // increased by each update of the value, so that a refresh does not overwrite a newer value
 }
 
 // Label ...
@@ -44,6 +56,11 @@ type Label struct {
 	Label    string   `json:"l"`
 	Clients  []string `json:"cls,omitempty"`
 	Channels []string `json:"chs,omitempty"`
+	Apps     []string `json:"aps,omitempty"`
+	// Version is a version constraint, ex. ">=10.0.0 <11"
+	Version string `json:"ver,omitempty"`
+
+	constraints goversion.Constraints
 }
 
 // MatchClient ...
@@ -72,23 +89,57 @@ func (l *Label) MatchChannel(channel string) bool {
 	return false
 }
 
-// NewLabelStore ...
-func NewLabelStore(logger log.Logger, cfg dynamic.Canary, expiration, cacheCleanDuration time.Duration, name string) *LabelStore {
-	product := cfg.Product
-	apiURL := cfg.Server
-	// apiURL ex. https://labelServerHost/api/labels?uid=%s&product=%s
-	if !strings.Contains(apiURL, "%s") { // append default API path.
-		if apiURL[len(apiURL)-1] == '/' {
-			apiURL = apiURL[:len(apiURL)-1]
+// MatchApp ...
+func (l *Label) MatchApp(app string) bool {
+	if len(l.Apps) == 0 {
+		return true
+	}
+	for _, a := range l.Apps {
+		if a == app {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchVersion ...
+func (l *Label) MatchVersion(version string) bool {
+	if l.Version == "" {
+		return true
+	}
+	constraints := l.constraints
+	if constraints == nil {
+		var err error
+		if constraints, err = parseVersionConstraints(l.Version); err != nil {
+			return false
+		}
+	}
+	return matchVersion(constraints, version)
+}
+
+// Match returns true if the label is available for the client, channel, app and version.
+func (l *Label) Match(client, channel, app, version string) bool {
+	return l.MatchClient(client) && l.MatchChannel(channel) && l.MatchApp(app) && l.MatchVersion(version)
+}
+
+// parseVersion parses the version constraint once, so that MatchVersion does not have to.
+func (l *Label) parseVersion() {
+	if l.Version != "" && l.constraints == nil {
+		if constraints, err := parseVersionConstraints(l.Version); err == nil {
+			l.constraints = constraints
 		}
-		apiURL += "/users/%s/labels:cache?product=%s"
 	}
+}
 
+// NewLabelStore ...
+func NewLabelStore(logger log.Logger, cfg dynamic.Canary, expiration, cacheCleanDuration time.Duration, name string, sources []LabelSource, metricsRegistry metrics.Registry) *LabelStore {
 	storesMu.Lock()
 	// LabelStores share Store with same apiURL, but always update Store'config to latest
 	s, ok := stores[name]
 	if !ok {
 		s = &Store{
+			product:            cfg.Product,
+			expiration:         expiration,
 			maxCacheSize:       cfg.MaxCacheSize,
 			cacheCleanDuration: cacheCleanDuration,
 			shouldRound:        time.Now().UTC().Add(cacheCleanDuration),
@@ -97,19 +148,48 @@ func NewLabelStore(logger log.Logger, cfg dynamic.Canary, expiration, cacheClean
 		}
 		stores[name] = s
 	} else {
-		s.updateConfig(cfg.MaxCacheSize, cacheCleanDuration)
+		s.updateConfig(cfg.Product, cfg.MaxCacheSize, expiration, cacheCleanDuration)
 	}
+	s.refs++
 	storesMu.Unlock()
 
-	ls := &LabelStore{logger: logger, s: s, expiration: expiration}
-	ls.mustFetchLabels = func(ctx context.Context, uid, requestID string) ([]Label, int64) {
-		url := fmt.Sprintf(apiURL, uid, product)
-		return MustGetUserLabels(ctx, url, requestID, logger)
+	if metricsRegistry == nil {
+		metricsRegistry = metrics.NewVoidRegistry()
+	}
+
+	maxStaleness := time.Duration(cfg.MaxStaleness)
+	if maxStaleness <= 0 {
+		maxStaleness = expiration
+	}
+
+	ls := &LabelStore{
+		name:         name,
+		logger:       logger,
+		s:            s,
+		expiration:   expiration,
+		maxStaleness: maxStaleness,
+		cacheHits:    metricsRegistry.CanaryCacheHitsCounter().With("middleware", name),
+		cacheMisses:  metricsRegistry.CanaryCacheMissesCounter().With("middleware", name),
+	}
+	ls.fetch = func(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
+		return fetchLabels(ctx, logger, sources, uid, requestID)
 	}
 	return ls
 }
 
-// MustLoadLabels ...
+// release drops the store of ls once it is not shared by another label store anymore.
+func (ls *LabelStore) release() {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+
+	ls.s.refs--
+	if ls.s.refs <= 0 && stores[ls.name] == ls.s {
+		delete(stores, ls.name)
+	}
+}
+
+// MustLoadLabels returns the cached labels of uid. It only blocks on the first fetch, or when the
+// labels expired longer than maxStaleness, otherwise expired labels are returned and refreshed in background.
 func (ls *LabelStore) MustLoadLabels(ctx context.Context, uid, requestID string) []Label {
 	now := time.Now().UTC()
 	e, round := ls.s.mustLoadEntry(uid, now)
@@ -121,29 +201,286 @@ func (ls *LabelStore) MustLoadLabels(ctx context.Context, uid, requestID string)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	fetchLabels := false
+	refreshLabels := false
 
-	if e.value == nil || e.expireAt.Before(now) {
-		labels, ts := ls.mustFetchLabels(ctx, uid, requestID)
-		e.value = labels
-		e.expireAt = time.Unix(ts, 0).Add(ls.expiration)
+	switch {
+	case e.value == nil || e.expireAt.Add(ls.maxStaleness).Before(now):
+		labels, expireAt, err := ls.fetchLabels(ctx, uid, requestID)
+		if err != nil {
+			// the labels are too stale to be kept, the user gets no labels until they expire.
+			labels, expireAt = []Label{}, now.Add(ls.expiration)
+		}
+		e.value, e.expireAt = labels, expireAt
+		e.version++
 		fetchLabels = true
+		ls.cacheMisses.Add(1)
+	case e.expireAt.Before(now):
+		// only one refresh for an entry at a time
+		if !e.refreshing {
+			e.refreshing = true
+			refreshLabels = true
+			version := e.version
+			safe.Go(func() {
+				ls.refreshLabels(e, version, uid, requestID)
+			})
+		}
+		ls.cacheHits.Add(1)
+	default:
+		ls.cacheHits.Add(1)
 	}
 
 	if span := opentracing.SpanFromContext(ctx); span != nil {
 		span.SetTag("fetched-labels", fetchLabels)
+		span.SetTag("refresh-labels", refreshLabels)
 	}
 
 	return e.value
 }
 
+func (ls *LabelStore) fetchLabels(ctx context.Context, uid, requestID string) ([]Label, time.Time, error) {
+	labels, ts, err := ls.fetch(ctx, uid, requestID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	for i := range labels {
+		labels[i].parseVersion()
+	}
+	return labels, time.Unix(ts, 0).Add(ls.expiration), nil
+}
+
+// refreshLabels fetches the labels of an expired entry, it is detached from the request which triggered it,
+// so that a canceled request does not cancel the refresh.
+// The stale labels are kept when the fetch fails, until they expired longer than maxStaleness,
+// and the fetched labels are dropped when the entry was updated during the fetch, as they may be older.
+func (ls *LabelStore) refreshLabels(e *entry, version uint64, uid, requestID string) {
+	labels, expireAt, err := ls.fetchLabels(context.Background(), uid, requestID)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.refreshing = false
+	if err != nil || e.version != version {
+		return
+	}
+	e.value = labels
+	e.expireAt = expireAt
+	e.version++
+}
+
+// InvalidateLabels drops the cached labels of uids from the stores of product,
+// or all the cached labels of product when uids is empty, so that they will be fetched again.
+// It returns the number of dropped entries.
+func InvalidateLabels(product string, uids ...string) int {
+	n := 0
+	for _, s := range productStores(product) {
+		if len(uids) == 0 {
+			n += s.flush()
+		} else {
+			n += s.invalidate(uids)
+		}
+	}
+	return n
+}
+
+// UpdateLabels replaces the cached labels of uid in the stores of product.
+// It returns the number of updated stores.
+func UpdateLabels(product, uid string, labels []Label) int {
+	if labels == nil {
+		labels = []Label{}
+	}
+	for i := range labels {
+		labels[i].parseVersion()
+	}
+
+	ss := productStores(product)
+	now := time.Now().UTC()
+	for _, s := range ss {
+		s.update(uid, labels, now)
+	}
+	return len(ss)
+}
+
+// StoreStats is the cache size stats of a label store.
+type StoreStats struct {
+	Name         string `json:"name"`
+	Product      string `json:"product"`
+	Live         int    `json:"live"`
+	Stale        int    `json:"stale"`
+	MaxCacheSize int    `json:"maxCacheSize"`
+}
+
+// GetStoreStats returns the cache size stats of all the label stores, sorted by name.
+func GetStoreStats() []StoreStats {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+
+	stats := make([]StoreStats, 0, len(stores))
+	for name, s := range stores {
+		s.mu.RLock()
+		st := StoreStats{Name: name, Product: s.product, Live: len(s.liveMap), MaxCacheSize: s.maxCacheSize}
+		for _, e := range s.staleMap {
+			if e != nil {
+				st.Stale++
+			}
+		}
+		s.mu.RUnlock()
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+func productStores(product string) []*Store {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+
+	ss := make([]*Store, 0, 1)
+	for _, s := range stores {
+		s.mu.RLock()
+		if s.product == product {
+			ss = append(ss, s)
+		}
+		s.mu.RUnlock()
+	}
+	return ss
+}
+
 // updateConfig ...
-func (s *Store) updateConfig(maxCacheSize int, cacheCleanDuration time.Duration) {
+func (s *Store) updateConfig(product string, maxCacheSize int, expiration, cacheCleanDuration time.Duration) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.product = product
 	s.maxCacheSize = maxCacheSize
+	s.expiration = expiration
 	s.cacheCleanDuration = cacheCleanDuration
 }
 
+func (s *Store) invalidate(keys []string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, key := range keys {
+		if _, ok := s.liveMap[key]; ok {
+			delete(s.liveMap, key)
+			n++
+		}
+		if e, ok := s.staleMap[key]; ok {
+			delete(s.staleMap, key)
+			if e != nil {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func (s *Store) flush() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.liveMap)
+	for _, e := range s.staleMap {
+		if e != nil {
+			n++
+		}
+	}
+	s.liveMap = make(map[string]*entry)
+	s.staleMap = make(map[string]*entry)
+	return n
+}
+
+func (s *Store) update(key string, labels []Label, now time.Time) {
+	s.mu.Lock()
+	e, ok := s.liveMap[key]
+	if !ok {
+		e = &entry{}
+		s.liveMap[key] = e
+		delete(s.staleMap, key)
+	}
+	expireAt := now.Add(s.expiration)
+	s.mu.Unlock()
+
+	e.mu.Lock()
+	e.value = labels
+	e.expireAt = expireAt
+	e.version++
+	e.mu.Unlock()
+}
+
+// recentKeys returns at most max keys, the keys of liveMap go first.
+func (s *Store) recentKeys(max int) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.liveMap))
+	for key := range s.liveMap {
+		if len(keys) >= max {
+			return keys
+		}
+		keys = append(keys, key)
+	}
+	for key, e := range s.staleMap {
+		if len(keys) >= max {
+			return keys
+		}
+		if e != nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// missingKeys returns the keys which are not cached.
+func (s *Store) missingKeys(keys []string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	missing := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := s.liveMap[key]; ok {
+			continue
+		}
+		if e := s.staleMap[key]; e != nil {
+			continue
+		}
+		missing = append(missing, key)
+	}
+	return missing
+}
+
+// warm caches labels of key unless it is cached already.
+func (s *Store) warm(key string, labels []Label, expireAt time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.liveMap[key]; ok {
+		return false
+	}
+	if e := s.staleMap[key]; e != nil {
+		return false
+	}
+	s.liveMap[key] = &entry{value: labels, expireAt: expireAt}
+	delete(s.staleMap, key)
+	return true
+}
+
+// peek returns the cached labels of key without fetching them or touching the cache rounds.
+func (s *Store) peek(key string) ([]Label, bool) {
+	s.mu.RLock()
+	e, ok := s.liveMap[key]
+	if !ok || e == nil {
+		e = s.staleMap[key]
+	}
+	s.mu.RUnlock()
+
+	if e == nil {
+		return nil, false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.value, e.value != nil
+}
+
 func (s *Store) mustLoadEntry(key string, now time.Time) (*entry, bool) {
 	s.mu.RLock()
 	e, ok := s.liveMap[key]
@@ -9,6 +9,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	ptypes "github.com/traefik/paerser/types"
 	"github.com/traefik/traefik/v2/pkg/config/dynamic"
 )
 
@@ -32,6 +33,35 @@ func TestLabelStruct(t *testing.T) {
 		a.True(l.MatchChannel("stable"))
 		a.False(l.MatchChannel("any"))
 	})
+
+	t.Run("matchApp and matchVersion should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		l := Label{}
+		a.True(l.MatchApp(""))
+		a.True(l.MatchVersion(""))
+		a.True(l.Match("", "", "", ""))
+
+		l = Label{Apps: []string{"teambition"}, Version: ">=10.0.0 <11"}
+		a.False(l.MatchApp(""))
+		a.True(l.MatchApp("teambition"))
+		a.False(l.MatchApp("any"))
+
+		a.False(l.MatchVersion(""))
+		a.True(l.MatchVersion("v10.2"))
+		a.False(l.MatchVersion("11.0.0"))
+
+		l.parseVersion()
+		a.NotNil(l.constraints)
+		a.True(l.MatchVersion("v10.2"))
+		a.True(l.Match("web", "stable", "teambition", "10.0"))
+		a.False(l.Match("web", "stable", "teambition", "9.0"))
+
+		l = Label{Version: "invalid"}
+		l.parseVersion()
+		a.Nil(l.constraints)
+		a.False(l.MatchVersion("10.0"))
+	})
 }
 
 func TestLabelStore(t *testing.T) {
@@ -39,9 +69,9 @@ func TestLabelStore(t *testing.T) {
 		a := assert.New(t)
 
 		cfg := dynamic.Canary{MaxCacheSize: 3, Server: "localhost1", Product: "T"}
-		ls := NewLabelStore(logrus.StandardLogger(), cfg, time.Second, time.Second*2, "canary-test")
-		ls.mustFetchLabels = func(ctx context.Context, uid, requestID string) ([]Label, int64) {
-			return []Label{{Label: requestID}}, time.Now().Unix()
+		ls := NewLabelStore(logrus.StandardLogger(), cfg, time.Second, time.Second*2, "canary-test", nil, nil)
+		ls.fetch = func(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
+			return []Label{{Label: requestID}}, time.Now().Unix(), nil
 		}
 
 		u1, ok := ls.s.mustLoadEntry("u1", time.Now())
@@ -83,10 +113,10 @@ func TestLabelStore(t *testing.T) {
 	t.Run("MustLoadLabels should work", func(t *testing.T) {
 		a := assert.New(t)
 
-		cfg := dynamic.Canary{MaxCacheSize: 3, Server: "localhost2", Product: "T"}
-		ls := NewLabelStore(logrus.StandardLogger(), cfg, time.Second, time.Second*2, "canary-test2")
-		ls.mustFetchLabels = func(ctx context.Context, uid, requestID string) ([]Label, int64) {
-			return []Label{{Label: requestID}}, time.Now().Unix()
+		cfg := dynamic.Canary{MaxCacheSize: 3, Server: "localhost2", Product: "T", MaxStaleness: ptypes.Duration(time.Second * 5)}
+		ls := NewLabelStore(logrus.StandardLogger(), cfg, time.Second, time.Second*2, "canary-test2", nil, nil)
+		ls.fetch = func(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
+			return []Label{{Label: requestID}}, time.Now().Unix(), nil
 		}
 
 		labels := ls.MustLoadLabels(context.Background(), "u1", "v1")
@@ -100,10 +130,13 @@ func TestLabelStore(t *testing.T) {
 
 		// cache expired
 		time.Sleep(time.Millisecond * 1100)
-		// cache value
+		// stale value, refreshed in background
 		labels = ls.MustLoadLabels(context.Background(), "u1", "v2")
 		a.Equal(1, len(labels))
-		a.Equal("v2", labels[0].Label)
+		a.Equal("v1", labels[0].Label)
+		a.Eventually(func() bool {
+			return ls.MustLoadLabels(context.Background(), "u1", "v3")[0].Label == "v2"
+		}, time.Second, time.Millisecond*10)
 
 		labels = ls.MustLoadLabels(context.Background(), "u1", "v3")
 		a.Equal(1, len(labels))
@@ -123,9 +156,9 @@ func TestLabelStore(t *testing.T) {
 		a.Equal("v2", labels[0].Label)
 
 		var call int32
-		ls.mustFetchLabels = func(ctx context.Context, uid, requestID string) ([]Label, int64) {
+		ls.fetch = func(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
 			atomic.AddInt32(&call, 1)
-			return []Label{{Label: requestID}}, time.Now().Unix()
+			return []Label{{Label: requestID}}, time.Now().Unix(), nil
 		}
 
 		var wg sync.WaitGroup
@@ -146,6 +179,175 @@ func TestLabelStore(t *testing.T) {
 			_ = ls.MustLoadLabels(context.Background(), "u1", "v6")
 		}()
 		wg.Wait()
-		a.Equal(int32(1), call)
+		a.Eventually(func() bool {
+			return atomic.LoadInt32(&call) == 1
+		}, time.Second, time.Millisecond*10)
+		time.Sleep(time.Millisecond * 100)
+		a.Equal(int32(1), atomic.LoadInt32(&call))
+	})
+
+	t.Run("MustLoadLabels should not block on stale labels", func(t *testing.T) {
+		a := assert.New(t)
+
+		cfg := dynamic.Canary{MaxCacheSize: 100, Server: "localhost5", Product: "T5", MaxStaleness: ptypes.Duration(time.Second * 2)}
+		ls := NewLabelStore(logrus.StandardLogger(), cfg, time.Second, time.Minute, "canary-test5", nil, nil)
+		a.Equal(time.Second*2, ls.maxStaleness)
+
+		var call int32
+		release := make(chan struct{})
+		ls.fetch = func(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
+			if atomic.AddInt32(&call, 1) > 1 {
+				<-release
+			}
+			return []Label{{Label: requestID}}, time.Now().Unix(), nil
+		}
+
+		labels := ls.MustLoadLabels(context.Background(), "u1", "v1")
+		a.Equal("v1", labels[0].Label)
+
+		time.Sleep(time.Millisecond * 1100)
+		// the refresh is blocked, stale labels are returned without waiting for it
+		for i := 0; i < 10; i++ {
+			labels = ls.MustLoadLabels(context.Background(), "u1", "v2")
+			a.Equal("v1", labels[0].Label)
+		}
+		a.Eventually(func() bool {
+			return atomic.LoadInt32(&call) == 2
+		}, time.Second, time.Millisecond*10)
+
+		close(release)
+		a.Eventually(func() bool {
+			return ls.MustLoadLabels(context.Background(), "u1", "v3")[0].Label == "v2"
+		}, time.Second, time.Millisecond*10)
+		a.Equal(int32(2), atomic.LoadInt32(&call))
+
+		// expired longer than maxStaleness
+		time.Sleep(time.Millisecond * 3100)
+		labels = ls.MustLoadLabels(context.Background(), "u1", "v4")
+		a.Equal("v4", labels[0].Label)
+		a.Equal(int32(3), atomic.LoadInt32(&call))
+	})
+	t.Run("refreshLabels should keep stale labels when the fetch fails", func(t *testing.T) {
+		a := assert.New(t)
+
+		cfg := dynamic.Canary{MaxCacheSize: 100, Server: "localhost7", Product: "T7", MaxStaleness: ptypes.Duration(time.Minute)}
+		ls := NewLabelStore(logrus.StandardLogger(), cfg, time.Minute, time.Minute, "canary-test7", nil, nil)
+
+		var call int32
+		ls.fetch = func(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
+			if atomic.AddInt32(&call, 1) > 1 {
+				return nil, 0, errUnhealthy
+			}
+			return []Label{{Label: requestID}}, time.Now().Unix(), nil
+		}
+
+		labels := ls.MustLoadLabels(context.Background(), "u1", "v1")
+		a.Equal("v1", labels[0].Label)
+
+		e := ls.s.liveMap["u1"]
+		expireAt := time.Now().UTC().Add(-time.Second)
+		e.mu.Lock()
+		e.expireAt = expireAt
+		e.mu.Unlock()
+
+		labels = ls.MustLoadLabels(context.Background(), "u1", "v2")
+		a.Equal("v1", labels[0].Label)
+		a.Eventually(func() bool {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			return !e.refreshing
+		}, time.Second, time.Millisecond*10)
+		a.Equal(int32(2), atomic.LoadInt32(&call))
+
+		// the stale labels are kept and still expired, so that they are refreshed again.
+		e.mu.Lock()
+		a.Equal("v1", e.value[0].Label)
+		a.Equal(expireAt, e.expireAt)
+		e.mu.Unlock()
+		labels = ls.MustLoadLabels(context.Background(), "u1", "v3")
+		a.Equal("v1", labels[0].Label)
+		a.Eventually(func() bool {
+			return atomic.LoadInt32(&call) == 3
+		}, time.Second, time.Millisecond*10)
+	})
+
+	t.Run("refreshLabels should not overwrite labels updated during the fetch", func(t *testing.T) {
+		a := assert.New(t)
+
+		cfg := dynamic.Canary{MaxCacheSize: 100, Server: "localhost8", Product: "T8", MaxStaleness: ptypes.Duration(time.Minute)}
+		ls := NewLabelStore(logrus.StandardLogger(), cfg, time.Minute, time.Minute, "canary-test8", nil, nil)
+
+		var call int32
+		fetching := make(chan struct{})
+		release := make(chan struct{})
+		ls.fetch = func(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
+			if atomic.AddInt32(&call, 1) > 1 {
+				close(fetching)
+				<-release
+			}
+			return []Label{{Label: requestID}}, time.Now().Unix(), nil
+		}
+
+		labels := ls.MustLoadLabels(context.Background(), "u1", "v1")
+		a.Equal("v1", labels[0].Label)
+
+		e := ls.s.liveMap["u1"]
+		e.mu.Lock()
+		e.expireAt = time.Now().UTC().Add(-time.Second)
+		e.mu.Unlock()
+
+		labels = ls.MustLoadLabels(context.Background(), "u1", "v2")
+		a.Equal("v1", labels[0].Label)
+		<-fetching
+
+		a.Equal(1, UpdateLabels("T8", "u1", []Label{{Label: "beta"}}))
+		close(release)
+		a.Eventually(func() bool {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			return !e.refreshing
+		}, time.Second, time.Millisecond*10)
+
+		labels = ls.MustLoadLabels(context.Background(), "u1", "v3")
+		a.Equal("beta", labels[0].Label)
+		a.Equal(int32(2), atomic.LoadInt32(&call))
+	})
+
+	t.Run("InvalidateLabels and UpdateLabels should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		cfg := dynamic.Canary{MaxCacheSize: 100, Server: "localhost3", Product: "T3"}
+		ls := NewLabelStore(logrus.StandardLogger(), cfg, time.Minute, time.Minute, "canary-test3", nil, nil)
+		ls2 := NewLabelStore(logrus.StandardLogger(), cfg, time.Minute, time.Minute, "canary-test4", nil, nil)
+		var call int32
+		ls.fetch = func(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
+			atomic.AddInt32(&call, 1)
+			return []Label{{Label: requestID}}, time.Now().Unix(), nil
+		}
+
+		labels := ls.MustLoadLabels(context.Background(), "u1", "v1")
+		a.Equal("v1", labels[0].Label)
+		_ = ls.MustLoadLabels(context.Background(), "u2", "v1")
+		a.Equal(int32(2), call)
+
+		a.Equal(0, InvalidateLabels("T3-none", "u1"))
+		a.Equal(1, InvalidateLabels("T3", "u1", "u3"))
+		labels = ls.MustLoadLabels(context.Background(), "u1", "v2")
+		a.Equal("v2", labels[0].Label)
+		a.Equal(int32(3), call)
+
+		a.Equal(2, UpdateLabels("T3", "u1", []Label{{Label: "beta", Version: ">=1.0"}}))
+		labels = ls.MustLoadLabels(context.Background(), "u1", "v3")
+		a.Equal("beta", labels[0].Label)
+		a.NotNil(labels[0].constraints)
+		a.Equal(int32(3), call)
+		labels = ls2.MustLoadLabels(context.Background(), "u1", "v3")
+		a.Equal("beta", labels[0].Label)
+
+		a.Equal(3, InvalidateLabels("T3"))
+		a.Equal(0, len(ls.s.liveMap))
+		labels = ls.MustLoadLabels(context.Background(), "u2", "v4")
+		a.Equal("v4", labels[0].Label)
+		a.Equal(int32(4), call)
 	})
 }
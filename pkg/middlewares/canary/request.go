@@ -1,6 +1,7 @@
 package canary
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
@@ -9,44 +10,162 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"reflect"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	gokitmetrics "github.com/go-kit/kit/metrics"
 	"github.com/opentracing/opentracing-go"
-	"github.com/traefik/traefik/v2/pkg/log"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/metrics"
+	"github.com/traefik/traefik/v2/pkg/safe"
 	"github.com/traefik/traefik/v2/pkg/version"
 )
 
+const (
+	defaultRequestTimeout    = time.Second
+	defaultDialTimeout       = 5 * time.Second
+	defaultFailuresThreshold = 5
+	defaultRetryInterval     = 10 * time.Second
+)
+
 func init() {
 	userAgent = fmt.Sprintf("Go/%v Traefik/%s (Canary Middleware)", runtime.Version(), version.Version)
 }
 
 var userAgent string
 
-var tr = &http.Transport{
-	TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	DialContext: (&net.Dialer{
-		Timeout:   5 * time.Second,
-		KeepAlive: 15 * time.Second,
-	}).DialContext,
-	ForceAttemptHTTP2:     true,
-	MaxIdleConns:          100,
-	MaxIdleConnsPerHost:   100,
-	IdleConnTimeout:       25 * time.Second,
-	TLSHandshakeTimeout:   3 * time.Second,
-	ExpectContinueTimeout: 1 * time.Second,
-	ResponseHeaderTimeout: 5 * time.Second,
+var labelClientsMu sync.Mutex
+var labelClients = make(map[string]*labelClient)
+
+func newTransport(dialTimeout time.Duration, tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
+		TLSClientConfig: tlsConfig,
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: 15 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   100,
+		IdleConnTimeout:       25 * time.Second,
+		TLSHandshakeTimeout:   3 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+	}
 }
 
-var client = &http.Client{
-	Transport: tr,
-	Timeout:   time.Second,
+// labelClient requests a label server, every label server of a middleware has its own client,
+// so that a failing label server only opens its own circuit breaker.
+type labelClient struct {
+	opts          dynamic.LabelServerOptions
+	client        *http.Client
+	hc            *healthcheck
+	fetchDuration metrics.ScalableHistogram
+	fetchErrors   gokitmetrics.Counter
+	refs          int // the middlewares using the client, guarded by labelClientsMu
+}
+
+// getLabelClient returns the client of the middleware's label server. The client is kept between
+// configuration reloads, and so is its circuit breaker state, unless the options changed.
+// The client is released once ctx is done, and dropped when no middleware uses it anymore.
+func getLabelClient(ctx context.Context, middleware, server string, opts *dynamic.LabelServerOptions, metricsRegistry metrics.Registry) (*labelClient, error) {
+	if opts == nil {
+		opts = &dynamic.LabelServerOptions{}
+	}
+	if metricsRegistry == nil {
+		metricsRegistry = metrics.NewVoidRegistry()
+	}
+
+	key := middleware + "|" + server
+	labelClientsMu.Lock()
+	defer labelClientsMu.Unlock()
+
+	old, ok := labelClients[key]
+	if ok && reflect.DeepEqual(old.opts, *opts) {
+		old.acquire(ctx, key)
+		return old, nil
+	}
+
+	c, err := newLabelClient(*opts)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		// the replaced client only serves the middlewares of the previous configuration until they are released
+		old.client.CloseIdleConnections()
+	}
+
+	labelValues := []string{"middleware", middleware, "server", server}
+	c.fetchDuration = metricsRegistry.CanaryLabelFetchDurationHistogram().With(labelValues...)
+	c.fetchErrors = metricsRegistry.CanaryLabelFetchErrorsCounter().With(labelValues...)
+	c.hc.breakerOpen = metricsRegistry.CanaryBreakerOpenGauge().With(labelValues...)
+	c.hc.breakerOpen.Set(0)
+	labelClients[key] = c
+	c.acquire(ctx, key)
+	return c, nil
 }
 
-var hc = &healthcheck{
-	failuresThreshold: 5,
-	retry:             time.Second * 10,
+// acquire must be called with labelClientsMu held.
+func (c *labelClient) acquire(ctx context.Context, key string) {
+	c.refs++
+	safe.Go(func() {
+		<-ctx.Done()
+		c.release(key)
+	})
+}
+
+func (c *labelClient) release(key string) {
+	labelClientsMu.Lock()
+	defer labelClientsMu.Unlock()
+
+	c.refs--
+	if c.refs > 0 {
+		return
+	}
+	c.client.CloseIdleConnections()
+	if labelClients[key] == c {
+		delete(labelClients, key)
+		c.hc.breakerOpen.Set(0)
+	}
+}
+
+func newLabelClient(opts dynamic.LabelServerOptions) (*labelClient, error) {
+	tlsConfig, err := opts.TLS.CreateTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid label server TLS config: %w", err)
+	}
+
+	timeout := time.Duration(opts.Timeout)
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+	dialTimeout := time.Duration(opts.DialTimeout)
+	if dialTimeout <= 0 {
+		dialTimeout = defaultDialTimeout
+	}
+	failuresThreshold := opts.FailuresThreshold
+	if failuresThreshold <= 0 {
+		failuresThreshold = defaultFailuresThreshold
+	}
+	retry := time.Duration(opts.RetryInterval)
+	if retry <= 0 {
+		retry = defaultRetryInterval
+	}
+
+	return &labelClient{
+		opts: opts,
+		client: &http.Client{
+			Transport: newTransport(dialTimeout, tlsConfig),
+			Timeout:   timeout,
+		},
+		hc: &healthcheck{
+			failuresThreshold: uint64(failuresThreshold),
+			retry:             retry,
+		},
+	}, nil
 }
 
 type healthcheck struct {
@@ -54,14 +173,17 @@ type healthcheck struct {
 	failuresThreshold uint64
 	retry             time.Duration
 	timer             *time.Timer
+	breakerOpen       gokitmetrics.Gauge
 }
 
 func (h *healthcheck) CountFailure() uint64 {
 	i := atomic.AddUint64(&h.failures, 1)
 	if i == h.failuresThreshold {
+		h.setBreakerOpen(1)
 		h.timer = time.AfterFunc(h.retry, func() {
 			// make MaybeHealthy() returns true
 			atomic.StoreUint64(&h.failures, h.failuresThreshold-1)
+			h.setBreakerOpen(0)
 		})
 	}
 	return i
@@ -71,6 +193,7 @@ func (h *healthcheck) Reset() {
 	if atomic.SwapUint64(&h.failures, 0) != 0 && h.timer != nil {
 		h.timer.Stop()
 		h.timer = nil
+		h.setBreakerOpen(0)
 	}
 }
 
@@ -78,12 +201,27 @@ func (h *healthcheck) MaybeHealthy() bool {
 	return atomic.LoadUint64(&h.failures) < h.failuresThreshold
 }
 
+func (h *healthcheck) setBreakerOpen(v float64) {
+	if h.breakerOpen != nil {
+		h.breakerOpen.Set(v)
+	}
+}
+
 type labelsRes struct {
 	Timestamp int64   `json:"timestamp"` // []label 构建时间，Unix seconds
 	Result    []Label `json:"result"`    // 空数组也保留
 }
 
-func getUserLabels(ctx context.Context, api, xRequestID string) (*labelsRes, error) {
+type batchLabelsReq struct {
+	UIDs []string `json:"uids"`
+}
+
+type batchLabelsRes struct {
+	Timestamp int64              `json:"timestamp"` // labels 构建时间，Unix seconds
+	Result    map[string][]Label `json:"result"`    // uid -> []label，缺失的 uid 视为没有 label
+}
+
+func (c *labelClient) getUserLabels(ctx context.Context, api, xRequestID string) (*labelsRes, error) {
 	if ctx.Err() != nil {
 		return nil, nil
 	}
@@ -93,6 +231,39 @@ func getUserLabels(ctx context.Context, api, xRequestID string) (*labelsRes, err
 		return nil, err
 	}
 
+	res := &labelsRes{}
+	if ok, err := c.do(ctx, req, xRequestID, res); !ok {
+		return nil, err
+	}
+	return res, nil
+}
+
+// getBatchLabels posts uids to the batch endpoint of the label server, it returns the labels of every uid.
+func (c *labelClient) getBatchLabels(ctx context.Context, api string, uids []string, xRequestID string) (*batchLabelsRes, error) {
+	if ctx.Err() != nil {
+		return nil, nil
+	}
+
+	body, err := json.Marshal(batchLabelsReq{UIDs: uids})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", api, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res := &batchLabelsRes{}
+	if ok, err := c.do(ctx, req, xRequestID, res); !ok {
+		return nil, err
+	}
+	return res, nil
+}
+
+// do sends req and decodes the response body into res, it returns false when the request failed or was canceled.
+func (c *labelClient) do(ctx context.Context, req *http.Request, xRequestID string, res interface{}) (bool, error) {
 	req.Header.Set(headerUA, userAgent)
 	req.Header.Set(headerXRequestID, xRequestID)
 	if span := opentracing.SpanFromContext(ctx); span != nil {
@@ -102,48 +273,35 @@ func getUserLabels(ctx context.Context, api, xRequestID string) (*labelsRes, err
 			opentracing.HTTPHeadersCarrier(req.Header))
 	}
 
-	resp, err := client.Do(req)
+	start := time.Now()
+	resp, err := c.client.Do(req)
 	if err != nil {
 		if err.(*url.Error).Unwrap() == context.Canceled {
-			return nil, nil
+			return false, nil
 		}
 
-		c := hc.CountFailure()
-		return nil, fmt.Errorf("xRequestId: %s, failures: %d, request error: %v", xRequestID, c, err)
+		c.fetchErrors.Add(1)
+		n := c.hc.CountFailure()
+		return false, fmt.Errorf("xRequestId: %s, failures: %d, request error: %v", xRequestID, n, err)
 	}
 
-	hc.Reset()
-
 	respBody, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
+	c.fetchDuration.ObserveFromStart(start)
 	if resp.StatusCode != 200 || err != nil || len(respBody) == 0 {
-		return nil, fmt.Errorf("xRequestId: %s, getUserLabels error: %d, %d, %v, %s",
-			xRequestID, resp.StatusCode, resp.ContentLength, err, string(respBody))
+		c.fetchErrors.Add(1)
+		n := c.hc.CountFailure()
+		return false, fmt.Errorf("xRequestId: %s, failures: %d, getUserLabels error: %d, %d, %v, %s",
+			xRequestID, n, resp.StatusCode, resp.ContentLength, err, string(respBody))
 	}
 
-	res := &labelsRes{}
 	if err = json.Unmarshal(respBody, res); err != nil {
-		return nil, fmt.Errorf("xRequestId: %s, getUserLabels Unmarshal error: %v, %s",
-			xRequestID, err, string(respBody))
-	}
-	return res, nil
-}
-
-// MustGetUserLabels returns labels and timestamp
-func MustGetUserLabels(ctx context.Context, api, xRequestID string, logger log.Logger) ([]Label, int64) {
-	ts := time.Now().UTC().Unix()
-	rs := []Label{}
-
-	if hc.MaybeHealthy() {
-		if res, err := getUserLabels(ctx, api, xRequestID); err != nil {
-			logger.Error(err)
-		} else if res != nil {
-			rs = res.Result
-			if res.Timestamp > 0 && res.Timestamp < ts {
-				ts = res.Timestamp
-			}
-		}
+		c.fetchErrors.Add(1)
+		n := c.hc.CountFailure()
+		return false, fmt.Errorf("xRequestId: %s, failures: %d, getUserLabels Unmarshal error: %v, %s",
+			xRequestID, n, err, string(respBody))
 	}
 
-	return rs, ts
+	c.hc.Reset()
+	return true, nil
 }
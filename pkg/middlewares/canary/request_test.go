@@ -1,11 +1,18 @@
 package canary
 
 import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	ptypes "github.com/traefik/paerser/types"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
 )
 
 func TestHealthcheck(t *testing.T) {
@@ -42,3 +49,149 @@ func TestHealthcheck(t *testing.T) {
 		a.Nil(hc.timer)
 	})
 }
+
+func TestLabelClient(t *testing.T) {
+	t.Run("getLabelClient should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		c1, err := getLabelClient(context.Background(), "test-client1", "http://localhost1", nil, nil)
+		a.Nil(err)
+		a.Equal(time.Second, c1.client.Timeout)
+		a.Equal(uint64(5), c1.hc.failuresThreshold)
+		a.Equal(10*time.Second, c1.hc.retry)
+
+		c2, err := getLabelClient(context.Background(), "test-client1", "http://localhost1", &dynamic.LabelServerOptions{}, nil)
+		a.Nil(err)
+		a.Same(c1, c2)
+
+		c2, err = getLabelClient(context.Background(), "test-client2", "http://localhost1", nil, nil)
+		a.Nil(err)
+		a.NotSame(c1, c2)
+
+		opts := &dynamic.LabelServerOptions{
+			Timeout:           ptypes.Duration(2 * time.Second),
+			FailuresThreshold: 2,
+			RetryInterval:     ptypes.Duration(time.Minute),
+		}
+		c2, err = getLabelClient(context.Background(), "test-client1", "http://localhost1", opts, nil)
+		a.Nil(err)
+		a.NotSame(c1, c2)
+		a.Equal(2*time.Second, c2.client.Timeout)
+		a.Equal(uint64(2), c2.hc.failuresThreshold)
+		a.Equal(time.Minute, c2.hc.retry)
+
+		_, err = getLabelClient(context.Background(), "test-client1", "http://localhost1", &dynamic.LabelServerOptions{
+			TLS: &dynamic.ClientTLS{Cert: "invalid", Key: "invalid"},
+		}, nil)
+		a.NotNil(err)
+	})
+
+	t.Run("released clients should be closed and dropped", func(t *testing.T) {
+		a := assert.New(t)
+
+		var closed int32
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			_, _ = rw.Write([]byte(`{"timestamp": 0, "result": []}`))
+		}))
+		server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateClosed {
+				atomic.AddInt32(&closed, 1)
+			}
+		}
+		server.Start()
+		defer server.Close()
+
+		clientCount := func() int {
+			labelClientsMu.Lock()
+			defer labelClientsMu.Unlock()
+			return len(labelClients)
+		}
+		count := clientCount()
+
+		ctx1, cancel1 := context.WithCancel(context.Background())
+		defer cancel1()
+		c1, err := getLabelClient(ctx1, "test-release", server.URL, nil, nil)
+		a.Nil(err)
+		_, _, err = newHTTPSource(server.URL, "T", c1).FetchLabels(context.Background(), "u1", "r1")
+		a.Nil(err)
+		a.Equal(count+1, clientCount())
+
+		// the reloaded configuration changes the options, the idle connections of the replaced client are closed
+		ctx2, cancel2 := context.WithCancel(context.Background())
+		defer cancel2()
+		c2, err := getLabelClient(ctx2, "test-release", server.URL, &dynamic.LabelServerOptions{FailuresThreshold: 2}, nil)
+		a.Nil(err)
+		a.NotSame(c1, c2)
+		a.Eventually(func() bool { return atomic.LoadInt32(&closed) == 1 }, time.Second, 10*time.Millisecond)
+
+		cancel1()
+		time.Sleep(50 * time.Millisecond)
+		a.Equal(count+1, clientCount())
+		c3, err := getLabelClient(ctx2, "test-release", server.URL, &dynamic.LabelServerOptions{FailuresThreshold: 2}, nil)
+		a.Nil(err)
+		a.Same(c2, c3)
+
+		// the middleware is removed
+		cancel2()
+		a.Eventually(func() bool { return clientCount() == count }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("circuit breaker should be per server", func(t *testing.T) {
+		a := assert.New(t)
+
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			_, _ = rw.Write([]byte(`{"timestamp": 0, "result": []}`))
+		}))
+		defer server.Close()
+		failing := httptest.NewServer(http.NotFoundHandler())
+		failing.Close()
+
+		opts := &dynamic.LabelServerOptions{FailuresThreshold: 2}
+		c1, err := getLabelClient(context.Background(), "test-breaker", failing.URL, opts, nil)
+		a.Nil(err)
+		c2, err := getLabelClient(context.Background(), "test-breaker", server.URL, opts, nil)
+		a.Nil(err)
+
+		s1 := newHTTPSource(failing.URL, "T", c1)
+		s2 := newHTTPSource(server.URL, "T", c2)
+		for i := 0; i < 2; i++ {
+			_, _, err = s1.FetchLabels(context.Background(), "u1", "r1")
+			a.NotNil(err)
+		}
+		_, _, err = s1.FetchLabels(context.Background(), "u1", "r1")
+		a.Equal(errUnhealthy, err)
+
+		labels, _, err := s2.FetchLabels(context.Background(), "u1", "r1")
+		a.Nil(err)
+		a.Equal(0, len(labels))
+	})
+	t.Run("circuit breaker should open on server errors", func(t *testing.T) {
+		a := assert.New(t)
+
+		var status int32 = http.StatusInternalServerError
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.WriteHeader(int(atomic.LoadInt32(&status)))
+		}))
+		defer server.Close()
+
+		c, err := getLabelClient(context.Background(), "test-breaker-status", server.URL, &dynamic.LabelServerOptions{FailuresThreshold: 2}, nil)
+		a.Nil(err)
+		s := newHTTPSource(server.URL, "T", c)
+		for i := 0; i < 2; i++ {
+			_, _, err = s.FetchLabels(context.Background(), "u1", "r1")
+			a.NotNil(err)
+		}
+		_, _, err = s.FetchLabels(context.Background(), "u1", "r1")
+		a.Equal(errUnhealthy, err)
+
+		// an empty body is a failure too
+		c.hc.Reset()
+		atomic.StoreInt32(&status, http.StatusOK)
+		for i := 0; i < 2; i++ {
+			_, _, err = s.FetchLabels(context.Background(), "u1", "r1")
+			a.NotNil(err)
+		}
+		_, _, err = s.FetchLabels(context.Background(), "u1", "r1")
+		a.Equal(errUnhealthy, err)
+	})
+}
@@ -0,0 +1,67 @@
+package canary
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+)
+
+// rollout assigns labels to a stable percentage of users.
+// A user is hashed into one of 100 buckets, labels take consecutive ranges of buckets in order,
+// so raising a label's percent keeps its current users as long as the labels before it are unchanged.
+type rollout struct {
+	seed   string
+	labels []dynamic.RolloutLabel
+}
+
+func newRollout(cfg *dynamic.CanaryRollout, product string) (*rollout, error) {
+	total := 0
+	labels := make([]dynamic.RolloutLabel, 0, len(cfg.Labels))
+	for _, l := range cfg.Labels {
+		if !validLabelReg.MatchString(l.Label) {
+			return nil, fmt.Errorf("invalid rollout label %q", l.Label)
+		}
+		if l.Percent < 0 || l.Percent > 100 {
+			return nil, fmt.Errorf("invalid rollout percent %d for label %q", l.Percent, l.Label)
+		}
+		total += l.Percent
+		if l.Percent > 0 {
+			labels = append(labels, l)
+		}
+	}
+	if total > 100 {
+		return nil, fmt.Errorf("sum of rollout percents should not be greater than 100, got %d", total)
+	}
+
+	seed := cfg.Seed
+	if seed == "" {
+		seed = product
+	}
+	return &rollout{seed: seed, labels: labels}, nil
+}
+
+// label returns the label assigned to uid, or empty string when uid is not in the rollout.
+func (r *rollout) label(uid string) string {
+	if uid == "" || len(r.labels) == 0 {
+		return ""
+	}
+
+	bucket := rolloutBucket(r.seed, uid)
+	for _, l := range r.labels {
+		if bucket < l.Percent {
+			return l.Label
+		}
+		bucket -= l.Percent
+	}
+	return ""
+}
+
+func rolloutBucket(seed, uid string) int {
+	h := fnv.New32a()
+	io.WriteString(h, seed)
+	io.WriteString(h, ":")
+	io.WriteString(h, uid)
+	return int(h.Sum32() % 100)
+}
@@ -0,0 +1,78 @@
+package canary
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+)
+
+func TestRollout(t *testing.T) {
+	t.Run("newRollout should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		r, err := newRollout(&dynamic.CanaryRollout{}, "Urbs")
+		a.Nil(err)
+		a.Equal("Urbs", r.seed)
+		a.Equal("", r.label("someuid"))
+
+		r, err = newRollout(&dynamic.CanaryRollout{Seed: "seed", Labels: []dynamic.RolloutLabel{
+			{Label: "beta", Percent: 10},
+			{Label: "dev", Percent: 0},
+		}}, "Urbs")
+		a.Nil(err)
+		a.Equal("seed", r.seed)
+		a.Equal(1, len(r.labels))
+
+		_, err = newRollout(&dynamic.CanaryRollout{Labels: []dynamic.RolloutLabel{
+			{Label: ".beta", Percent: 10},
+		}}, "Urbs")
+		a.NotNil(err)
+
+		_, err = newRollout(&dynamic.CanaryRollout{Labels: []dynamic.RolloutLabel{
+			{Label: "beta", Percent: 101},
+		}}, "Urbs")
+		a.NotNil(err)
+
+		_, err = newRollout(&dynamic.CanaryRollout{Labels: []dynamic.RolloutLabel{
+			{Label: "beta", Percent: 60},
+			{Label: "dev", Percent: 50},
+		}}, "Urbs")
+		a.NotNil(err)
+	})
+
+	t.Run("label should be stable and respect percent", func(t *testing.T) {
+		a := assert.New(t)
+
+		r, err := newRollout(&dynamic.CanaryRollout{Labels: []dynamic.RolloutLabel{
+			{Label: "beta", Percent: 20},
+			{Label: "dev", Percent: 30},
+		}}, "Urbs")
+		a.Nil(err)
+		a.Equal("", r.label(""))
+
+		counts := make(map[string]int)
+		for i := 0; i < 10000; i++ {
+			uid := fmt.Sprintf("uid-%d", i)
+			l := r.label(uid)
+			a.Equal(l, r.label(uid))
+			counts[l]++
+		}
+		a.InDelta(2000, counts["beta"], 300)
+		a.InDelta(3000, counts["dev"], 300)
+		a.InDelta(5000, counts[""], 300)
+
+		// users in a rollout stay in it when the percent grows.
+		wider, err := newRollout(&dynamic.CanaryRollout{Labels: []dynamic.RolloutLabel{
+			{Label: "beta", Percent: 50},
+		}}, "Urbs")
+		a.Nil(err)
+		for i := 0; i < 1000; i++ {
+			uid := fmt.Sprintf("uid-%d", i)
+			if r.label(uid) == "beta" {
+				a.Equal("beta", wider.label(uid))
+			}
+		}
+	})
+}
@@ -0,0 +1,150 @@
+package canary
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	goversion "github.com/hashicorp/go-version"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/ip"
+)
+
+// rule is a compiled dynamic.CanaryRule.
+type rule struct {
+	pathPrefix string
+	host       string
+	query      map[string]string
+	cookies    map[string]string
+	headers    map[string]*regexp.Regexp
+	checker    *ip.Checker
+	strategy   ip.Strategy
+	client     string
+	channel    string
+	app        string
+	version    goversion.Constraints
+	labels     []string
+}
+
+func newRules(cfgs []dynamic.CanaryRule) ([]*rule, error) {
+	rules := make([]*rule, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		r, err := newRule(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid canary rule %d: %w", i, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func newRule(cfg dynamic.CanaryRule) (*rule, error) {
+	labels := strings.Split(cfg.Labels, ",")
+	ch := &canaryHeader{}
+	ch.feed(labels, false)
+	if ch.label == "" {
+		return nil, fmt.Errorf("invalid labels %q", cfg.Labels)
+	}
+
+	r := &rule{
+		pathPrefix: cfg.PathPrefix,
+		host:       strings.ToLower(cfg.Host),
+		query:      cfg.Query,
+		cookies:    cfg.Cookies,
+		client:     cfg.Client,
+		channel:    cfg.Channel,
+		app:        cfg.App,
+		labels:     labels,
+	}
+
+	if cfg.Version != "" {
+		constraints, err := parseVersionConstraints(cfg.Version)
+		if err != nil {
+			return nil, fmt.Errorf("version: %w", err)
+		}
+		r.version = constraints
+	}
+
+	if len(cfg.Headers) > 0 {
+		r.headers = make(map[string]*regexp.Regexp, len(cfg.Headers))
+		for name, exp := range cfg.Headers {
+			reg, err := regexp.Compile(exp)
+			if err != nil {
+				return nil, fmt.Errorf("header %s: %w", name, err)
+			}
+			r.headers[http.CanonicalHeaderKey(name)] = reg
+		}
+	}
+
+	if len(cfg.SourceRange) > 0 {
+		checker, err := ip.NewChecker(cfg.SourceRange)
+		if err != nil {
+			return nil, fmt.Errorf("sourceRange: %w", err)
+		}
+		strategy, err := cfg.IPStrategy.Get()
+		if err != nil {
+			return nil, fmt.Errorf("ipStrategy: %w", err)
+		}
+		r.checker = checker
+		r.strategy = strategy
+	}
+	return r, nil
+}
+
+// match reports whether the request and its parsed canary header satisfy all conditions of the rule.
+func (r *rule) match(req *http.Request, info *canaryHeader) bool {
+	if r.pathPrefix != "" && !strings.HasPrefix(req.URL.Path, r.pathPrefix) {
+		return false
+	}
+	if r.host != "" && r.host != requestHost(req) {
+		return false
+	}
+	if r.client != "" && r.client != info.client {
+		return false
+	}
+	if r.channel != "" && r.channel != info.channel {
+		return false
+	}
+	if r.app != "" && r.app != info.app {
+		return false
+	}
+	if r.version != nil && !matchVersion(r.version, info.version) {
+		return false
+	}
+
+	if len(r.query) > 0 {
+		query := req.URL.Query()
+		for name, val := range r.query {
+			if query.Get(name) != val {
+				return false
+			}
+		}
+	}
+	for name, val := range r.cookies {
+		if cookie, _ := req.Cookie(name); cookie == nil || cookie.Value != val {
+			return false
+		}
+	}
+	for name, reg := range r.headers {
+		if !reg.MatchString(req.Header.Get(name)) {
+			return false
+		}
+	}
+
+	if r.checker != nil {
+		if ok, _ := r.checker.Contains(r.strategy.GetIP(req)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func requestHost(req *http.Request) string {
+	host := strings.ToLower(req.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
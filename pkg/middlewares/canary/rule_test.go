@@ -0,0 +1,101 @@
+package canary
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+)
+
+func TestRule(t *testing.T) {
+	t.Run("newRules should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		rules, err := newRules([]dynamic.CanaryRule{{Labels: "beta"}, {Labels: "label=dev,nofallback"}})
+		a.Nil(err)
+		a.Equal(2, len(rules))
+
+		_, err = newRules([]dynamic.CanaryRule{{}})
+		a.NotNil(err)
+
+		_, err = newRules([]dynamic.CanaryRule{{Labels: ".beta"}})
+		a.NotNil(err)
+
+		_, err = newRules([]dynamic.CanaryRule{{Labels: "beta", Headers: map[string]string{"X-Foo": "("}}})
+		a.NotNil(err)
+
+		_, err = newRules([]dynamic.CanaryRule{{Labels: "beta", Version: ">=abc"}})
+		a.NotNil(err)
+
+		_, err = newRules([]dynamic.CanaryRule{{Labels: "beta", SourceRange: []string{"10.0.0.0/33"}}})
+		a.NotNil(err)
+	})
+
+	t.Run("match should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		rules, err := newRules([]dynamic.CanaryRule{{
+			PathPrefix:  "/api",
+			Host:        "Example.com",
+			Query:       map[string]string{"debug": "1"},
+			Cookies:     map[string]string{"env": "beta"},
+			Headers:     map[string]string{"x-device": "^iPhone"},
+			SourceRange: []string{"10.0.0.0/8"},
+			Client:      "iOS",
+			Labels:      "beta",
+		}})
+		a.Nil(err)
+		r := rules[0]
+
+		newReq := func() *http.Request {
+			req := httptest.NewRequest("GET", "http://example.com:8080/api/users?debug=1", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.AddCookie(&http.Cookie{Name: "env", Value: "beta"})
+			req.Header.Set("X-Device", "iPhone 12")
+			return req
+		}
+		info := &canaryHeader{client: "iOS"}
+
+		a.True(r.match(newReq(), info))
+		a.False(r.match(newReq(), &canaryHeader{client: "Android"}))
+
+		req := newReq()
+		req.URL.Path = "/web"
+		a.False(r.match(req, info))
+
+		req = newReq()
+		req.Host = "example.org"
+		a.False(r.match(req, info))
+
+		req = newReq()
+		req.URL.RawQuery = ""
+		a.False(r.match(req, info))
+
+		req = newReq()
+		req.Header.Del("Cookie")
+		a.False(r.match(req, info))
+
+		req = newReq()
+		req.Header.Set("X-Device", "Pixel")
+		a.False(r.match(req, info))
+
+		req = newReq()
+		req.RemoteAddr = "192.168.0.1:1234"
+		a.False(r.match(req, info))
+
+		rules, err = newRules([]dynamic.CanaryRule{{App: "teambition", Version: "v10.0", Labels: "beta"}})
+		a.Nil(err)
+		a.True(rules[0].match(newReq(), &canaryHeader{app: "teambition", version: "v10.0"}))
+		a.False(rules[0].match(newReq(), &canaryHeader{app: "teambition", version: "v9.0"}))
+		a.False(rules[0].match(newReq(), &canaryHeader{version: "v10.0"}))
+
+		rules, err = newRules([]dynamic.CanaryRule{{Client: "iOS", Version: ">=10.2", Labels: "beta"}})
+		a.Nil(err)
+		a.True(rules[0].match(newReq(), &canaryHeader{client: "iOS", version: "10.2"}))
+		a.True(rules[0].match(newReq(), &canaryHeader{client: "iOS", version: "v11.0.1"}))
+		a.False(rules[0].match(newReq(), &canaryHeader{client: "iOS", version: "10.1.9"}))
+		a.False(rules[0].match(newReq(), &canaryHeader{client: "iOS"}))
+	})
+}
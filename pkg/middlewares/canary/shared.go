@@ -0,0 +1,68 @@
+package canary
+
+import (
+	"context"
+	"sync"
+
+	"github.com/traefik/traefik/v2/pkg/safe"
+)
+
+// sharedTasks runs the background tasks shared by the canary middlewares, keyed by what they share.
+// A task is started by the first middleware which acquires it, and it is stopped once all the
+// middlewares which acquired it are released, so that a reloaded configuration keeps the unchanged tasks running.
+type sharedTasks struct {
+	mu    sync.Mutex
+	tasks map[string]*sharedTask
+}
+
+type sharedTask struct {
+	refs   int
+	cancel context.CancelFunc
+}
+
+func newSharedTasks() *sharedTasks {
+	return &sharedTasks{tasks: make(map[string]*sharedTask)}
+}
+
+// acquire starts run for key unless it is running already, and releases it once ctx is done.
+// The context of run is canceled when the last middleware is released.
+func (s *sharedTasks) acquire(ctx context.Context, key string, run func(ctx context.Context)) {
+	s.mu.Lock()
+	task, ok := s.tasks[key]
+	if !ok {
+		taskCtx, cancel := context.WithCancel(context.Background())
+		task = &sharedTask{cancel: cancel}
+		s.tasks[key] = task
+		safe.Go(func() {
+			run(taskCtx)
+		})
+	}
+	task.refs++
+	s.mu.Unlock()
+
+	safe.Go(func() {
+		<-ctx.Done()
+		s.release(key, task)
+	})
+}
+
+func (s *sharedTasks) release(key string, task *sharedTask) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task.refs--
+	if task.refs > 0 {
+		return
+	}
+	task.cancel()
+	if s.tasks[key] == task {
+		delete(s.tasks, key)
+	}
+}
+
+// running returns the number of running tasks.
+func (s *sharedTasks) running() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tasks)
+}
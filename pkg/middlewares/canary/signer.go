@@ -0,0 +1,159 @@
+package canary
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+)
+
+const (
+	signatureField = ",sig="
+	defaultMaxAge  = time.Minute
+	// cookieMACPrefix separates the signatures of the cookies from the ones of the X-Canary header.
+	cookieMACPrefix = "cookie"
+)
+
+var validKeyIDReg = regexp.MustCompile(`^[0-9A-Za-z_-]{1,32}$`)
+
+// signer signs the X-Canary header with HMAC-SHA256, the signature and its issue time are appended to the header:
+// X-Canary: label=beta,product=urbs,uid=5c4057f0be825b390667abee,sig={keyID}:{unix time}:{base64url(HMAC)}
+// The HMAC covers the value and the issue time, so that a captured header is rejected once it is older than maxAge.
+type signer struct {
+	keys   []signingKey // the first key signs
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+type signingKey struct {
+	id     string
+	secret []byte
+}
+
+func newSigner(cfg *dynamic.CanarySigning) (*signer, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("signing keys required")
+	}
+
+	s := &signer{
+		keys:   make([]signingKey, 0, len(cfg.Keys)),
+		maxAge: time.Duration(cfg.MaxAge),
+		now:    time.Now,
+	}
+	if s.maxAge <= 0 {
+		s.maxAge = defaultMaxAge
+	}
+	for _, k := range cfg.Keys {
+		if !validKeyIDReg.MatchString(k.ID) {
+			return nil, fmt.Errorf("invalid signing key id %q", k.ID)
+		}
+		if k.Secret == "" {
+			return nil, fmt.Errorf("secret of signing key %s required", k.ID)
+		}
+		if s.key(k.ID) != nil {
+			return nil, fmt.Errorf("duplicate signing key %s", k.ID)
+		}
+		s.keys = append(s.keys, signingKey{id: k.ID, secret: []byte(k.Secret)})
+	}
+	return s, nil
+}
+
+// sign returns val with the signature of the first key.
+func (s *signer) sign(val string) string {
+	k := s.keys[0]
+	iat := strconv.FormatInt(s.now().Unix(), 10)
+	return val + signatureField + k.id + ":" + iat + ":" + base64.RawURLEncoding.EncodeToString(k.mac(val, iat))
+}
+
+// verify returns the value of the X-Canary header without the signature,
+// it returns false when the header is not signed by one of the keys, or was signed more than maxAge ago.
+func (s *signer) verify(header http.Header) (string, bool) {
+	vals := header.Values(headerXCanary)
+	if len(vals) != 1 {
+		return "", false
+	}
+
+	i := strings.LastIndex(vals[0], signatureField)
+	if i <= 0 {
+		return "", false
+	}
+	val, sig := vals[0][:i], vals[0][i+len(signatureField):]
+	parts := strings.SplitN(sig, ":", 3)
+	if len(parts) != 3 {
+		return "", false
+	}
+
+	k := s.key(parts[0])
+	if k == nil {
+		return "", false
+	}
+	iat, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(mac, k.mac(val, parts[1])) {
+		return "", false
+	}
+
+	age := s.now().Sub(time.Unix(iat, 0))
+	if age > s.maxAge || age < -s.maxAge {
+		return "", false
+	}
+	return val, true
+}
+
+// signCookie returns the cookie value val with the signature of the first key,
+// unlike the X-Canary header, the signature of a cookie does not expire.
+func (s *signer) signCookie(val string) string {
+	k := s.keys[0]
+	return val + "." + k.id + ":" + base64.RawURLEncoding.EncodeToString(k.mac(cookieMACPrefix, val))
+}
+
+// verifyCookie returns the cookie value without the signature,
+// it returns false when the value is not signed by one of the keys.
+func (s *signer) verifyCookie(val string) (string, bool) {
+	i := strings.LastIndexByte(val, '.')
+	if i <= 0 {
+		return "", false
+	}
+	parts := strings.SplitN(val[i+1:], ":", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+
+	k := s.key(parts[0])
+	if k == nil {
+		return "", false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(mac, k.mac(cookieMACPrefix, val[:i])) {
+		return "", false
+	}
+	return val[:i], true
+}
+
+func (s *signer) key(id string) *signingKey {
+	for i := range s.keys {
+		if s.keys[i].id == id {
+			return &s.keys[i]
+		}
+	}
+	return nil
+}
+
+func (k *signingKey) mac(a, b string) []byte {
+	h := hmac.New(sha256.New, k.secret)
+	h.Write([]byte(a))
+	h.Write([]byte{':'})
+	h.Write([]byte(b))
+	return h.Sum(nil)
+}
@@ -0,0 +1,175 @@
+package canary
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	ptypes "github.com/traefik/paerser/types"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+)
+
+func TestSigner(t *testing.T) {
+	t.Run("newSigner should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		_, err := newSigner(&dynamic.CanarySigning{})
+		a.NotNil(err)
+		_, err = newSigner(&dynamic.CanarySigning{Keys: []dynamic.CanarySigningKey{{ID: "k:1", Secret: "secret"}}})
+		a.NotNil(err)
+		_, err = newSigner(&dynamic.CanarySigning{Keys: []dynamic.CanarySigningKey{{ID: "k1"}}})
+		a.NotNil(err)
+		_, err = newSigner(&dynamic.CanarySigning{Keys: []dynamic.CanarySigningKey{
+			{ID: "k1", Secret: "secret1"},
+			{ID: "k1", Secret: "secret2"},
+		}})
+		a.NotNil(err)
+
+		s, err := newSigner(&dynamic.CanarySigning{Keys: []dynamic.CanarySigningKey{
+			{ID: "k2", Secret: "secret2"},
+			{ID: "k1", Secret: "secret1"},
+		}})
+		a.Nil(err)
+		a.Equal(2, len(s.keys))
+	})
+
+	t.Run("sign and verify should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		s1, _ := newSigner(&dynamic.CanarySigning{Keys: []dynamic.CanarySigningKey{{ID: "k1", Secret: "secret1"}}})
+		s2, _ := newSigner(&dynamic.CanarySigning{Keys: []dynamic.CanarySigningKey{
+			{ID: "k2", Secret: "secret2"},
+			{ID: "k1", Secret: "secret1"},
+		}})
+
+		val := "label=beta,product=urbs,uid=u1"
+		signed := s1.sign(val)
+		a.True(strings.HasPrefix(signed, val+",sig=k1:"))
+
+		header := http.Header{}
+		header.Set(headerXCanary, signed)
+		v, ok := s1.verify(header)
+		a.True(ok)
+		a.Equal(val, v)
+
+		// rotated key
+		v, ok = s2.verify(header)
+		a.True(ok)
+		a.Equal(val, v)
+		header.Set(headerXCanary, s2.sign(val))
+		a.True(strings.Contains(header.Get(headerXCanary), ",sig=k2:"))
+		_, ok = s1.verify(header)
+		a.False(ok)
+
+		// tampered
+		header.Set(headerXCanary, strings.Replace(signed, "uid=u1", "uid=u2", 1))
+		_, ok = s1.verify(header)
+		a.False(ok)
+
+		// unsigned
+		header.Set(headerXCanary, val)
+		_, ok = s1.verify(header)
+		a.False(ok)
+
+		header.Set(headerXCanary, val+",sig=k1")
+		_, ok = s1.verify(header)
+		a.False(ok)
+
+		header.Set(headerXCanary, val+",sig=k1:invalid")
+		_, ok = s1.verify(header)
+		a.False(ok)
+
+		header.Set(headerXCanary, signed)
+		header.Add(headerXCanary, "label=dev")
+		_, ok = s1.verify(header)
+		a.False(ok)
+	})
+
+	t.Run("verify should reject expired signatures", func(t *testing.T) {
+		a := assert.New(t)
+
+		s, _ := newSigner(&dynamic.CanarySigning{
+			Keys:   []dynamic.CanarySigningKey{{ID: "k1", Secret: "secret1"}},
+			MaxAge: ptypes.Duration(time.Minute),
+		})
+		now := time.Now()
+		s.now = func() time.Time { return now }
+
+		val := "label=beta,product=urbs,uid=u1"
+		header := http.Header{}
+		header.Set(headerXCanary, s.sign(val))
+
+		now = now.Add(59 * time.Second)
+		v, ok := s.verify(header)
+		a.True(ok)
+		a.Equal(val, v)
+
+		// replayed
+		now = now.Add(2 * time.Second)
+		_, ok = s.verify(header)
+		a.False(ok)
+
+		// issued in the future
+		now = now.Add(-3 * time.Minute)
+		_, ok = s.verify(header)
+		a.False(ok)
+
+		// tampered issue time
+		signed := s.sign(val)
+		parts := strings.Split(signed, ":")
+		parts[1] = strconv.FormatInt(now.Add(time.Hour).Unix(), 10)
+		header.Set(headerXCanary, strings.Join(parts, ":"))
+		now = now.Add(time.Hour)
+		_, ok = s.verify(header)
+		a.False(ok)
+	})
+
+	t.Run("signed X-Canary header should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		next := http.NotFoundHandler()
+		signing := &dynamic.CanarySigning{Keys: []dynamic.CanarySigningKey{{ID: "k1", Secret: "secret1"}}}
+		public, err := New(context.Background(), next, dynamic.Canary{Product: "Urbs", Signing: signing}, "test-public", nil)
+		a.Nil(err)
+		internal, err := New(context.Background(), next, dynamic.Canary{Product: "Urbs", ForwardLabel: true, Signing: signing}, "test-internal", nil)
+		a.Nil(err)
+
+		req := httptest.NewRequest("GET", "http://example.com/foo?uid=u1", nil)
+		req.Header.Set(headerXCanary, "label=beta,uid=u2")
+		rw := httptest.NewRecorder()
+		public.processCanary(rw, req)
+		a.True(strings.HasPrefix(req.Header.Get(headerXCanary), "label=beta,product=Urbs,uid=u1,sig=k1:"))
+
+		internal.processCanary(httptest.NewRecorder(), req)
+		ch := &canaryHeader{}
+		ch.fromHeader(req.Header, true)
+		a.Equal("beta", ch.label)
+		a.Equal("u1", ch.uid)
+
+		d, err := Explain("test-internal", req)
+		a.Nil(err)
+		a.Equal("u1", d.UID)
+		a.False(d.Unverified)
+
+		// spoofed uid
+		req.Header.Set(headerXCanary, strings.Replace(req.Header.Get(headerXCanary), "uid=u1", "uid=u2", 1))
+		d, err = Explain("test-internal", req)
+		a.Nil(err)
+		a.Equal("", d.UID)
+		a.Equal("", d.XCanary)
+		a.True(d.Unverified)
+
+		internal.processCanary(httptest.NewRecorder(), req)
+		a.Equal("", req.Header.Get(headerXCanary))
+
+		// unsigned
+		req.Header.Set(headerXCanary, "label=beta,product=Urbs,uid=u2")
+		internal.processCanary(httptest.NewRecorder(), req)
+		a.Equal("", req.Header.Get(headerXCanary))
+	})
+}
@@ -0,0 +1,120 @@
+package canary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/log"
+	"github.com/traefik/traefik/v2/pkg/metrics"
+)
+
+// LabelSource loads user's labels from somewhere.
+type LabelSource interface {
+	// FetchLabels returns user's labels and the time (Unix seconds) they were built.
+	// An error means the source is unavailable and the next source should be tried,
+	// an unknown user should get empty labels without error.
+	FetchLabels(ctx context.Context, uid, requestID string) ([]Label, int64, error)
+	String() string
+}
+
+var errUnhealthy = errors.New("label source is unhealthy")
+
+// newLabelSources builds label sources from cfg.Server and cfg.Sources in order.
+func newLabelSources(ctx context.Context, cfg dynamic.Canary, name string, metricsRegistry metrics.Registry) ([]LabelSource, error) {
+	sources := make([]LabelSource, 0, len(cfg.Sources)+1)
+	if cfg.Server != "" {
+		client, err := getLabelClient(ctx, name, cfg.Server, cfg.ServerOptions, metricsRegistry)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, newHTTPSource(cfg.Server, cfg.Product, client))
+	}
+
+	for i, sc := range cfg.Sources {
+		switch {
+		case sc.Server != "":
+			client, err := getLabelClient(ctx, name, sc.Server, cfg.ServerOptions, metricsRegistry)
+			if err != nil {
+				return nil, fmt.Errorf("label source %d: %w", i, err)
+			}
+			sources = append(sources, newHTTPSource(sc.Server, cfg.Product, client))
+		case sc.File != "":
+			s, err := getFileSource(ctx, sc.File)
+			if err != nil {
+				return nil, fmt.Errorf("label source %d: %w", i, err)
+			}
+			sources = append(sources, s)
+		case sc.KV != nil:
+			s, err := getKVSource(sc.KV, cfg.Product)
+			if err != nil {
+				return nil, fmt.Errorf("label source %d: %w", i, err)
+			}
+			sources = append(sources, s)
+		default:
+			return nil, fmt.Errorf("label source %d: one of server, file or kv required", i)
+		}
+	}
+	return sources, nil
+}
+
+// fetchLabels tries sources in order and returns the labels of the first available one.
+// The error of the last source is returned when all the sources failed.
+func fetchLabels(ctx context.Context, logger log.Logger, sources []LabelSource, uid, requestID string) ([]Label, int64, error) {
+	err := errors.New("no label source")
+	for _, s := range sources {
+		var labels []Label
+		var ts int64
+		labels, ts, err = s.FetchLabels(ctx, uid, requestID)
+		if err == nil {
+			return labels, ts, nil
+		}
+		if err != errUnhealthy {
+			logger.Errorf("Fetch labels from %s failed: %v", s, err)
+		}
+	}
+	return nil, 0, err
+}
+
+type httpSource struct {
+	apiURL  string
+	product string
+	client  *labelClient
+}
+
+func newHTTPSource(server, product string, client *labelClient) *httpSource {
+	apiURL := server
+	// apiURL ex. https://labelServerHost/api/labels?uid=%s&product=%s
+	if !strings.Contains(apiURL, "%s") { // append default API path.
+		apiURL = strings.TrimSuffix(apiURL, "/")
+		apiURL += "/users/%s/labels:cache?product=%s"
+	}
+	return &httpSource{apiURL: apiURL, product: product, client: client}
+}
+
+// FetchLabels implements LabelSource interface.
+func (s *httpSource) FetchLabels(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
+	ts := time.Now().UTC().Unix()
+	if !s.client.hc.MaybeHealthy() {
+		return nil, ts, errUnhealthy
+	}
+
+	res, err := s.client.getUserLabels(ctx, fmt.Sprintf(s.apiURL, uid, s.product), requestID)
+	if err != nil {
+		return nil, ts, err
+	}
+	if res == nil { // request canceled
+		return []Label{}, ts, nil
+	}
+	if res.Timestamp > 0 && res.Timestamp < ts {
+		ts = res.Timestamp
+	}
+	return res.Result, ts, nil
+}
+
+func (s *httpSource) String() string {
+	return s.apiURL
+}
@@ -0,0 +1,138 @@
+package canary
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/traefik/traefik/v2/pkg/log"
+	"github.com/traefik/traefik/v2/pkg/safe"
+	"gopkg.in/fsnotify.v1"
+)
+
+var fileSourcesMu sync.Mutex
+var fileSources = make(map[string]*fileSource)
+
+// fileSource loads labels from a JSON file which maps uid to labels, ex.
+// {"5c4057f0be825b390667abee": [{"l": "beta"}], "5c4057f0be825b390667abef": [{"l": "dev", "cls": ["iOS"]}]}
+// The file is watched and reloaded on change, a broken file keeps the previous labels.
+type fileSource struct {
+	filename  string
+	mu        sync.RWMutex
+	labels    map[string][]Label
+	timestamp int64
+}
+
+// getFileSource returns the fileSource of filename, the sources are shared between middlewares,
+// so that one file is only watched once.
+func getFileSource(ctx context.Context, filename string) (*fileSource, error) {
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	fileSourcesMu.Lock()
+	defer fileSourcesMu.Unlock()
+
+	if s, ok := fileSources[filename]; ok {
+		return s, nil
+	}
+
+	s := &fileSource{filename: filename}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.watch(ctx); err != nil {
+		return nil, err
+	}
+	fileSources[filename] = s
+	return s, nil
+}
+
+func (s *fileSource) load() error {
+	data, err := os.ReadFile(s.filename)
+	if err != nil {
+		return fmt.Errorf("read labels file %s: %w", s.filename, err)
+	}
+
+	labels := make(map[string][]Label)
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return fmt.Errorf("parse labels file %s: %w", s.filename, err)
+	}
+	for _, ls := range labels {
+		for i := range ls {
+			ls[i].parseVersion()
+		}
+	}
+
+	s.mu.Lock()
+	s.labels = labels
+	s.timestamp = time.Now().UTC().Unix()
+	s.mu.Unlock()
+	return nil
+}
+
+// watch watches the directory rather than the file, so that files replaced by editors or
+// Kubernetes ConfigMap updates are still tracked.
+func (s *fileSource) watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating file watcher: %w", err)
+	}
+
+	dir, name := filepath.Split(s.filename)
+	if err = watcher.Add(dir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("error adding file watcher: %w", err)
+	}
+
+	logger := log.FromContext(ctx)
+	safe.Go(func() {
+		defer watcher.Close()
+		for {
+			select {
+			case evt, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Base(evt.Name) != name && filepath.Base(evt.Name) != "..data" {
+					continue
+				}
+				if _, err := os.Stat(s.filename); err != nil {
+					continue
+				}
+				if err := s.load(); err != nil {
+					logger.Errorf("Reload labels file failed: %v", err)
+				} else {
+					logger.Debugf("Labels file %s reloaded", s.filename)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Errorf("Labels file watcher event error: %s", err)
+			}
+		}
+	})
+	return nil
+}
+
+// FetchLabels implements LabelSource interface.
+func (s *fileSource) FetchLabels(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	labels := s.labels[uid]
+	if labels == nil {
+		labels = []Label{}
+	}
+	return labels, s.timestamp, nil
+}
+
+func (s *fileSource) String() string {
+	return s.filename
+}
@@ -0,0 +1,119 @@
+package canary
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abronan/valkeyrie"
+	"github.com/abronan/valkeyrie/store"
+	"github.com/abronan/valkeyrie/store/consul"
+	etcdv3 "github.com/abronan/valkeyrie/store/etcd/v3"
+	"github.com/abronan/valkeyrie/store/redis"
+	"github.com/abronan/valkeyrie/store/zookeeper"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+)
+
+const defaultKVRootKey = "canary"
+
+var kvSourcesMu sync.Mutex
+var kvSources = make(map[string]*kvSource)
+
+// kvSource loads labels from the KV stores supported by the KV providers,
+// the value of key `{rootKey}/{product}/{uid}` is a JSON array of labels.
+type kvSource struct {
+	prefix string
+	client store.Store
+}
+
+// getKVSource returns the kvSource of cfg and product, clients are shared between middlewares.
+func getKVSource(cfg *dynamic.KVLabelSource, product string) (*kvSource, error) {
+	rootKey := cfg.RootKey
+	if rootKey == "" {
+		rootKey = defaultKVRootKey
+	}
+	prefix := path.Join(rootKey, product)
+	key := fmt.Sprintf("%s|%s|%s|%s", cfg.Backend, strings.Join(cfg.Endpoints, ","), cfg.Username, prefix)
+
+	kvSourcesMu.Lock()
+	defer kvSourcesMu.Unlock()
+
+	if s, ok := kvSources[key]; ok {
+		return s, nil
+	}
+
+	client, err := createKVClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &kvSource{prefix: prefix, client: client}
+	kvSources[key] = s
+	return s, nil
+}
+
+func createKVClient(cfg *dynamic.KVLabelSource) (store.Store, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, fmt.Errorf("kv endpoints required")
+	}
+
+	var backend store.Backend
+	switch cfg.Backend {
+	case "consul":
+		consul.Register()
+		backend = store.CONSUL
+	case "etcd":
+		etcdv3.Register()
+		backend = store.ETCDV3
+	case "zookeeper":
+		zookeeper.Register()
+		backend = store.ZK
+	case "redis":
+		redis.Register()
+		backend = store.REDIS
+	default:
+		return nil, fmt.Errorf("unsupported kv backend %q", cfg.Backend)
+	}
+
+	storeConfig := &store.Config{
+		ConnectionTimeout: 3 * time.Second,
+		Bucket:            "traefik",
+		Username:          cfg.Username,
+		Password:          cfg.Password,
+	}
+
+	if cfg.TLS != nil {
+		var err error
+		storeConfig.TLS, err = cfg.TLS.CreateTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return valkeyrie.NewStore(backend, cfg.Endpoints, storeConfig)
+}
+
+// FetchLabels implements LabelSource interface.
+func (s *kvSource) FetchLabels(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
+	ts := time.Now().UTC().Unix()
+	pair, err := s.client.Get(path.Join(s.prefix, uid), nil)
+	if err == store.ErrKeyNotFound {
+		return []Label{}, ts, nil
+	}
+	if err != nil {
+		return nil, ts, fmt.Errorf("xRequestId: %s, get labels error: %w", requestID, err)
+	}
+
+	labels := []Label{}
+	if err = json.Unmarshal(pair.Value, &labels); err != nil {
+		return nil, ts, fmt.Errorf("xRequestId: %s, unmarshal labels error: %w, %s", requestID, err, string(pair.Value))
+	}
+	return labels, ts, nil
+}
+
+func (s *kvSource) String() string {
+	return s.prefix
+}
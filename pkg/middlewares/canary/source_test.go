@@ -0,0 +1,140 @@
+package canary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+)
+
+type mockSource struct {
+	labels []Label
+	err    error
+	calls  int
+}
+
+func (s *mockSource) FetchLabels(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
+	s.calls++
+	return s.labels, time.Now().Unix(), s.err
+}
+
+func (s *mockSource) String() string {
+	return "mock"
+}
+
+func TestLabelSources(t *testing.T) {
+	t.Run("newLabelSources should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		sources, err := newLabelSources(context.Background(), dynamic.Canary{Product: "Urbs"}, "test", nil)
+		a.Nil(err)
+		a.Equal(0, len(sources))
+
+		sources, err = newLabelSources(context.Background(), dynamic.Canary{
+			Product: "Urbs",
+			Server:  "http://localhost/",
+			Sources: []dynamic.LabelSource{{Server: "http://localhost:8080/api/labels?uid=%s&product=%s"}},
+		}, "test", nil)
+		a.Nil(err)
+		a.Equal(2, len(sources))
+		a.Equal("http://localhost/users/%s/labels:cache?product=%s", sources[0].String())
+		a.Equal("http://localhost:8080/api/labels?uid=%s&product=%s", sources[1].String())
+
+		_, err = newLabelSources(context.Background(), dynamic.Canary{Product: "Urbs", Sources: []dynamic.LabelSource{{}}}, "test", nil)
+		a.NotNil(err)
+
+		_, err = newLabelSources(context.Background(), dynamic.Canary{Product: "Urbs", Sources: []dynamic.LabelSource{{File: "not-exists.json"}}}, "test", nil)
+		a.NotNil(err)
+
+		_, err = newLabelSources(context.Background(), dynamic.Canary{Product: "Urbs", Sources: []dynamic.LabelSource{
+			{KV: &dynamic.KVLabelSource{Backend: "unknown", Endpoints: []string{"localhost:6379"}}},
+		}}, "test", nil)
+		a.NotNil(err)
+	})
+
+	t.Run("fetchLabels should fail over", func(t *testing.T) {
+		a := assert.New(t)
+
+		s1 := &mockSource{err: errors.New("down")}
+		s2 := &mockSource{labels: []Label{{Label: "beta"}}}
+		labels, ts, err := fetchLabels(context.Background(), logrus.StandardLogger(), []LabelSource{s1, s2}, "u1", "r1")
+		a.Nil(err)
+		a.Equal(1, len(labels))
+		a.Equal("beta", labels[0].Label)
+		a.True(ts > 0)
+		a.Equal(1, s1.calls)
+		a.Equal(1, s2.calls)
+
+		s2.err = errUnhealthy
+		_, _, err = fetchLabels(context.Background(), logrus.StandardLogger(), []LabelSource{s1, s2}, "u1", "r1")
+		a.Equal(errUnhealthy, err)
+	})
+
+	t.Run("httpSource should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		ts := time.Now().Add(-time.Minute).Unix()
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			a.Equal("/users/u1/labels:cache", req.URL.Path)
+			a.Equal("Urbs", req.URL.Query().Get("product"))
+			a.Equal("r1", req.Header.Get(headerXRequestID))
+			fmt.Fprintf(rw, `{"timestamp": %d, "result": [{"l": "beta", "cls": ["iOS"]}]}`, ts)
+		}))
+		defer server.Close()
+
+		client, err := getLabelClient(context.Background(), "test-http", server.URL, nil, nil)
+		a.Nil(err)
+		s := newHTTPSource(server.URL, "Urbs", client)
+		labels, t1, err := s.FetchLabels(context.Background(), "u1", "r1")
+		a.Nil(err)
+		a.Equal(ts, t1)
+		a.Equal(1, len(labels))
+		a.Equal("beta", labels[0].Label)
+		a.Equal([]string{"iOS"}, labels[0].Clients)
+	})
+
+	t.Run("fileSource should work and reload", func(t *testing.T) {
+		a := assert.New(t)
+
+		filename := filepath.Join(t.TempDir(), "labels.json")
+		a.Nil(os.WriteFile(filename, []byte(`{"u1": [{"l": "beta", "ver": ">=10.0"}]}`), 0o600))
+
+		s, err := getFileSource(context.Background(), filename)
+		a.Nil(err)
+		s2, err := getFileSource(context.Background(), filename)
+		a.Nil(err)
+		a.Equal(s, s2)
+
+		labels, _, err := s.FetchLabels(context.Background(), "u1", "")
+		a.Nil(err)
+		a.Equal(1, len(labels))
+		a.Equal("beta", labels[0].Label)
+		a.NotNil(labels[0].constraints)
+
+		labels, _, err = s.FetchLabels(context.Background(), "u2", "")
+		a.Nil(err)
+		a.NotNil(labels)
+		a.Equal(0, len(labels))
+
+		a.Nil(os.WriteFile(filename, []byte(`{"u2": [{"l": "dev"}]}`), 0o600))
+		a.Eventually(func() bool {
+			labels, _, _ := s.FetchLabels(context.Background(), "u2", "")
+			return len(labels) == 1 && labels[0].Label == "dev"
+		}, 3*time.Second, 20*time.Millisecond)
+
+		// broken file keeps the previous labels
+		a.Nil(os.WriteFile(filename, []byte(`{"u2": [`), 0o600))
+		time.Sleep(100 * time.Millisecond)
+		labels, _, _ = s.FetchLabels(context.Background(), "u2", "")
+		a.Equal(1, len(labels))
+	})
+}
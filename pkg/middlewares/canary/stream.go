@@ -0,0 +1,111 @@
+package canary
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/traefik/traefik/v2/pkg/job"
+	"github.com/traefik/traefik/v2/pkg/log"
+	"github.com/traefik/traefik/v2/pkg/safe"
+)
+
+var streamClient = &http.Client{Transport: newTransport(defaultDialTimeout, nil)}
+
+// invalidationStreams are the subscribed invalidation streams, keyed by url and product.
+var invalidationStreams = newSharedTasks()
+
+// invalidation is an event of the invalidation stream, ex.
+// data: {"product": "urbs", "uids": ["5c4057f0be825b390667abee"]}
+// The product of the middleware is used when product is empty,
+// all the cached labels of the product are dropped when uids is empty.
+type invalidation struct {
+	Product string   `json:"product"`
+	UIDs    []string `json:"uids"`
+}
+
+// subscribeInvalidation subscribes the server-sent events stream at url, and keeps reconnecting to it.
+// A stream is only subscribed once for a product, it is closed once all the middlewares
+// which subscribed it are released: when their ctx is done.
+func subscribeInvalidation(ctx context.Context, url, product string) {
+	logger := log.FromContext(ctx)
+	invalidationStreams.acquire(ctx, url+"|"+product, func(streamCtx context.Context) {
+		operation := func() error {
+			return readInvalidationStream(streamCtx, url, product, logger)
+		}
+		notify := func(err error, d time.Duration) {
+			logger.Errorf("Canary invalidation stream %s error: %v, retrying in %s", url, err, d)
+		}
+		bo := backoff.WithContext(job.NewBackOff(backoff.NewExponentialBackOff()), streamCtx)
+		err := backoff.RetryNotify(safe.OperationWithRecover(operation), bo, notify)
+		if err != nil && streamCtx.Err() == nil {
+			logger.Errorf("Cannot subscribe canary invalidation stream %s: %v", url, err)
+		}
+		logger.Debugf("Canary invalidation stream %s closed", url)
+	})
+}
+
+func readInvalidationStream(ctx context.Context, url, product string, logger log.Logger) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	req.Header.Set(headerUA, userAgent)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		switch {
+		case len(line) == 0: // dispatch the event
+			if data.Len() > 0 {
+				applyInvalidation(data.Bytes(), product, logger)
+				data.Reset()
+			}
+		case bytes.HasPrefix(line, []byte("data:")):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.Write(bytes.TrimPrefix(bytes.TrimPrefix(line, []byte("data:")), []byte(" ")))
+		}
+	}
+	if ctx.Err() != nil {
+		return backoff.Permanent(ctx.Err())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("stream closed")
+}
+
+func applyInvalidation(data []byte, product string, logger log.Logger) {
+	inv := &invalidation{}
+	if err := json.Unmarshal(data, inv); err != nil {
+		logger.Errorf("Invalid canary invalidation event %s: %v", string(data), err)
+		return
+	}
+	if inv.Product == "" {
+		inv.Product = product
+	}
+	n := InvalidateLabels(inv.Product, inv.UIDs...)
+	logger.Debugf("Canary invalidation event for product %s: %d entries dropped", inv.Product, n)
+}
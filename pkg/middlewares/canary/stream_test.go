@@ -0,0 +1,89 @@
+package canary
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+)
+
+func TestInvalidationStream(t *testing.T) {
+	t.Run("readInvalidationStream should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		cfg := dynamic.Canary{MaxCacheSize: 100, Server: "localhost5", Product: "T5"}
+		ls := NewLabelStore(logrus.StandardLogger(), cfg, time.Minute, time.Minute, "canary-stream", nil, nil)
+		var call int32
+		ls.fetch = func(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
+			atomic.AddInt32(&call, 1)
+			return []Label{{Label: requestID}}, time.Now().Unix(), nil
+		}
+		_ = ls.MustLoadLabels(context.Background(), "u1", "v1")
+		_ = ls.MustLoadLabels(context.Background(), "u2", "v1")
+		_ = ls.MustLoadLabels(context.Background(), "u3", "v1")
+
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			a.Equal("text/event-stream", req.Header.Get("Accept"))
+			rw.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(rw, ": comment\n\n")
+			fmt.Fprint(rw, "event: invalidate\ndata: {\"uids\": [\"u1\"]}\n\n")
+			fmt.Fprint(rw, "data: {\"product\": \"T5\",\n")
+			fmt.Fprint(rw, "data: \"uids\": [\"u2\"]}\n\n")
+			fmt.Fprint(rw, "data: invalid\n\n")
+			fmt.Fprint(rw, "data: {\"product\": \"other\"}\n\n")
+		}))
+		defer server.Close()
+
+		err := readInvalidationStream(context.Background(), server.URL, "T5", logrus.StandardLogger())
+		a.EqualError(err, "stream closed")
+
+		labels := ls.MustLoadLabels(context.Background(), "u1", "v2")
+		a.Equal("v2", labels[0].Label)
+		labels = ls.MustLoadLabels(context.Background(), "u2", "v2")
+		a.Equal("v2", labels[0].Label)
+		labels = ls.MustLoadLabels(context.Background(), "u3", "v2")
+		a.Equal("v1", labels[0].Label)
+		a.Equal(int32(5), call)
+
+		server.Close()
+		a.NotNil(readInvalidationStream(context.Background(), server.URL, "T5", logrus.StandardLogger()))
+	})
+	t.Run("subscribeInvalidation should be shared and closed with the middlewares", func(t *testing.T) {
+		a := assert.New(t)
+
+		var connected, closed int32
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&connected, 1)
+			rw.Header().Set("Content-Type", "text/event-stream")
+			rw.(http.Flusher).Flush()
+			<-req.Context().Done()
+			atomic.AddInt32(&closed, 1)
+		}))
+		defer server.Close()
+
+		ctx1, cancel1 := context.WithCancel(context.Background())
+		ctx2, cancel2 := context.WithCancel(context.Background())
+		subscribeInvalidation(ctx1, server.URL, "T6")
+		subscribeInvalidation(ctx2, server.URL, "T6")
+
+		a.Eventually(func() bool { return atomic.LoadInt32(&connected) == 1 }, time.Second, 10*time.Millisecond)
+		a.Equal(1, invalidationStreams.running())
+
+		cancel1()
+		time.Sleep(50 * time.Millisecond)
+		a.Equal(int32(0), atomic.LoadInt32(&closed))
+		a.Equal(1, invalidationStreams.running())
+
+		cancel2()
+		a.Eventually(func() bool { return atomic.LoadInt32(&closed) == 1 }, time.Second, 10*time.Millisecond)
+		a.Eventually(func() bool { return invalidationStreams.running() == 0 }, time.Second, 10*time.Millisecond)
+		a.Equal(int32(1), atomic.LoadInt32(&connected))
+	})
+}
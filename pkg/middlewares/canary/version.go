@@ -0,0 +1,36 @@
+package canary
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	goversion "github.com/hashicorp/go-version"
+)
+
+var constraintTokenReg = regexp.MustCompile(`(~>|>=|<=|!=|=|>|<)?\s*[^\s,<>=!~]+`)
+
+// parseVersionConstraints parses a version constraint such as ">=10.0.0 <11" or ">= 10.0, < 11".
+// A bare version ("v10.2") means equality.
+func parseVersionConstraints(s string) (goversion.Constraints, error) {
+	tokens := constraintTokenReg.FindAllString(s, -1)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("invalid version constraint %q", s)
+	}
+	if rest := constraintTokenReg.ReplaceAllString(s, ""); strings.Trim(rest, " ,") != "" {
+		return nil, fmt.Errorf("invalid version constraint %q", s)
+	}
+	return goversion.NewConstraint(strings.Join(tokens, ","))
+}
+
+// matchVersion reports whether ver satisfies the constraints, an unparsable version never matches.
+func matchVersion(constraints goversion.Constraints, ver string) bool {
+	if ver == "" {
+		return false
+	}
+	v, err := goversion.NewVersion(ver)
+	if err != nil {
+		return false
+	}
+	return constraints.Check(v)
+}
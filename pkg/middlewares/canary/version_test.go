@@ -0,0 +1,41 @@
+package canary
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionConstraints(t *testing.T) {
+	t.Run("parseVersionConstraints should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		for _, s := range []string{"10.2", "v10.2", ">=10.2", ">= 10.2", ">=10.0.0 <11", ">=10.0.0, <11", "~> 10.1", "!=10.1.1"} {
+			_, err := parseVersionConstraints(s)
+			a.Nil(err, s)
+		}
+
+		for _, s := range []string{"", " ", ">=", ">=abc", "=>10.0", ">=10.0 & <11"} {
+			_, err := parseVersionConstraints(s)
+			a.NotNil(err, s)
+		}
+	})
+
+	t.Run("matchVersion should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		c, err := parseVersionConstraints(">=10.0.0 <11")
+		a.Nil(err)
+		a.True(matchVersion(c, "10.0"))
+		a.True(matchVersion(c, "v10.2.1"))
+		a.False(matchVersion(c, "9.9.9"))
+		a.False(matchVersion(c, "11.0"))
+		a.False(matchVersion(c, ""))
+		a.False(matchVersion(c, "latest"))
+
+		c, err = parseVersionConstraints("v10.2")
+		a.Nil(err)
+		a.True(matchVersion(c, "10.2.0"))
+		a.False(matchVersion(c, "10.2.1"))
+	})
+}
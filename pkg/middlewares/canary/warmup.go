@@ -0,0 +1,212 @@
+package canary
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/log"
+	"github.com/traefik/traefik/v2/pkg/metrics"
+	"github.com/traefik/traefik/v2/pkg/middlewares/requestid"
+)
+
+const (
+	defaultWarmupBatchSize       = 100
+	defaultWarmupMaxUIDs         = 10000
+	defaultWarmupPersistInterval = time.Minute
+)
+
+// warmups are the running warmups, keyed by middleware name and configuration.
+var warmups = newSharedTasks()
+
+// warmup persists the recently seen uids of a LabelStore to a file, and prefetches their labels
+// with the batch endpoint of the label server when the middleware is created,
+// so that a restarted Traefik does not request the label server for every uid.
+type warmup struct {
+	logger          log.Logger
+	ls              *LabelStore
+	client          *labelClient
+	batchURL        string
+	file            string
+	batchSize       int
+	maxUIDs         int
+	persistInterval time.Duration
+}
+
+func newWarmup(ctx context.Context, logger log.Logger, cfg dynamic.Canary, name string, ls *LabelStore, metricsRegistry metrics.Registry) (*warmup, error) {
+	if cfg.Server == "" {
+		return nil, errors.New("warmup requires the label server")
+	}
+	if cfg.Warmup.File == "" {
+		return nil, errors.New("warmup file required")
+	}
+
+	batchURL := cfg.Warmup.BatchURL
+	if batchURL == "" {
+		if strings.Contains(cfg.Server, "%s") {
+			return nil, errors.New("warmup batchURL required when server is a URL template")
+		}
+		batchURL = strings.TrimSuffix(cfg.Server, "/") + "/users/labels:batch?product=%s"
+	}
+	if strings.Contains(batchURL, "%s") {
+		batchURL = fmt.Sprintf(batchURL, cfg.Product)
+	}
+
+	// share the client and its circuit breaker with the label source of the server
+	client, err := getLabelClient(ctx, name, cfg.Server, cfg.ServerOptions, metricsRegistry)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &warmup{
+		logger:          logger,
+		ls:              ls,
+		client:          client,
+		batchURL:        batchURL,
+		file:            cfg.Warmup.File,
+		batchSize:       cfg.Warmup.BatchSize,
+		maxUIDs:         cfg.Warmup.MaxUIDs,
+		persistInterval: time.Duration(cfg.Warmup.PersistInterval),
+	}
+	if w.batchSize <= 0 {
+		w.batchSize = defaultWarmupBatchSize
+	}
+	if w.maxUIDs <= 0 {
+		w.maxUIDs = defaultWarmupMaxUIDs
+	}
+	if w.persistInterval <= 0 {
+		w.persistInterval = defaultWarmupPersistInterval
+	}
+	return w, nil
+}
+
+// startWarmup starts w for the middleware name, unless the warmup of the same configuration is running already:
+// New is called for every router using the middleware, and on every configuration reload.
+// The warmup is stopped once all the middlewares of its configuration are released,
+// it persists the uids one last time before stopping.
+func startWarmup(ctx context.Context, name string, cfg dynamic.Canary, w *warmup) {
+	warmups.acquire(ctx, warmupKey(name, cfg), w.run)
+}
+
+func warmupKey(name string, cfg dynamic.Canary) string {
+	h := fnv.New64a()
+	_ = json.NewEncoder(h).Encode(cfg)
+	return fmt.Sprintf("%s|%x", name, h.Sum64())
+}
+
+func (w *warmup) run(ctx context.Context) {
+	w.prefetch(ctx)
+
+	ticker := time.NewTicker(w.persistInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mustPersist()
+		case <-ctx.Done():
+			w.mustPersist()
+			return
+		}
+	}
+}
+
+// prefetch loads the labels of the persisted uids which are not cached yet.
+func (w *warmup) prefetch(ctx context.Context) {
+	data, err := os.ReadFile(w.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			w.logger.Errorf("Read warmup file failed: %v", err)
+		}
+		return
+	}
+
+	uids := []string{}
+	if err = json.Unmarshal(data, &uids); err != nil {
+		w.logger.Errorf("Parse warmup file %s failed: %v", w.file, err)
+		return
+	}
+
+	uids = w.ls.s.missingKeys(uids)
+	n := 0
+	for i := 0; i < len(uids); i += w.batchSize {
+		end := i + w.batchSize
+		if end > len(uids) {
+			end = len(uids)
+		}
+
+		if !w.client.hc.MaybeHealthy() {
+			w.logger.Errorf("Warmup stopped after %d uids: %v", n, errUnhealthy)
+			return
+		}
+
+		res, err := w.client.getBatchLabels(ctx, w.batchURL, uids[i:end], requestid.NewUUID())
+		if err != nil {
+			w.logger.Errorf("Warmup stopped after %d uids: %v", n, err)
+			return
+		}
+		if res == nil { // canceled
+			return
+		}
+
+		ts := time.Now().UTC().Unix()
+		if res.Timestamp > 0 && res.Timestamp < ts {
+			ts = res.Timestamp
+		}
+		expireAt := time.Unix(ts, 0).Add(w.ls.expiration)
+		for _, uid := range uids[i:end] {
+			labels := res.Result[uid]
+			if labels == nil {
+				labels = []Label{}
+			}
+			for j := range labels {
+				labels[j].parseVersion()
+			}
+			if w.ls.s.warm(uid, labels, expireAt) {
+				n++
+			}
+		}
+	}
+	w.logger.Infof("Warmup loaded labels of %d uids from %s", n, w.file)
+}
+
+func (w *warmup) mustPersist() {
+	if err := w.persist(); err != nil {
+		w.logger.Errorf("Persist warmup file failed: %v", err)
+	}
+}
+
+// persist writes the recently seen uids to a temporary file which then replaces the warmup file,
+// so that the warmup file is never partially written.
+func (w *warmup) persist() error {
+	uids := w.ls.s.recentKeys(w.maxUIDs)
+	if len(uids) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(uids)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(w.file), filepath.Base(w.file)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), w.file)
+}
@@ -0,0 +1,148 @@
+package canary
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+)
+
+func TestWarmup(t *testing.T) {
+	t.Run("newWarmup should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		cfg := dynamic.Canary{Product: "Urbs", Server: "http://localhost/", Warmup: &dynamic.CanaryWarmup{File: "uids.json"}}
+		w, err := newWarmup(context.Background(), logrus.StandardLogger(), cfg, "test-warmup", nil, nil)
+		a.Nil(err)
+		a.Equal("http://localhost/users/labels:batch?product=Urbs", w.batchURL)
+		a.Equal(defaultWarmupBatchSize, w.batchSize)
+		a.Equal(defaultWarmupMaxUIDs, w.maxUIDs)
+		a.Equal(defaultWarmupPersistInterval, w.persistInterval)
+
+		cfg.Server = "http://localhost/labels?uid=%s&product=%s"
+		_, err = newWarmup(context.Background(), logrus.StandardLogger(), cfg, "test-warmup", nil, nil)
+		a.NotNil(err)
+
+		cfg.Warmup.BatchURL = "http://localhost/labels?product=%s"
+		w, err = newWarmup(context.Background(), logrus.StandardLogger(), cfg, "test-warmup", nil, nil)
+		a.Nil(err)
+		a.Equal("http://localhost/labels?product=Urbs", w.batchURL)
+
+		cfg.Warmup.File = ""
+		_, err = newWarmup(context.Background(), logrus.StandardLogger(), cfg, "test-warmup", nil, nil)
+		a.NotNil(err)
+
+		cfg.Server = ""
+		cfg.Warmup.File = "uids.json"
+		_, err = newWarmup(context.Background(), logrus.StandardLogger(), cfg, "test-warmup", nil, nil)
+		a.NotNil(err)
+	})
+
+	t.Run("prefetch and persist should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		var batches int32
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			a.Equal(http.MethodPost, req.Method)
+			a.Equal("/users/labels:batch", req.URL.Path)
+			a.Equal("Urbs", req.URL.Query().Get("product"))
+			atomic.AddInt32(&batches, 1)
+
+			body := batchLabelsReq{}
+			a.Nil(json.NewDecoder(req.Body).Decode(&body))
+			a.True(len(body.UIDs) <= 2)
+
+			res := batchLabelsRes{Timestamp: time.Now().Unix(), Result: make(map[string][]Label)}
+			for _, uid := range body.UIDs {
+				if uid != "u3" {
+					res.Result[uid] = []Label{{Label: "beta-" + uid, Version: ">=1.0"}}
+				}
+			}
+			a.Nil(json.NewEncoder(rw).Encode(res))
+		}))
+		defer server.Close()
+
+		filename := filepath.Join(t.TempDir(), "uids.json")
+		a.Nil(os.WriteFile(filename, []byte(`["u1", "u2", "u3", "u4"]`), 0o600))
+
+		cfg := dynamic.Canary{
+			Product:      "Urbs",
+			Server:       server.URL,
+			MaxCacheSize: 100,
+			Warmup:       &dynamic.CanaryWarmup{File: filename, BatchSize: 2, MaxUIDs: 3},
+		}
+		ls := NewLabelStore(logrus.StandardLogger(), cfg, time.Minute, time.Minute, "canary-warmup", nil, nil)
+		ls.fetch = func(ctx context.Context, uid, requestID string) ([]Label, int64, error) {
+			return []Label{{Label: requestID}}, time.Now().Unix(), nil
+		}
+		labels := ls.MustLoadLabels(context.Background(), "u4", "v1")
+		a.Equal("v1", labels[0].Label)
+
+		w, err := newWarmup(context.Background(), logrus.StandardLogger(), cfg, "canary-warmup", ls, nil)
+		a.Nil(err)
+		w.prefetch(context.Background())
+		a.Equal(int32(2), atomic.LoadInt32(&batches))
+
+		labels = ls.MustLoadLabels(context.Background(), "u1", "v2")
+		a.Equal("beta-u1", labels[0].Label)
+		a.NotNil(labels[0].constraints)
+		labels = ls.MustLoadLabels(context.Background(), "u3", "v2")
+		a.Equal(0, len(labels))
+		// cached uid is not overwritten
+		labels = ls.MustLoadLabels(context.Background(), "u4", "v2")
+		a.Equal("v1", labels[0].Label)
+
+		a.Nil(w.persist())
+		data, err := os.ReadFile(filename)
+		a.Nil(err)
+		uids := []string{}
+		a.Nil(json.Unmarshal(data, &uids))
+		a.Equal(3, len(uids))
+		for _, uid := range uids {
+			a.Contains([]string{"u1", "u2", "u3", "u4"}, uid)
+		}
+	})
+	t.Run("startWarmup should start a warmup once per configuration", func(t *testing.T) {
+		a := assert.New(t)
+
+		cfg := dynamic.Canary{
+			Product:      "Urbs",
+			Server:       "http://localhost/",
+			MaxCacheSize: 100,
+			Warmup:       &dynamic.CanaryWarmup{File: filepath.Join(t.TempDir(), "uids.json")},
+		}
+		ls := NewLabelStore(logrus.StandardLogger(), cfg, time.Minute, time.Minute, "canary-warmup-start", nil, nil)
+
+		ctx1, cancel1 := context.WithCancel(context.Background())
+		ctx2, cancel2 := context.WithCancel(context.Background())
+		for _, ctx := range []context.Context{ctx1, ctx2} {
+			w, err := newWarmup(context.Background(), logrus.StandardLogger(), cfg, "canary-warmup-start", ls, nil)
+			a.Nil(err)
+			startWarmup(ctx, "canary-warmup-start", cfg, w)
+		}
+		a.Equal(1, warmups.running())
+
+		changed := cfg
+		changed.Warmup = &dynamic.CanaryWarmup{File: cfg.Warmup.File, BatchSize: 10}
+		ctx3, cancel3 := context.WithCancel(context.Background())
+		w, err := newWarmup(context.Background(), logrus.StandardLogger(), changed, "canary-warmup-start", ls, nil)
+		a.Nil(err)
+		startWarmup(ctx3, "canary-warmup-start", changed, w)
+		a.Equal(2, warmups.running())
+
+		cancel1()
+		cancel2()
+		a.Eventually(func() bool { return warmups.running() == 1 }, time.Second, 10*time.Millisecond)
+		cancel3()
+		a.Eventually(func() bool { return warmups.running() == 0 }, time.Second, 10*time.Millisecond)
+	})
+}
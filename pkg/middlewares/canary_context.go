@@ -0,0 +1,28 @@
+package middlewares
+
+import "context"
+
+type canaryKey struct{}
+
+// Canary is the canary decision of a request, it is added to the requests metrics of services.
+type Canary struct {
+	// Label is the label resolved by the canary middleware,
+	// the labels which clients are free to set are replaced by a fixed value unless they are configured.
+	Label string
+	// Served is set by the labeled balancer to the label of the service which served the request,
+	// it is empty for the default service. Unlike Label, it is one of the labels of the services.
+	Served string
+	// Fallback is set by the labeled balancer when the request is served by its default service despite its label.
+	Fallback bool
+}
+
+// WithCanary returns a copy of ctx which carries the canary decision of the request with label.
+func WithCanary(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, canaryKey{}, &Canary{Label: label})
+}
+
+// CanaryFromContext returns the canary decision carried by ctx, which is set by the canary middleware, or nil.
+func CanaryFromContext(ctx context.Context) *Canary {
+	c, _ := ctx.Value(canaryKey{}).(*Canary)
+	return c
+}
@@ -25,10 +25,13 @@ func GetSourceExtractor(ctx context.Context, sourceMatcher *dynamic.SourceCriter
 		if sourceMatcher.RequestHeaderName != "" && sourceMatcher.RequestHost {
 			return nil, errors.New("requestHost and RequestHeaderName are mutually exclusive")
 		}
+		if sourceMatcher.Key != nil && (sourceMatcher.RequestHeaderName != "" || sourceMatcher.RequestHost) {
+			return nil, errors.New("key is mutually exclusive with RequestHeaderName and RequestHost")
+		}
 	}
 
 	if sourceMatcher == nil ||
-		sourceMatcher.IPStrategy == nil &&
+		sourceMatcher.IPStrategy == nil && sourceMatcher.Key == nil &&
 			sourceMatcher.RequestHeaderName == "" && !sourceMatcher.RequestHost {
 		sourceMatcher = &dynamic.SourceCriterion{
 			IPStrategy: &dynamic.IPStrategy{},
@@ -36,6 +39,21 @@ func GetSourceExtractor(ctx context.Context, sourceMatcher *dynamic.SourceCriter
 	}
 
 	logger := log.FromContext(ctx)
+	if sourceMatcher.Key != nil {
+		// the IP strategy resolves the client IP of the key.
+		ipStrategy := sourceMatcher.IPStrategy
+		if ipStrategy == nil {
+			ipStrategy = &dynamic.IPStrategy{}
+		}
+		strategy, err := ipStrategy.Get()
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Debug("Using Key")
+		return newSourceKey(sourceMatcher.Key, strategy)
+	}
+
 	if sourceMatcher.IPStrategy != nil {
 		strategy, err := sourceMatcher.IPStrategy.Get()
 		if err != nil {
@@ -29,7 +29,7 @@ func New(ctx context.Context, next http.Handler, config dynamic.InFlightReq, nam
 	log.FromContext(ctxLog).Debug("Creating middleware")
 
 	if config.SourceCriterion == nil ||
-		config.SourceCriterion.IPStrategy == nil &&
+		config.SourceCriterion.IPStrategy == nil && config.SourceCriterion.Key == nil &&
 			config.SourceCriterion.RequestHeaderName == "" && !config.SourceCriterion.RequestHost {
 		config.SourceCriterion = &dynamic.SourceCriterion{
 			RequestHost: true,
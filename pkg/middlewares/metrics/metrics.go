@@ -33,6 +33,7 @@ type metricsMiddleware struct {
 	reqDurationHistogram metrics.ScalableHistogram
 	openConnsGauge       gokitmetrics.Gauge
 	baseLabels           []string
+	canaryLabel          bool // adds the label resolved by the canary middleware to the requests metrics
 }
 
 // NewEntryPointMiddleware creates a new metrics middleware for an Entrypoint.
@@ -74,6 +75,7 @@ func NewServiceMiddleware(ctx context.Context, next http.Handler, registry metri
 		reqDurationHistogram: registry.ServiceReqDurationHistogram(),
 		openConnsGauge:       registry.ServiceOpenConnsGauge(),
 		baseLabels:           []string{"service", serviceName},
+		canaryLabel:          registry.IsSvcCanaryEnabled(),
 	}
 }
 
@@ -121,6 +123,14 @@ func (m *metricsMiddleware) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 	m.next.ServeHTTP(recorder, req)
 
 	labels = append(labels, "code", strconv.Itoa(recorder.getCode()))
+	if m.canaryLabel {
+		canary := middlewares.CanaryFromContext(req.Context())
+		if canary == nil {
+			canary = &middlewares.Canary{}
+		}
+		// the label of the served service rather than the one of the request, which is set by the clients.
+		labels = append(labels, "canary", canary.Served, "canary_fallback", strconv.FormatBool(canary.Fallback))
+	}
 
 	histograms := m.reqDurationHistogram.With(labels...)
 	histograms.ObserveFromStart(start)
@@ -8,6 +8,8 @@ import (
 
 	"github.com/go-kit/kit/metrics"
 	"github.com/stretchr/testify/assert"
+	traefikmetrics "github.com/traefik/traefik/v2/pkg/metrics"
+	"github.com/traefik/traefik/v2/pkg/middlewares"
 )
 
 // CollectingCounter is a metrics.Counter implementation that enables access to the CounterValue and LastLabelValues.
@@ -98,3 +100,60 @@ func TestCloseNotifier(t *testing.T) {
 		})
 	}
 }
+
+func TestCanaryLabels(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		canary   *middlewares.Canary
+		expected []string
+	}{
+		{
+			desc:     "without canary middleware",
+			expected: []string{"canary", "", "canary_fallback", "false"},
+		},
+		{
+			desc:     "served by the default service",
+			canary:   &middlewares.Canary{Label: "beta"},
+			expected: []string{"canary", "", "canary_fallback", "false"},
+		},
+		{
+			desc:     "served by a labeled service",
+			canary:   &middlewares.Canary{Label: "beta", Served: "beta"},
+			expected: []string{"canary", "beta", "canary_fallback", "false"},
+		},
+		{
+			desc:     "with fallback",
+			canary:   &middlewares.Canary{Label: "beta", Fallback: true},
+			expected: []string{"canary", "", "canary_fallback", "true"},
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			registry := traefikmetrics.NewVoidRegistry()
+			counter := &CollectingCounter{}
+			m := &metricsMiddleware{
+				next:                 http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}),
+				reqsCounter:          counter,
+				reqsTLSCounter:       registry.ServiceReqsTLSCounter(),
+				reqDurationHistogram: registry.ServiceReqDurationHistogram(),
+				openConnsGauge:       registry.ServiceOpenConnsGauge(),
+				baseLabels:           []string{"service", "core"},
+				canaryLabel:          true,
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.canary != nil {
+				req = req.WithContext(middlewares.WithCanary(req.Context(), test.canary.Label))
+				middlewares.CanaryFromContext(req.Context()).Served = test.canary.Served
+				middlewares.CanaryFromContext(req.Context()).Fallback = test.canary.Fallback
+			}
+			m.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, test.expected, counter.LastLabelValues[len(counter.LastLabelValues)-4:])
+		})
+	}
+}
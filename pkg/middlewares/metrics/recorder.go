@@ -12,6 +12,14 @@ type recorder interface {
 	getCode() int
 }
 
+// ServeRecorded serves req with next, and returns the status code of the response.
+// As for the metrics middleware, the http.CloseNotifier of rw is preserved.
+func ServeRecorded(next http.Handler, rw http.ResponseWriter, req *http.Request) int {
+	rec := newResponseRecorder(rw)
+	next.ServeHTTP(rec, req)
+	return rec.getCode()
+}
+
 func newResponseRecorder(rw http.ResponseWriter) recorder {
 	rec := &responseRecorder{
 		ResponseWriter: rw,
@@ -61,3 +69,11 @@ func (r *responseRecorder) Flush() {
 		f.Flush()
 	}
 }
+
+// Push initiates an HTTP/2 server push.
+func (r *responseRecorder) Push(target string, opts *http.PushOptions) error {
+	if p, ok := r.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
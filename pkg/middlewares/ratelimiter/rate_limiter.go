@@ -44,7 +44,7 @@ func New(ctx context.Context, next http.Handler, config dynamic.RateLimit, name
 	log.FromContext(ctxLog).Debug("Creating middleware")
 
 	if config.SourceCriterion == nil ||
-		config.SourceCriterion.IPStrategy == nil &&
+		config.SourceCriterion.IPStrategy == nil && config.SourceCriterion.Key == nil &&
 			config.SourceCriterion.RequestHeaderName == "" && !config.SourceCriterion.RequestHost {
 		config.SourceCriterion = &dynamic.SourceCriterion{
 			IPStrategy: &dynamic.IPStrategy{},
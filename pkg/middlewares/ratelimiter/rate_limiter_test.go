@@ -74,6 +74,18 @@ func TestNewRateLimiter(t *testing.T) {
 			},
 			expectedError: "iPStrategy and RequestHeaderName are mutually exclusive",
 		},
+		{
+			desc: "Key is exclusive with the other SourceCriteria",
+			config: dynamic.RateLimit{
+				Average: 200,
+				Burst:   10,
+				SourceCriterion: &dynamic.SourceCriterion{
+					RequestHost: true,
+					Key:         &dynamic.SourceKey{UID: true},
+				},
+			},
+			expectedError: "key is mutually exclusive with RequestHeaderName and RequestHost",
+		},
 	}
 
 	for _, test := range testCases {
@@ -0,0 +1,200 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/log"
+	"github.com/traefik/traefik/v2/pkg/middlewares"
+	"github.com/traefik/traefik/v2/pkg/middlewares/accesslog"
+	"github.com/traefik/traefik/v2/pkg/tracing"
+)
+
+const (
+	typeName = "RequestID"
+
+	// DefaultHeaderName is the default header of the request id.
+	DefaultHeaderName = "X-Request-Id"
+	// LogDataKey is the access log field of the request id.
+	LogDataKey = "XRequestID"
+
+	headerTraceparent = "Traceparent"
+)
+
+// Formats of the generated request ids.
+const (
+	FormatUUID    = "uuid"
+	FormatULID    = "ulid"
+	FormatTraceID = "traceid"
+)
+
+// Resolver resolves the request id of requests.
+type Resolver struct {
+	requestHeaders    []string
+	headerName        string
+	generate          func() string
+	first             bool
+	addResponseHeader bool
+}
+
+// NewResolver creates a Resolver, the zero values of config are defaulted.
+func NewResolver(config dynamic.RequestID) (*Resolver, error) {
+	r := &Resolver{
+		requestHeaders:    config.RequestHeaders,
+		headerName:        config.HeaderName,
+		first:             config.First,
+		addResponseHeader: config.AddResponseHeader,
+	}
+	if r.headerName == "" {
+		r.headerName = DefaultHeaderName
+	}
+	if len(r.requestHeaders) == 0 {
+		r.requestHeaders = []string{r.headerName}
+	}
+
+	switch config.Format {
+	case "", FormatUUID:
+		r.generate = NewUUID
+	case FormatULID:
+		r.generate = NewULID
+	case FormatTraceID:
+		r.generate = NewTraceID
+	default:
+		return nil, fmt.Errorf("unsupported request id format %q", config.Format)
+	}
+	return r, nil
+}
+
+// Resolve returns the request id of req: the request ids of its request headers, or a generated one.
+func (r *Resolver) Resolve(req *http.Request) string {
+	var requestIDs []string
+	for _, name := range r.requestHeaders {
+		v := req.Header.Get(name)
+		if http.CanonicalHeaderKey(name) == headerTraceparent {
+			// extract the trace-id, https://www.w3.org/TR/trace-context/#traceparent-header
+			if len(v) < 55 {
+				continue
+			}
+			v = v[3:35]
+		}
+		if v == "" {
+			continue
+		}
+		if r.first {
+			return v
+		}
+		requestIDs = append(requestIDs, v)
+	}
+	if len(requestIDs) == 0 {
+		return r.generate()
+	}
+	return strings.Join(requestIDs, ", ")
+}
+
+// Apply sets the request id of req on its header, on the response header when enabled,
+// on the tracing span and in the access log.
+func (r *Resolver) Apply(rw http.ResponseWriter, req *http.Request) string {
+	requestID := r.Resolve(req)
+	req.Header.Set(r.headerName, requestID)
+	if r.addResponseHeader {
+		rw.Header().Set(r.headerName, requestID)
+	}
+
+	if span := opentracing.SpanFromContext(req.Context()); span != nil {
+		span.SetTag("x-request-id", requestID)
+	}
+	if logData := accesslog.GetLogData(req); logData != nil {
+		logData.Core[LogDataKey] = requestID
+	}
+	return requestID
+}
+
+type requestID struct {
+	next     http.Handler
+	name     string
+	resolver *Resolver
+}
+
+// New creates a request id middleware.
+func New(ctx context.Context, next http.Handler, config dynamic.RequestID, name string) (http.Handler, error) {
+	log.FromContext(middlewares.GetLoggerCtx(ctx, name, typeName)).Debug("Creating middleware")
+
+	resolver, err := NewResolver(config)
+	if err != nil {
+		return nil, err
+	}
+	return &requestID{next: next, name: name, resolver: resolver}, nil
+}
+
+func (r *requestID) GetTracingInformation() (string, ext.SpanKindEnum) {
+	return r.name, tracing.SpanKindNoneEnum
+}
+
+func (r *requestID) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	r.resolver.Apply(rw, req)
+	r.next.ServeHTTP(rw, req)
+}
+
+// NewUUID returns a random UUID (version 4).
+func NewUUID() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+
+	// https://tools.ietf.org/html/rfc4122#section-4.1.3
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], id[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], id[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], id[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], id[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], id[10:])
+	return string(buf)
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID, https://github.com/ulid/spec: 48 bits of milliseconds and 80 random bits,
+// encoded in 26 characters of Crockford's base32.
+func NewULID() string {
+	var id [16]byte
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	binary.BigEndian.PutUint16(id[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(id[2:6], uint32(ms))
+	_, _ = rand.Read(id[6:])
+
+	// 128 bits in 26 characters of 5 bits, the first character holds the 3 highest bits.
+	hi := binary.BigEndian.Uint64(id[0:8])
+	lo := binary.BigEndian.Uint64(id[8:16])
+	buf := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		buf[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buf)
+}
+
+// NewTraceID returns a random W3C trace-id, 32 lowercase hexadecimal characters which are not all zero.
+func NewTraceID() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	if id == [16]byte{} {
+		id[15] = 1
+	}
+	return hex.EncodeToString(id[:])
+}
@@ -0,0 +1,158 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/middlewares/accesslog"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+var (
+	uuidReg    = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ulidReg    = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+	traceIDReg = regexp.MustCompile(`^[0-9a-f]{32}$`)
+)
+
+func TestNewResolver(t *testing.T) {
+	_, err := NewResolver(dynamic.RequestID{Format: "snowflake"})
+	assert.Error(t, err)
+
+	r, err := NewResolver(dynamic.RequestID{})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultHeaderName, r.headerName)
+	assert.Equal(t, []string{DefaultHeaderName}, r.requestHeaders)
+
+	r, err = NewResolver(dynamic.RequestID{HeaderName: "X-Trace-Id", Format: FormatULID})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"X-Trace-Id"}, r.requestHeaders)
+}
+
+func TestRequestID(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		config         dynamic.RequestID
+		requestHeaders map[string]string
+		headerName     string
+		expected       string
+		expectedReg    *regexp.Regexp
+		responseHeader bool
+	}{
+		{
+			desc:        "generated uuid",
+			expectedReg: uuidReg,
+		},
+		{
+			desc:        "generated ulid",
+			config:      dynamic.RequestID{Format: FormatULID},
+			expectedReg: ulidReg,
+		},
+		{
+			desc:        "generated trace-id",
+			config:      dynamic.RequestID{Format: FormatTraceID},
+			expectedReg: traceIDReg,
+		},
+		{
+			desc:           "incoming request id",
+			config:         dynamic.RequestID{AddResponseHeader: true},
+			requestHeaders: map[string]string{"X-Request-Id": "r1"},
+			expected:       "r1",
+			responseHeader: true,
+		},
+		{
+			desc:   "joined request ids",
+			config: dynamic.RequestID{RequestHeaders: []string{"X-Request-Id", "Eagleeye-Traceid", "traceparent"}},
+			requestHeaders: map[string]string{
+				"X-Request-Id":     "r1",
+				"Eagleeye-Traceid": "e1",
+				"Traceparent":      traceparent,
+			},
+			expected: "r1, e1, 4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		{
+			desc:   "first request id",
+			config: dynamic.RequestID{RequestHeaders: []string{"X-Request-Id", "traceparent", "Eagleeye-Traceid"}, First: true},
+			requestHeaders: map[string]string{
+				"Eagleeye-Traceid": "e1",
+				"Traceparent":      traceparent,
+			},
+			expected: "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		{
+			desc:           "invalid traceparent",
+			config:         dynamic.RequestID{RequestHeaders: []string{"traceparent"}, Format: FormatTraceID},
+			requestHeaders: map[string]string{"Traceparent": "00-invalid"},
+			expectedReg:    traceIDReg,
+		},
+		{
+			desc:           "custom header name",
+			config:         dynamic.RequestID{RequestHeaders: []string{"X-Amzn-Trace-Id"}, HeaderName: "X-Correlation-Id", AddResponseHeader: true},
+			requestHeaders: map[string]string{"X-Amzn-Trace-Id": "a1"},
+			headerName:     "X-Correlation-Id",
+			expected:       "a1",
+			responseHeader: true,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var forwarded string
+			headerName := test.headerName
+			if headerName == "" {
+				headerName = DefaultHeaderName
+			}
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				forwarded = req.Header.Get(headerName)
+			})
+
+			handler, err := New(context.Background(), next, test.config, "test-request-id")
+			require.NoError(t, err)
+
+			tracer := mocktracer.New()
+			span := tracer.StartSpan("test")
+			logData := &accesslog.LogData{Core: accesslog.CoreLogData{}}
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+			req = req.WithContext(context.WithValue(opentracing.ContextWithSpan(req.Context(), span), accesslog.DataTableKey, logData))
+			for k, v := range test.requestHeaders {
+				req.Header.Set(k, v)
+			}
+
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+
+			if test.expectedReg != nil {
+				assert.Regexp(t, test.expectedReg, forwarded)
+			} else {
+				assert.Equal(t, test.expected, forwarded)
+			}
+			assert.Equal(t, forwarded, logData.Core[LogDataKey])
+			assert.Equal(t, forwarded, span.(*mocktracer.MockSpan).Tag("x-request-id"))
+			if test.responseHeader {
+				assert.Equal(t, forwarded, rw.Header().Get(headerName))
+			} else {
+				assert.Empty(t, rw.Header().Get(headerName))
+			}
+		})
+	}
+}
+
+func TestNewULID(t *testing.T) {
+	first := NewULID()
+	second := NewULID()
+	assert.Regexp(t, ulidReg, first)
+	assert.NotEqual(t, first, second)
+	// the ids of different milliseconds are sorted
+	assert.True(t, first[:10] <= second[:10])
+}
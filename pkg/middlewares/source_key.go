@@ -0,0 +1,139 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/ip"
+)
+
+type uidKey struct{}
+
+// WithUID returns a copy of ctx which carries the user id of the request.
+func WithUID(ctx context.Context, uid string) context.Context {
+	return context.WithValue(ctx, uidKey{}, uid)
+}
+
+// UIDFromContext returns the user id carried by ctx, which is set by the canary middleware.
+func UIDFromContext(ctx context.Context) string {
+	uid, _ := ctx.Value(uidKey{}).(string)
+	return uid
+}
+
+// sourceKey extracts a composite source from the parts of the request defined by dynamic.SourceKey.
+type sourceKey struct {
+	strategy  ip.Strategy // resolves the client IP of the requests without user id, or without any part
+	uid       bool
+	method    bool
+	path      bool
+	templates []pathTemplate
+	host      bool
+	headers   []string
+}
+
+// pathTemplate is a path split in segments, the empty segments match any segment.
+type pathTemplate struct {
+	raw      string
+	segments []string
+}
+
+func newSourceKey(cfg *dynamic.SourceKey, strategy ip.Strategy) (*sourceKey, error) {
+	k := &sourceKey{
+		strategy: strategy,
+		uid:      cfg.UID,
+		method:   cfg.Method,
+		path:     cfg.Path,
+		host:     cfg.Host,
+		headers:  cfg.Headers,
+	}
+	for _, raw := range cfg.PathTemplates {
+		if !strings.HasPrefix(raw, "/") {
+			return nil, fmt.Errorf("invalid path template %q: must start with /", raw)
+		}
+		segments := strings.Split(raw[1:], "/")
+		for i, s := range segments {
+			if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+				if len(s) == 2 {
+					return nil, fmt.Errorf("invalid path template %q: empty parameter name", raw)
+				}
+				segments[i] = ""
+			}
+		}
+		k.templates = append(k.templates, pathTemplate{raw: raw, segments: segments})
+	}
+
+	if !k.uid && !k.method && !k.path && len(k.templates) == 0 && !k.host && len(k.headers) == 0 {
+		return nil, errors.New("no part of the source key defined")
+	}
+	return k, nil
+}
+
+// Extract implements utils.SourceExtractor.
+func (k *sourceKey) Extract(req *http.Request) (string, int64, error) {
+	return k.key(req), 1, nil
+}
+
+// key joins a slot for every configured part, the headers as `name=value`, so that different parts never share a key.
+func (k *sourceKey) key(req *http.Request) string {
+	parts := make([]string, 0, 4+len(k.headers))
+	found := false
+	if k.uid {
+		uid := UIDFromContext(req.Context())
+		if uid == "" {
+			uid = k.strategy.GetIP(req)
+		}
+		parts = append(parts, uid)
+		found = true
+	}
+	if k.method {
+		parts = append(parts, req.Method)
+		found = true
+	}
+	if len(k.templates) > 0 || k.path {
+		path := k.matchTemplate(req.URL.Path)
+		if path == "" && k.path {
+			path = req.URL.Path
+		}
+		parts = append(parts, path)
+		found = found || path != ""
+	}
+	if k.host {
+		parts = append(parts, req.Host)
+		found = true
+	}
+	for _, name := range k.headers {
+		v := req.Header.Get(name)
+		parts = append(parts, name+"="+v)
+		found = found || v != ""
+	}
+
+	if !found {
+		return k.strategy.GetIP(req)
+	}
+	return strings.Join(parts, ":")
+}
+
+// matchTemplate returns the first path template which matches path.
+func (k *sourceKey) matchTemplate(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for _, tpl := range k.templates {
+		if len(tpl.segments) != len(segments) {
+			continue
+		}
+		matched := true
+		for i, s := range tpl.segments {
+			if s != "" && s != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return tpl.raw
+		}
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+)
+
+func TestSourceKey(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		config        dynamic.SourceKey
+		ipStrategy    *dynamic.IPStrategy
+		target        string
+		uid           string
+		headers       map[string]string
+		expected      string
+		expectedError bool
+	}{
+		{
+			desc:          "no part",
+			expectedError: true,
+		},
+		{
+			desc:          "invalid path template",
+			config:        dynamic.SourceKey{PathTemplates: []string{"api/{id}"}},
+			expectedError: true,
+		},
+		{
+			desc:          "empty path parameter",
+			config:        dynamic.SourceKey{PathTemplates: []string{"/api/{}"}},
+			expectedError: true,
+		},
+		{
+			desc:     "uid",
+			config:   dynamic.SourceKey{UID: true, Method: true},
+			uid:      "u1",
+			expected: "u1:GET",
+		},
+		{
+			desc:     "uid falls back to the client ip",
+			config:   dynamic.SourceKey{UID: true, Method: true},
+			expected: "192.0.2.1:GET",
+		},
+		{
+			desc:     "path and host",
+			config:   dynamic.SourceKey{Path: true, Host: true},
+			target:   "http://example.com/api/users/123",
+			expected: "/api/users/123:example.com",
+		},
+		{
+			desc:     "path template",
+			config:   dynamic.SourceKey{Method: true, PathTemplates: []string{"/api/teams/{id}", "/api/users/{id}"}},
+			target:   "http://example.com/api/users/123",
+			expected: "GET:/api/users/{id}",
+		},
+		{
+			desc:     "path template falls back to the path",
+			config:   dynamic.SourceKey{Path: true, PathTemplates: []string{"/api/users/{id}"}},
+			target:   "http://example.com/api/users/123/teams",
+			expected: "/api/users/123/teams",
+		},
+		{
+			desc:     "unmatched path template without path",
+			config:   dynamic.SourceKey{PathTemplates: []string{"/api/users/{id}"}},
+			target:   "http://example.com/api/teams",
+			expected: "192.0.2.1",
+		},
+		{
+			desc:     "headers",
+			config:   dynamic.SourceKey{UID: true, Headers: []string{"X-Tenant-Id", "X-App-Id", "X-Client"}},
+			uid:      "u1",
+			headers:  map[string]string{"X-Tenant-Id": "t1", "X-Client": "web"},
+			expected: "u1:X-Tenant-Id=t1:X-App-Id=:X-Client=web",
+		},
+		{
+			desc:     "headers keep their slot",
+			config:   dynamic.SourceKey{Headers: []string{"X-Tenant-Id", "X-App-Id"}},
+			headers:  map[string]string{"X-App-Id": "a"},
+			expected: "X-Tenant-Id=:X-App-Id=a",
+		},
+		{
+			desc:     "missing headers fall back to the client ip",
+			config:   dynamic.SourceKey{Headers: []string{"X-Tenant-Id", "X-App-Id"}},
+			expected: "192.0.2.1",
+		},
+		{
+			desc:       "uid falls back to the client ip of the ip strategy",
+			config:     dynamic.SourceKey{UID: true},
+			ipStrategy: &dynamic.IPStrategy{Depth: 1},
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.1, 10.0.0.2"},
+			expected:   "10.0.0.2",
+		},
+		{
+			desc:          "invalid ip strategy",
+			config:        dynamic.SourceKey{UID: true},
+			ipStrategy:    &dynamic.IPStrategy{ExcludedIPs: []string{"invalid"}},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			extractor, err := GetSourceExtractor(context.Background(), &dynamic.SourceCriterion{Key: &test.config, IPStrategy: test.ipStrategy})
+			if test.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			target := test.target
+			if target == "" {
+				target = "http://example.com/"
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			if test.uid != "" {
+				req = req.WithContext(WithUID(req.Context(), test.uid))
+			}
+			for k, v := range test.headers {
+				req.Header.Set(k, v)
+			}
+
+			key, amount, err := extractor.Extract(req)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), amount)
+			assert.Equal(t, test.expected, key)
+		})
+	}
+}
+
+func TestGetSourceExtractorKeyExclusive(t *testing.T) {
+	_, err := GetSourceExtractor(context.Background(), &dynamic.SourceCriterion{
+		RequestHost: true,
+		Key:         &dynamic.SourceKey{Host: true},
+	})
+	assert.Error(t, err)
+}
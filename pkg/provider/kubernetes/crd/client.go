@@ -46,6 +46,7 @@ type Client interface {
 	GetService(namespace, name string) (*corev1.Service, bool, error)
 	GetSecret(namespace, name string) (*corev1.Secret, bool, error)
 	GetEndpoints(namespace, name string) (*corev1.Endpoints, bool, error)
+	GetNode(name string) (*corev1.Node, bool, error)
 }
 
 // TODO: add tests for the clientWrapper (and its methods) itself.
@@ -56,8 +57,10 @@ type clientWrapper struct {
 	factoriesCrd    map[string]externalversions.SharedInformerFactory
 	factoriesKube   map[string]informers.SharedInformerFactory
 	factoriesSecret map[string]informers.SharedInformerFactory
+	factoryNodes    informers.SharedInformerFactory
 
 	labelSelector string
+	watchNodes    bool
 
 	isNamespaceAll    bool
 	watchedNamespaces []string
@@ -187,12 +190,21 @@ func (c *clientWrapper) WatchAll(namespaces []string, stopCh <-chan struct{}) (<
 		c.factoriesSecret[ns] = factorySecret
 	}
 
+	if c.watchNodes {
+		c.factoryNodes = informers.NewSharedInformerFactory(c.csKube, resyncPeriod)
+		c.factoryNodes.Core().V1().Nodes().Informer().AddEventHandler(eventHandler)
+	}
+
 	for _, ns := range namespaces {
 		c.factoriesCrd[ns].Start(stopCh)
 		c.factoriesKube[ns].Start(stopCh)
 		c.factoriesSecret[ns].Start(stopCh)
 	}
 
+	if c.factoryNodes != nil {
+		c.factoryNodes.Start(stopCh)
+	}
+
 	for _, ns := range namespaces {
 		for t, ok := range c.factoriesCrd[ns].WaitForCacheSync(stopCh) {
 			if !ok {
@@ -213,6 +225,14 @@ func (c *clientWrapper) WatchAll(namespaces []string, stopCh <-chan struct{}) (<
 		}
 	}
 
+	if c.factoryNodes != nil {
+		for t, ok := range c.factoryNodes.WaitForCacheSync(stopCh) {
+			if !ok {
+				return nil, fmt.Errorf("timed out waiting for controller caches to sync %s", t.String())
+			}
+		}
+	}
+
 	return eventCh, nil
 }
 
@@ -379,6 +399,17 @@ func (c *clientWrapper) GetEndpoints(namespace, name string) (*corev1.Endpoints,
 	return endpoint, exist, err
 }
 
+// GetNode returns the named node, the nodes are only watched when the zones of the servers are set from their nodes.
+func (c *clientWrapper) GetNode(name string) (*corev1.Node, bool, error) {
+	if c.factoryNodes == nil {
+		return nil, false, fmt.Errorf("failed to get node %s: nodes are not watched", name)
+	}
+
+	node, err := c.factoryNodes.Core().V1().Nodes().Lister().Get(name)
+	exist, err := translateNotFoundError(err)
+	return node, exist, err
+}
+
 // GetSecret returns the named secret from the given namespace.
 func (c *clientWrapper) GetSecret(namespace, name string) (*corev1.Secret, bool, error) {
 	if !c.isWatchedNamespace(namespace) {
@@ -25,6 +25,7 @@ type clientMock struct {
 	services  []*corev1.Service
 	secrets   []*corev1.Secret
 	endpoints []*corev1.Endpoints
+	nodes     []*corev1.Node
 
 	apiServiceError   error
 	apiSecretError    error
@@ -59,6 +60,8 @@ func newClientMock(paths ...string) clientMock {
 				c.services = append(c.services, o)
 			case *corev1.Endpoints:
 				c.endpoints = append(c.endpoints, o)
+			case *corev1.Node:
+				c.nodes = append(c.nodes, o)
 			case *v1alpha1.IngressRoute:
 				c.ingressRoutes = append(c.ingressRoutes, o)
 			case *v1alpha1.IngressRouteTCP:
@@ -171,6 +174,16 @@ func (c clientMock) GetEndpoints(namespace, name string) (*corev1.Endpoints, boo
 	return &corev1.Endpoints{}, false, nil
 }
 
+func (c clientMock) GetNode(name string) (*corev1.Node, bool, error) {
+	for _, node := range c.nodes {
+		if node.Name == name {
+			return node, true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
 func (c clientMock) GetSecret(namespace, name string) (*corev1.Secret, bool, error) {
 	if c.apiSecretError != nil {
 		return nil, false, c.apiSecretError
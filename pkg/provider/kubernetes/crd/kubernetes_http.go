@@ -18,9 +18,13 @@ import (
 )
 
 const (
-	roundRobinStrategy = "RoundRobin"
-	httpsProtocol      = "https"
-	httpProtocol       = "http"
+	roundRobinStrategy     = "RoundRobin"
+	leastRequestStrategy   = "LeastRequest"
+	peakEWMAStrategy       = "PeakEWMA"
+	p2cStrategy            = "P2C"
+	consistentHashStrategy = "ConsistentHash"
+	httpsProtocol          = "https"
+	httpProtocol           = "http"
 )
 
 func (p *Provider) loadIngressRouteConfiguration(ctx context.Context, client Client, tlsConfigs map[string]*tls.CertAndStores) *dynamic.HTTPConfiguration {
@@ -50,7 +54,7 @@ func (p *Provider) loadIngressRouteConfiguration(ctx context.Context, client Cli
 			ingressName = ingressRoute.GenerateName
 		}
 
-		cb := configBuilder{client, p.AllowCrossNamespace}
+		cb := configBuilder{client, p.AllowCrossNamespace, p.NodeZones}
 
 		for _, route := range ingressRoute.Spec.Routes {
 			if route.Kind != "Rule" {
@@ -175,6 +179,7 @@ func (p *Provider) makeMiddlewareKeys(ctx context.Context, ingRouteNamespace str
 type configBuilder struct {
 	client              Client
 	allowCrossNamespace *bool
+	nodeZones           bool
 }
 
 // buildTraefikService creates the configuration for the traefik service defined in tService,
@@ -284,6 +289,10 @@ func (c configBuilder) buildLabeledLB(ctx context.Context, namespace string, tSe
 
 	logger := log.FromContext(ctx)
 	labeledServices := make([]string, 0)
+	var weights map[string]int
+	if tService.Labeled.Weight != nil {
+		weights = map[string]int{fullNameMain: *tService.Labeled.Weight}
+	}
 	for _, service := range tService.Labeled.Services {
 		s := service.LoadBalancerSpec
 		fullName, k8sService, err := c.nameAndService(ctx, namespace, s)
@@ -296,6 +305,36 @@ func (c configBuilder) buildLabeledLB(ctx context.Context, namespace string, tSe
 			conf[fullName] = k8sService
 		}
 		labeledServices = append(labeledServices, fullName)
+		if service.Weight != nil {
+			if weights == nil {
+				weights = make(map[string]int)
+			}
+			weights[fullName] = *service.Weight
+		}
+	}
+
+	var labels map[string]string
+	for label, service := range tService.Labeled.Labels {
+		s := service.LoadBalancerSpec
+		fullName, k8sService, err := c.nameAndService(ctx, namespace, s)
+		if err != nil {
+			logger.Errorf("buildLabeledLB (%s, %s, %s) failed: %s,", s.Name, s.Namespace, s.Kind, err.Error())
+			continue // ignore invalid service
+		}
+
+		if k8sService != nil {
+			conf[fullName] = k8sService
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[label] = fullName
+		if service.Weight != nil {
+			if weights == nil {
+				weights = make(map[string]int)
+			}
+			weights[fullName] = *service.Weight
+		}
 	}
 
 	conf[id] = &dynamic.Service{
@@ -303,6 +342,9 @@ func (c configBuilder) buildLabeledLB(ctx context.Context, namespace string, tSe
 			ServiceName: tService.Labeled.LoadBalancerSpec.Name,
 			Default:     fullNameMain,
 			Services:    labeledServices,
+			Labels:      labels,
+			Weights:     weights,
+			Analysis:    tService.Labeled.Analysis,
 		},
 	}
 	return nil
@@ -342,6 +384,12 @@ func (c configBuilder) buildServersLB(namespace string, svc v1alpha1.LoadBalance
 
 	lb.Sticky = svc.Sticky
 	lb.ServersTransport = svc.ServersTransport
+	if svc.Strategy != roundRobinStrategy {
+		lb.Strategy = svc.Strategy
+	}
+	lb.ConsistentHash = svc.ConsistentHash
+	lb.Locality = svc.Locality
+	lb.OutlierDetection = svc.OutlierDetection
 
 	return &dynamic.Service{LoadBalancer: lb}, nil
 }
@@ -351,7 +399,9 @@ func (c configBuilder) loadServers(parentNamespace string, svc v1alpha1.LoadBala
 	if strategy == "" {
 		strategy = roundRobinStrategy
 	}
-	if strategy != roundRobinStrategy {
+	switch strategy {
+	case roundRobinStrategy, leastRequestStrategy, peakEWMAStrategy, p2cStrategy, consistentHashStrategy:
+	default:
 		return nil, fmt.Errorf("load balancing strategy %s is not supported", strategy)
 	}
 
@@ -422,15 +472,39 @@ func (c configBuilder) loadServers(parentNamespace string, svc v1alpha1.LoadBala
 		for _, addr := range subset.Addresses {
 			hostPort := net.JoinHostPort(addr.IP, strconv.Itoa(int(port)))
 
-			servers = append(servers, dynamic.Server{
+			server := dynamic.Server{
 				URL: fmt.Sprintf("%s://%s", protocol, hostPort),
-			})
+			}
+			if c.nodeZones && addr.NodeName != nil {
+				server.Zone, err = c.nodeZone(*addr.NodeName)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			servers = append(servers, server)
 		}
 	}
 
 	return servers, nil
 }
 
+// nodeZone returns the zone of the named node, from its well-known topology labels.
+func (c configBuilder) nodeZone(name string) (string, error) {
+	node, exists, err := c.client.GetNode(name)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", nil
+	}
+
+	if zone, ok := node.Labels[corev1.LabelTopologyZone]; ok {
+		return zone, nil
+	}
+	return node.Labels[corev1.LabelFailureDomainBetaZone], nil
+}
+
 // nameAndService returns the name that should be used for the svc service in the generated config.
 // In addition, if the service is a Kubernetes one,
 // it generates and returns the configuration part for such a service,
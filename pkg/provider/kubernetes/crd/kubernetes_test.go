@@ -1430,6 +1430,67 @@ func TestLoadIngressRoutes(t *testing.T) {
 				TLS: &dynamic.TLSConfiguration{},
 			},
 		},
+		{
+			desc:  "Simple Ingress Route with canary middleware",
+			paths: []string{"services.yml", "with_middleware_canary.yml"},
+			expected: &dynamic.Configuration{
+				UDP: &dynamic.UDPConfiguration{
+					Routers:  map[string]*dynamic.UDPRouter{},
+					Services: map[string]*dynamic.UDPService{},
+				},
+				TCP: &dynamic.TCPConfiguration{
+					Routers:     map[string]*dynamic.TCPRouter{},
+					Middlewares: map[string]*dynamic.TCPMiddleware{},
+					Services:    map[string]*dynamic.TCPService{},
+				},
+				HTTP: &dynamic.HTTPConfiguration{
+					ServersTransports: map[string]*dynamic.ServersTransport{},
+					Routers: map[string]*dynamic.Router{
+						"default-test2-route-6f97418635c7e18853da": {
+							EntryPoints: []string{"web"},
+							Service:     "default-test2-route-6f97418635c7e18853da",
+							Rule:        "Host(`foo.com`)",
+							Priority:    12,
+							Middlewares: []string{"default-canary"},
+						},
+					},
+					Middlewares: map[string]*dynamic.Middleware{
+						"default-canary": {
+							Canary: &dynamic.Canary{
+								Product:      "urbs",
+								Server:       "http://labels.default.svc",
+								MaxStaleness: types.Duration(5 * time.Minute),
+								Rollout: &dynamic.CanaryRollout{
+									Seed:   "urbs-2021",
+									Labels: []dynamic.RolloutLabel{{Label: "beta", Percent: 10}},
+								},
+								Rules: []dynamic.CanaryRule{{PathPrefix: "/api", Labels: "dev"}},
+								Signing: &dynamic.CanarySigning{
+									MaxAge: types.Duration(30 * time.Second),
+									Keys:   []dynamic.CanarySigningKey{{ID: "k1", Secret: "secret"}},
+								},
+							},
+						},
+					},
+					Services: map[string]*dynamic.Service{
+						"default-test2-route-6f97418635c7e18853da": {
+							LoadBalancer: &dynamic.ServersLoadBalancer{
+								Servers: []dynamic.Server{
+									{
+										URL: "http://10.10.0.1:80",
+									},
+									{
+										URL: "http://10.10.0.2:80",
+									},
+								},
+								PassHostHeader: Bool(true),
+							},
+						},
+					},
+				},
+				TLS: &dynamic.TLSConfiguration{},
+			},
+		},
 		{
 			desc:  "Simple Ingress Route with middleware crossprovider",
 			paths: []string{"services.yml", "with_middleware_crossprovider.yml"},
@@ -1716,6 +1777,69 @@ func TestLoadIngressRoutes(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc:  "labeled traefik service with labels",
+			paths: []string{"services.yml", "with_services_labeled.yml"},
+			expected: &dynamic.Configuration{
+				UDP: &dynamic.UDPConfiguration{
+					Routers:  map[string]*dynamic.UDPRouter{},
+					Services: map[string]*dynamic.UDPService{},
+				},
+				TLS: &dynamic.TLSConfiguration{},
+				TCP: &dynamic.TCPConfiguration{
+					Routers:     map[string]*dynamic.TCPRouter{},
+					Middlewares: map[string]*dynamic.TCPMiddleware{},
+					Services:    map[string]*dynamic.TCPService{},
+				},
+				HTTP: &dynamic.HTTPConfiguration{
+					ServersTransports: map[string]*dynamic.ServersTransport{},
+					Routers:           map[string]*dynamic.Router{},
+					Middlewares:       map[string]*dynamic.Middleware{},
+					Services: map[string]*dynamic.Service{
+						"default-lrr1": {
+							Labeled: &dynamic.LabeledRoundRobin{
+								ServiceName: "whoami",
+								Default:     "default-whoami-80",
+								Services:    []string{},
+								Labels: map[string]string{
+									"beta": "default-whoami2-8080",
+								},
+								Weights: map[string]int{
+									"default-whoami-80":    90,
+									"default-whoami2-8080": 10,
+								},
+							},
+						},
+						"default-whoami-80": {
+							LoadBalancer: &dynamic.ServersLoadBalancer{
+								Servers: []dynamic.Server{
+									{
+										URL: "http://10.10.0.1:80",
+									},
+									{
+										URL: "http://10.10.0.2:80",
+									},
+								},
+								PassHostHeader: Bool(true),
+							},
+						},
+						"default-whoami2-8080": {
+							LoadBalancer: &dynamic.ServersLoadBalancer{
+								Servers: []dynamic.Server{
+									{
+										URL: "http://10.10.0.3:8080",
+									},
+									{
+										URL: "http://10.10.0.4:8080",
+									},
+								},
+								PassHostHeader: Bool(true),
+							},
+						},
+					},
+				},
+			},
+		},
 		{
 			desc:  "One ingress Route with two different services, each with two services, balancing servers nested",
 			paths: []string{"with_services_lb1.yml"},
@@ -3904,6 +4028,48 @@ func TestLoadIngressRouteUDPs(t *testing.T) {
 	}
 }
 
+func TestLoadIngressRoutesWithNodeZones(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		nodeZones bool
+		expected  []dynamic.Server
+	}{
+		{
+			desc: "node zones disabled",
+			expected: []dynamic.Server{
+				{URL: "http://10.10.0.1:80"},
+				{URL: "http://10.10.0.2:80"},
+				{URL: "http://10.10.0.3:80"},
+				{URL: "http://10.10.0.4:80"},
+			},
+		},
+		{
+			desc:      "node zones enabled",
+			nodeZones: true,
+			expected: []dynamic.Server{
+				{URL: "http://10.10.0.1:80", Zone: "zone-a"},
+				{URL: "http://10.10.0.2:80", Zone: "zone-b"},
+				{URL: "http://10.10.0.3:80"},
+				{URL: "http://10.10.0.4:80"},
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			p := Provider{NodeZones: test.nodeZones}
+			p.SetDefaults()
+
+			conf := p.loadConfigurationFromCRD(context.Background(), newClientMock("with_node_zones.yml"))
+			require.Contains(t, conf.HTTP.Services, "default-test-route-6f97418635c7e18853da")
+			assert.Equal(t, test.expected, conf.HTTP.Services["default-test-route-6f97418635c7e18853da"].LoadBalancer.Servers)
+		})
+	}
+}
+
 func TestParseServiceProtocol(t *testing.T) {
 	testCases := []struct {
 		desc          string
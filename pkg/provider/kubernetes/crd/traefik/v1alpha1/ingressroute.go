@@ -74,6 +74,9 @@ type LoadBalancerSpec struct {
 	Port               intstr.IntOrString          `json:"port,omitempty"`
 	Scheme             string                      `json:"scheme,omitempty"`
 	Strategy           string                      `json:"strategy,omitempty"`
+	ConsistentHash     *dynamic.ConsistentHash     `json:"consistentHash,omitempty"`
+	Locality           *dynamic.Locality           `json:"locality,omitempty"`
+	OutlierDetection   *dynamic.OutlierDetection   `json:"outlierDetection,omitempty"`
 	PassHostHeader     *bool                       `json:"passHostHeader,omitempty"`
 	ResponseForwarding *dynamic.ResponseForwarding `json:"responseForwarding,omitempty"`
 	ServersTransport   string                      `json:"serversTransport,omitempty"`
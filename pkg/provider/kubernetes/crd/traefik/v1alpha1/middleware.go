@@ -47,6 +47,7 @@ type MiddlewareSpec struct {
 	ContentType       *dynamic.ContentType           `json:"contentType,omitempty"`
 	Plugin            map[string]apiextensionv1.JSON `json:"plugin,omitempty"`
 	Canary            *dynamic.Canary                `json:"canary,omitempty"`
+	RequestID         *dynamic.RequestID             `json:"requestID,omitempty"`
 }
 
 // +k8s:deepcopy-gen=true
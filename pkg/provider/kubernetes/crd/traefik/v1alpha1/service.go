@@ -74,7 +74,13 @@ type WeightedRoundRobin struct {
 // LabeledRoundRobin defines a labeled load-balancer of services, which select service by label.
 // Label will be extract from request header or cookie, with key `X-Canary`.
 // services should be named as `{defaultService}-{label}`. Ex. "myservice-stable", "myservice-beta", "myservice-dev"
+// unless they are mapped to labels by Labels.
+// Requests are split by the weights of the services, see dynamic.LabeledRoundRobin.Weights.
 type LabeledRoundRobin struct {
 	Service  `json:",inline"`
 	Services []Service `json:"services,omitempty"`
+	// Labels maps labels to services, whatever the names of the services.
+	Labels map[string]Service `json:"labels,omitempty"`
+	// Analysis enables the automated analysis and promotion of the services of a label.
+	Analysis *dynamic.LabeledAnalysis `json:"analysis,omitempty"`
 }
@@ -32,6 +32,9 @@ type RouterFactory struct {
 
 	chainBuilder *middleware.ChainBuilder
 	tlsManager   *tls.Manager
+
+	// middlewaresBuilder is the builder of the current HTTP middlewares, closed on the next configuration.
+	middlewaresBuilder *middleware.Builder
 }
 
 // NewRouterFactory creates a new RouterFactory.
@@ -70,7 +73,7 @@ func (f *RouterFactory) CreateRouters(rtConf *runtime.Configuration) (map[string
 	// HTTP
 	serviceManager := f.managerFactory.Build(rtConf)
 
-	middlewaresBuilder := middleware.NewBuilder(rtConf.Middlewares, serviceManager, f.pluginBuilder)
+	middlewaresBuilder := middleware.NewBuilder(rtConf.Middlewares, serviceManager, f.pluginBuilder, f.metricsRegistry)
 
 	routerManager := router.NewManager(rtConf, serviceManager, middlewaresBuilder, f.chainBuilder, f.metricsRegistry)
 
@@ -79,6 +82,11 @@ func (f *RouterFactory) CreateRouters(rtConf *runtime.Configuration) (map[string
 
 	serviceManager.LaunchHealthCheck()
 
+	if f.middlewaresBuilder != nil {
+		f.middlewaresBuilder.Close()
+	}
+	f.middlewaresBuilder = middlewaresBuilder
+
 	// TCP
 	svcTCPManager := tcp.NewManager(rtConf)
 
@@ -333,6 +333,11 @@ func (c *writeCloserWrapper) CloseWrite() error {
 	return c.writeCloser.CloseWrite()
 }
 
+// ProxyHeader returns the PROXY protocol header of the connection, if any.
+func (c *writeCloserWrapper) ProxyHeader() *proxyproto.Header {
+	return tcp.ProxyHeader(c.Conn)
+}
+
 // writeCloser returns the given connection, augmented with the WriteCloser
 // implementation, if any was found within the underlying conn.
 func writeCloser(conn net.Conn) (tcp.WriteCloser, error) {
@@ -557,3 +562,8 @@ func (t *trackedConnection) Close() error {
 	t.tracker.RemoveConnection(t.WriteCloser)
 	return t.WriteCloser.Close()
 }
+
+// ProxyHeader returns the PROXY protocol header of the connection, if any.
+func (t *trackedConnection) ProxyHeader() *proxyproto.Header {
+	return tcp.ProxyHeader(t.WriteCloser)
+}
@@ -0,0 +1,327 @@
+package lrr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/log"
+	"github.com/traefik/traefik/v2/pkg/middlewares/metrics"
+	"github.com/traefik/traefik/v2/pkg/safe"
+)
+
+// Phases of an analysis.
+const (
+	PhaseProgressing = "progressing"
+	PhasePromoted    = "promoted"
+	PhaseRolledBack  = "rolledBack"
+)
+
+const (
+	maxAnalysisEvents = 20
+	// maxLatencySamples bounds the latencies kept per interval, they are sampled beyond it.
+	maxLatencySamples = 4096
+)
+
+// AnalysisEvent is a decision of an analysis.
+type AnalysisEvent struct {
+	Time    time.Time `json:"time"`
+	Phase   string    `json:"phase"`
+	Weight  int       `json:"weight"`
+	Message string    `json:"message"`
+}
+
+// AnalysisStatus is the status of the analysis of a labeled service.
+type AnalysisStatus struct {
+	Service string          `json:"service"`
+	Label   string          `json:"label"`
+	Phase   string          `json:"phase"`
+	Weight  int             `json:"weight"`
+	Events  []AnalysisEvent `json:"events,omitempty"`
+}
+
+// Analysis compares the services of a label (the canary) with the default service of labeled balancers,
+// and promotes or rolls back the canary by adjusting the weights of the balancers.
+type Analysis struct {
+	name     string // the labeled service
+	config   dynamic.LabeledAnalysis
+	canary   windowStats
+	baseline windowStats
+
+	mu        sync.Mutex
+	balancers []*Balancer
+	phase     string
+	weight    int
+	events    []AnalysisEvent
+}
+
+// NewAnalysis creates the analysis of the labeled service name, the zero values of config are defaulted.
+func NewAnalysis(name string, config dynamic.LabeledAnalysis) (*Analysis, error) {
+	if !ValidLabel(config.Label) {
+		return nil, fmt.Errorf("invalid analysis label %q", config.Label)
+	}
+
+	defaults := dynamic.LabeledAnalysis{}
+	defaults.SetDefaults()
+	if config.Interval <= 0 {
+		config.Interval = defaults.Interval
+	}
+	if config.StepWeight <= 0 {
+		config.StepWeight = defaults.StepWeight
+	}
+	if config.MaxWeight <= 0 || config.MaxWeight > 100 {
+		config.MaxWeight = defaults.MaxWeight
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = defaults.MinRequests
+	}
+	if config.MaxErrorRatio <= 0 {
+		config.MaxErrorRatio = defaults.MaxErrorRatio
+	}
+	if config.MaxLatencyRatio <= 0 {
+		config.MaxLatencyRatio = defaults.MaxLatencyRatio
+	}
+
+	return &Analysis{
+		name:   name,
+		config: config,
+		phase:  PhaseProgressing,
+		weight: minInt(config.StepWeight, config.MaxWeight),
+	}, nil
+}
+
+// Attach records the requests of the canary and of the default service of b, and applies the weights of the analysis to b.
+// It should be called before b serves requests.
+func (a *Analysis) Attach(b *Balancer) error {
+	if b.analysis != nil {
+		return errors.New("analysis already attached")
+	}
+	b.analysis = a
+	b.analyzed = b.serviceName + "-" + a.config.Label
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.balancers = append(a.balancers, b)
+	a.apply(b)
+	return nil
+}
+
+// Status returns the status of the analysis.
+func (a *Analysis) Status() AnalysisStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	events := make([]AnalysisEvent, len(a.events))
+	copy(events, a.events)
+	return AnalysisStatus{Service: a.name, Label: a.config.Label, Phase: a.phase, Weight: a.weight, Events: events}
+}
+
+// resume continues from the state of prev when it analyzes the same canary.
+func (a *Analysis) resume(prev *Analysis) {
+	prev.mu.Lock()
+	phase, weight, events := prev.phase, prev.weight, prev.events
+	config := prev.config
+	prev.mu.Unlock()
+	if config != a.config {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.phase, a.weight = phase, weight
+	a.events = append([]AnalysisEvent(nil), events...)
+	for _, b := range a.balancers {
+		a.apply(b)
+	}
+}
+
+func (a *Analysis) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(a.config.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !a.step(ctx, now) {
+				return
+			}
+		}
+	}
+}
+
+// step compares the canary with the default service over the last interval, it returns false when the analysis is over.
+func (a *Analysis) step(ctx context.Context, now time.Time) bool {
+	canary := a.canary.reset()
+	baseline := a.baseline.reset()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.phase != PhaseProgressing {
+		return false
+	}
+	logger := log.FromContext(ctx)
+	if canary.requests < a.config.MinRequests {
+		logger.Debugf("Analysis of %s: %d requests of %s, waiting for %d", a.name, canary.requests, a.config.Label, a.config.MinRequests)
+		return true
+	}
+
+	switch {
+	case canary.errorRatio()-baseline.errorRatio() > a.config.MaxErrorRatio:
+		a.weight = 0
+		a.phase = PhaseRolledBack
+		a.record(now, fmt.Sprintf("error ratio %.4f of %s exceeds %.4f of the default service by more than %.4f",
+			canary.errorRatio(), a.config.Label, baseline.errorRatio(), a.config.MaxErrorRatio))
+		logger.Warnf("Analysis of %s: %s rolled back, %s", a.name, a.config.Label, a.events[len(a.events)-1].Message)
+	case baseline.p99 > 0 && float64(canary.p99) > float64(baseline.p99)*a.config.MaxLatencyRatio:
+		a.weight = 0
+		a.phase = PhaseRolledBack
+		a.record(now, fmt.Sprintf("p99 latency %s of %s exceeds %s of the default service by more than %.2f times",
+			canary.p99, a.config.Label, baseline.p99, a.config.MaxLatencyRatio))
+		logger.Warnf("Analysis of %s: %s rolled back, %s", a.name, a.config.Label, a.events[len(a.events)-1].Message)
+	default:
+		a.weight = minInt(a.weight+a.config.StepWeight, a.config.MaxWeight)
+		if a.weight == a.config.MaxWeight {
+			a.phase = PhasePromoted
+		}
+		a.record(now, fmt.Sprintf("%d requests of %s, error ratio %.4f, p99 latency %s",
+			canary.requests, a.config.Label, canary.errorRatio(), canary.p99))
+		logger.Infof("Analysis of %s: %s weight set to %d, %s", a.name, a.config.Label, a.weight, a.events[len(a.events)-1].Message)
+	}
+
+	for _, b := range a.balancers {
+		a.apply(b)
+	}
+	return a.phase == PhaseProgressing
+}
+
+func (a *Analysis) record(now time.Time, message string) {
+	a.events = append(a.events, AnalysisEvent{Time: now, Phase: a.phase, Weight: a.weight, Message: message})
+	if len(a.events) > maxAnalysisEvents {
+		a.events = a.events[len(a.events)-maxAnalysisEvents:]
+	}
+}
+
+// apply sets the weight of the canary as its share of the requests without label of b, the default service gets the rest.
+func (a *Analysis) apply(b *Balancer) {
+	b.SetLabelShare(a.config.Label, a.weight)
+	b.SetDefaultWeight(100 - a.weight)
+}
+
+// windowStats holds the responses of a service over an interval.
+type windowStats struct {
+	mu        sync.Mutex
+	requests  int
+	errors    int
+	latencies []time.Duration
+}
+
+type windowSummary struct {
+	requests int
+	errors   int
+	p99      time.Duration
+}
+
+func (s windowSummary) errorRatio() float64 {
+	if s.requests == 0 {
+		return 0
+	}
+	return float64(s.errors) / float64(s.requests)
+}
+
+// serve serves req with h, and records the response in s.
+func (s *windowStats) serve(h http.Handler, rw http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	status := metrics.ServeRecorded(h, rw, req)
+	s.observe(status, time.Since(start))
+}
+
+func (s *windowStats) observe(status int, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	if status >= http.StatusInternalServerError {
+		s.errors++
+	}
+	if len(s.latencies) < maxLatencySamples {
+		s.latencies = append(s.latencies, latency)
+	} else if i := rand.Intn(s.requests); i < maxLatencySamples {
+		s.latencies[i] = latency // reservoir sampling
+	}
+}
+
+// reset returns the summary of the interval, and starts a new one.
+func (s *windowStats) reset() windowSummary {
+	s.mu.Lock()
+	requests, errors, latencies := s.requests, s.errors, s.latencies
+	s.requests, s.errors, s.latencies = 0, 0, nil
+	s.mu.Unlock()
+
+	summary := windowSummary{requests: requests, errors: errors}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		summary.p99 = latencies[(len(latencies)*99-1)/100]
+	}
+	return summary
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+var (
+	analysesMu     sync.Mutex
+	analyses       = make(map[string]*Analysis)
+	analysesCancel context.CancelFunc
+)
+
+// LaunchAnalyses stops the running analyses and starts the given ones, keyed by labeled service name.
+// An analysis of the same canary with the same configuration resumes from the phase and the weight of the running one.
+func LaunchAnalyses(parentCtx context.Context, next map[string]*Analysis) {
+	analysesMu.Lock()
+	defer analysesMu.Unlock()
+
+	if analysesCancel != nil {
+		analysesCancel()
+	}
+	ctx, cancel := context.WithCancel(parentCtx)
+	analysesCancel = cancel
+
+	for name, a := range next {
+		if prev, ok := analyses[name]; ok {
+			a.resume(prev)
+		}
+
+		current := a
+		logCtx := log.With(ctx, log.Str(log.ServiceName, name))
+		safe.Go(func() {
+			current.run(logCtx)
+		})
+	}
+	analyses = next
+}
+
+// GetAnalyses returns the status of the running analyses, sorted by labeled service name.
+func GetAnalyses() []AnalysisStatus {
+	analysesMu.Lock()
+	defer analysesMu.Unlock()
+
+	statuses := make([]AnalysisStatus, 0, len(analyses))
+	for _, a := range analyses {
+		statuses = append(statuses, a.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Service < statuses[j].Service })
+	return statuses
+}
@@ -0,0 +1,252 @@
+package lrr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	ptypes "github.com/traefik/paerser/types"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+)
+
+func TestAnalysis(t *testing.T) {
+	t.Run("NewAnalysis should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		_, err := NewAnalysis("core", dynamic.LabeledAnalysis{})
+		a.NotNil(err)
+		_, err = NewAnalysis("core", dynamic.LabeledAnalysis{Label: "Beta"})
+		a.NotNil(err)
+
+		an, err := NewAnalysis("core", dynamic.LabeledAnalysis{Label: "beta", MaxWeight: 200})
+		a.Nil(err)
+		a.Equal(ptypes.Duration(time.Minute), an.config.Interval)
+		a.Equal(10, an.config.StepWeight)
+		a.Equal(100, an.config.MaxWeight)
+		a.Equal(20, an.config.MinRequests)
+		a.Equal(0.01, an.config.MaxErrorRatio)
+		a.Equal(1.5, an.config.MaxLatencyRatio)
+		a.Equal(AnalysisStatus{Service: "core", Label: "beta", Phase: PhaseProgressing, Weight: 10, Events: []AnalysisEvent{}}, an.Status())
+	})
+
+	t.Run("promotion should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		b := New("core", statusHandler(http.StatusOK), nil)
+		b.AddService("ng-core-beta-80", statusHandler(http.StatusOK), nil)
+		an, err := NewAnalysis("core", dynamic.LabeledAnalysis{Label: "beta", StepWeight: 40, MaxWeight: 90, MinRequests: 5, MaxLatencyRatio: 1e6})
+		a.Nil(err)
+		a.Nil(an.Attach(b))
+		a.NotNil(an.Attach(b))
+		share, _ := b.load().share("core-beta")
+		a.Equal(40, share)
+		a.Nil(b.load().handlers[0].weight)
+		a.Equal(60, b.load().defaultWeight)
+
+		serveLabeled(b, "beta", 4)
+		a.True(an.step(context.Background(), time.Now()))
+		a.Equal(40, an.Status().Weight)
+		a.Equal(0, len(an.Status().Events))
+
+		serveLabeled(b, "beta", 5)
+		serveLabeled(b, "", 5)
+		a.True(an.step(context.Background(), time.Now()))
+		a.Equal(80, an.Status().Weight)
+		share, _ = b.load().share("core-beta")
+		a.Equal(80, share)
+		a.Equal(20, b.load().defaultWeight)
+
+		serveLabeled(b, "beta", 5)
+		a.False(an.step(context.Background(), time.Now()))
+		status := an.Status()
+		a.Equal(PhasePromoted, status.Phase)
+		a.Equal(90, status.Weight)
+		a.Equal(2, len(status.Events))
+		a.Equal(10, b.load().defaultWeight)
+		a.False(an.step(context.Background(), time.Now()))
+	})
+
+	t.Run("rollback should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		b := New("core", statusHandler(http.StatusOK), nil)
+		b.AddService("ng-core-beta-80", statusHandler(http.StatusBadGateway), nil)
+		an, _ := NewAnalysis("core", dynamic.LabeledAnalysis{Label: "beta", MinRequests: 5})
+		a.Nil(an.Attach(b))
+
+		serveLabeled(b, "beta", 5)
+		serveLabeled(b, "", 5)
+		a.False(an.step(context.Background(), time.Now()))
+		status := an.Status()
+		a.Equal(PhaseRolledBack, status.Phase)
+		a.Equal(0, status.Weight)
+		a.Contains(status.Events[0].Message, "error ratio 1.0000 of beta")
+		share, _ := b.load().share("core-beta")
+		a.Equal(0, share)
+		a.Equal(100, b.load().defaultWeight)
+
+		// the requests without label are served by the default service only.
+		for i := 0; i < 100; i++ {
+			_, useDefault := b.Pick(http.Header{})
+			a.True(useDefault)
+		}
+
+		// but the labeled requests are still served by the labeled service, the nofallback ones too.
+		for _, canary := range []string{"label=beta", "label=beta,nofallback"} {
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Canary", canary)
+			b.ServeHTTP(rw, req)
+			a.Equal(http.StatusBadGateway, rw.Code)
+		}
+	})
+
+	t.Run("weights of the versions of the label should be kept", func(t *testing.T) {
+		a := assert.New(t)
+
+		weight := func(i int) *int { return &i }
+		b := New("core", http.NotFoundHandler(), nil)
+		b.AddService("ng1-core-beta-80", http.NotFoundHandler(), weight(3))
+		b.AddService("ng2-core-beta-80", http.NotFoundHandler(), weight(1))
+		an, _ := NewAnalysis("core", dynamic.LabeledAnalysis{Label: "beta", StepWeight: 20})
+		a.Nil(an.Attach(b))
+		a.Equal(3, *b.load().handlers[0].weight)
+		a.Equal(1, *b.load().handlers[1].weight)
+
+		// the label gets its share of the requests without label as a whole, split by the weights of the versions.
+		counts := make(map[string]int)
+		for i := 0; i < 10000; i++ {
+			name, useDefault := b.Pick(http.Header{})
+			if useDefault {
+				name = "default"
+			}
+			counts[name]++
+		}
+		a.InDelta(8000, counts["default"], 300)
+		a.InDelta(1500, counts["ng1-core-beta-80"], 200)
+		a.InDelta(500, counts["ng2-core-beta-80"], 150)
+
+		counts = make(map[string]int)
+		header := http.Header{}
+		header.Set("X-Canary", "label=beta")
+		for i := 0; i < 10000; i++ {
+			name, _ := b.Pick(header)
+			counts[name]++
+		}
+		a.InDelta(7500, counts["ng1-core-beta-80"], 300)
+		a.InDelta(2500, counts["ng2-core-beta-80"], 300)
+
+		// a removed version does not remove the share of the label.
+		a.True(b.RemoveService("ng1-core-beta-80"))
+		share, ok := b.load().share("core-beta")
+		a.True(ok)
+		a.Equal(20, share)
+		a.True(b.load().weighted)
+	})
+
+	t.Run("latency rollback should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		an, _ := NewAnalysis("core", dynamic.LabeledAnalysis{Label: "beta", MinRequests: 1})
+		an.canary.observe(http.StatusOK, 200*time.Millisecond)
+		an.baseline.observe(http.StatusOK, 100*time.Millisecond)
+		a.False(an.step(context.Background(), time.Now()))
+		a.Equal(PhaseRolledBack, an.Status().Phase)
+		a.Contains(an.Status().Events[0].Message, "p99 latency 200ms of beta")
+	})
+
+	t.Run("p99 should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		s := &windowStats{}
+		for i := 1; i <= 200; i++ {
+			s.observe(http.StatusOK, time.Duration(i)*time.Millisecond)
+		}
+		s.observe(http.StatusServiceUnavailable, time.Millisecond)
+		summary := s.reset()
+		a.Equal(201, summary.requests)
+		a.Equal(1, summary.errors)
+		a.Equal(198*time.Millisecond, summary.p99)
+		a.Equal(windowSummary{}, s.reset())
+	})
+
+	t.Run("serve should record the response", func(t *testing.T) {
+		a := assert.New(t)
+
+		s := &windowStats{}
+		handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			_, ok := rw.(http.CloseNotifier)
+			a.True(ok)
+			_, ok = rw.(http.Pusher)
+			a.True(ok)
+			rw.WriteHeader(http.StatusBadGateway)
+		})
+		s.serve(handler, &closeNotifyRecorder{httptest.NewRecorder()}, httptest.NewRequest(http.MethodGet, "/", nil))
+		summary := s.reset()
+		a.Equal(1, summary.requests)
+		a.Equal(1, summary.errors)
+	})
+
+	t.Run("LaunchAnalyses should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		config := dynamic.LabeledAnalysis{Label: "beta", Interval: ptypes.Duration(time.Hour)}
+		prev, _ := NewAnalysis("core@file", config)
+		prev.weight = 30
+		LaunchAnalyses(context.Background(), map[string]*Analysis{"core@file": prev})
+		a.Equal([]AnalysisStatus{{Service: "core@file", Label: "beta", Phase: PhaseProgressing, Weight: 30, Events: []AnalysisEvent{}}}, GetAnalyses())
+
+		b := New("core", statusHandler(http.StatusOK), nil)
+		b.AddService("ng-core-beta-80", statusHandler(http.StatusOK), nil)
+		next, _ := NewAnalysis("core@file", config)
+		a.Nil(next.Attach(b))
+		other, _ := NewAnalysis("other@file", dynamic.LabeledAnalysis{Label: "dev", Interval: ptypes.Duration(time.Hour)})
+		LaunchAnalyses(context.Background(), map[string]*Analysis{"core@file": next, "other@file": other})
+		statuses := GetAnalyses()
+		a.Equal(2, len(statuses))
+		a.Equal(30, statuses[0].Weight)
+		share, _ := b.load().share("core-beta")
+		a.Equal(30, share)
+		a.Equal("other@file", statuses[1].Service)
+		a.Equal(10, statuses[1].Weight)
+
+		// a changed configuration starts over.
+		config.StepWeight = 20
+		next, _ = NewAnalysis("core@file", config)
+		LaunchAnalyses(context.Background(), map[string]*Analysis{"core@file": next})
+		a.Equal(20, GetAnalyses()[0].Weight)
+
+		LaunchAnalyses(context.Background(), nil)
+		a.Equal(0, len(GetAnalyses()))
+	})
+}
+
+func statusHandler(code int) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(code)
+	})
+}
+
+// serveLabeled serves n requests of label with b, the weights of b are ignored.
+func serveLabeled(b *Balancer, label string, n int) {
+	for i := 0; i < n; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if label != "" {
+			req.Header.Set("X-Canary", "label="+label)
+		} else {
+			req.Header.Set("X-Canary", "label=none")
+		}
+		b.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+type closeNotifyRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (r *closeNotifyRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
@@ -1,21 +1,41 @@
 package lrr
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/log"
+	"github.com/traefik/traefik/v2/pkg/middlewares"
 )
 
 type namedHandler struct {
 	http.Handler
-	name string
+	name     string
+	fullName string
+	weight   *int
+	down     int32 // 1 when the service is reported as down, accessed atomically
 }
 
 // New creates a new load balancer.
-func New(defaultServiceName string, handler http.Handler) *Balancer {
-	return &Balancer{serviceName: defaultServiceName, defaultHandler: handler}
+func New(defaultServiceName string, handler http.Handler, hc *dynamic.HealthCheck) *Balancer {
+	b := &Balancer{
+		serviceName:      defaultServiceName,
+		defaultHandler:   handler,
+		wantsHealthCheck: hc != nil,
+	}
+	b.children.Store(&children{defaultWeight: 1})
+	return b
 }
 
 type sliceHandler []*namedHandler
@@ -31,6 +51,30 @@ func (s sliceHandler) Match(name string, fallback bool) *namedHandler {
 	return nil
 }
 
+// Weighted returns a handler named name by weight, the weight of a handler is 1 when not set.
+// The handlers which are down are ignored when upOnly is true.
+func (s sliceHandler) Weighted(name string, upOnly bool) *namedHandler {
+	total := 0
+	for _, handler := range s {
+		if handler.name == name && (!upOnly || handler.isUp()) {
+			total += handler.weightOr(1)
+		}
+	}
+	if total <= 0 {
+		return nil
+	}
+
+	n := rand.Intn(total)
+	for _, handler := range s {
+		if handler.name == name && (!upOnly || handler.isUp()) {
+			if n -= handler.weightOr(1); n < 0 {
+				return handler
+			}
+		}
+	}
+	return nil
+}
+
 func (s sliceHandler) AppendAndSort(h *namedHandler) sliceHandler {
 	s = append(s, h)
 	sort.SliceStable(s, func(i, j int) bool {
@@ -40,40 +84,427 @@ func (s sliceHandler) AppendAndSort(h *namedHandler) sliceHandler {
 }
 
 // Balancer is a labeled load-balancer of services, which select service by label.
+// Its labeled services can be added, removed or replaced while it is serving requests.
 type Balancer struct {
-	serviceName    string
-	defaultHandler http.Handler
-	handlers       sliceHandler
+	serviceName      string
+	defaultHandler   http.Handler
+	wantsHealthCheck bool
+	defaultDown      int32        // 1 when the default service is reported as down, accessed atomically
+	children         atomic.Value // *children
+	// analysis records the requests of the analyzed services and of the default service, when set.
+	analysis *Analysis
+	analyzed string // the name of the analyzed services
+
+	// mutex serializes the updates of the children and of the status.
+	mutex sync.Mutex
+	// updaters is the list of hooks that are run (to update the Balancer
+	// parent(s)), whenever the Balancer status changes.
+	updaters []func(bool)
+}
+
+// children is a snapshot of the labeled services, it is never modified once stored,
+// so that requests are served without locking while the labeled services are updated.
+type children struct {
+	handlers      sliceHandler
+	defaultWeight int
+	// shares are the shares of the requests without label set for labels, by the analysis,
+	// they replace the weights of the handlers of the labels for these requests.
+	shares []labelShare
+	// weighted is true when a handler has weight or a label has a share, then requests without label are split by weight.
+	weighted bool
+}
+
+// labelShare is the share of the requests without label of the handlers named name,
+// which are split between them by weight.
+type labelShare struct {
+	name  string
+	share int
+}
+
+func (c *children) clone() *children {
+	cc := *c
+	cc.handlers = make(sliceHandler, len(c.handlers))
+	copy(cc.handlers, c.handlers)
+	cc.shares = make([]labelShare, len(c.shares))
+	copy(cc.shares, c.shares)
+	return &cc
+}
+
+// share returns the share of the handlers named name, and whether it is set.
+func (c *children) share(name string) (int, bool) {
+	for _, s := range c.shares {
+		if s.name == name {
+			return s.share, true
+		}
+	}
+	return 0, false
+}
+
+func (c *children) remove(fullServiceName string) bool {
+	handlers := c.handlers[:0]
+	for _, h := range c.handlers {
+		if h.fullName != fullServiceName {
+			handlers = append(handlers, h)
+		}
+	}
+	removed := len(handlers) < len(c.handlers)
+	c.handlers = handlers
+	c.weighted = len(c.shares) > 0
+	for _, h := range handlers {
+		if h.weight != nil {
+			c.weighted = true
+		}
+	}
+	return removed
 }
 
 func (b *Balancer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	label, fallback := extractLabel(req.Header)
-	name := b.serviceName
+	handler, useDefault := b.pick(req.Header)
+	switch {
+	case handler != nil:
+		label := strings.TrimPrefix(handler.name, b.serviceName+"-")
+		if c := middlewares.CanaryFromContext(req.Context()); c != nil {
+			c.Served = label
+		}
+		req = withServed(req, Served{Label: label})
+		if b.analysis != nil && handler.name == b.analyzed {
+			b.analysis.canary.serve(handler, w, req)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	case useDefault:
+		label, _ := extractLabel(req.Header)
+		if c := middlewares.CanaryFromContext(req.Context()); c != nil {
+			c.Served = ""
+			c.Fallback = label != ""
+		}
+		req = withServed(req, Served{Fallback: label != ""})
+		if b.analysis != nil {
+			b.analysis.baseline.serve(b.defaultHandler, w, req)
+			return
+		}
+		b.defaultHandler.ServeHTTP(w, req)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError)+": no service found in LRR Balancer", http.StatusInternalServerError)
+	}
+}
+
+type servedKey struct{}
+
+// Served describes how a request was served by a labeled balancer.
+type Served struct {
+	Label    string // the label of the service which served the request, empty for the default service
+	Fallback bool   // the request has a label but it is served by the default service
+}
+
+// ServedFromContext returns how the request of ctx was served by a labeled balancer, if any.
+func ServedFromContext(ctx context.Context) (Served, bool) {
+	s, ok := ctx.Value(servedKey{}).(Served)
+	return s, ok
+}
+
+// withServed records s in the context of req, and in the tracing baggage so that the next hops see it.
+func withServed(req *http.Request, s Served) *http.Request {
+	if span := opentracing.SpanFromContext(req.Context()); span != nil {
+		span.SetTag("canary.served", s.Label)
+		span.SetBaggageItem("canary-fallback", strconv.FormatBool(s.Fallback))
+	}
+	return req.WithContext(context.WithValue(req.Context(), servedKey{}, s))
+}
+
+// Pick returns the full name of the labeled service which would serve a request with header,
+// or an empty name and whether the default service would serve it.
+func (b *Balancer) Pick(header http.Header) (string, bool) {
+	handler, useDefault := b.pick(header)
+	if handler != nil {
+		return handler.fullName, false
+	}
+	return "", useDefault
+}
+
+func (b *Balancer) pick(header http.Header) (*namedHandler, bool) {
+	c := b.load()
+	label, fallback := extractLabel(header)
 	if label != "" {
-		name = fmt.Sprintf("%s-%s", name, label)
-		if handler := b.handlers.Match(name, fallback); handler != nil {
-			handler.ServeHTTP(w, req)
+		name := fmt.Sprintf("%s-%s", b.serviceName, label)
+		if handler := c.handlers.Match(name, fallback); handler != nil {
+			// fall back to the default service when the labeled services are down,
+			// or keep sending to them when the request is nofallback.
+			if h := c.handlers.Weighted(handler.name, true); h != nil {
+				return h, false
+			}
+			if !fallback {
+				if h := c.handlers.Weighted(handler.name, false); h != nil {
+					return h, false
+				}
+			}
+		}
+	} else if c.weighted {
+		return b.pickUnlabeled(c)
+	}
+	return nil, b.defaultHandler != nil && (fallback || label == "")
+}
+
+// pickUnlabeled splits requests without label by weight between the default handler and the weighted handlers,
+// the labels which have a share get it as a whole, which is split by weight between their handlers.
+func (b *Balancer) pickUnlabeled(c *children) (*namedHandler, bool) {
+	total := 0
+	if b.defaultHandler != nil && atomic.LoadInt32(&b.defaultDown) == 0 {
+		total += c.defaultWeight
+	}
+	for _, handler := range c.handlers {
+		if _, ok := c.share(handler.name); !ok && handler.isUp() {
+			total += handler.weightOr(0)
+		}
+	}
+	for _, s := range c.shares {
+		if c.handlers.Weighted(s.name, true) != nil {
+			total += s.share
+		}
+	}
+	if total <= 0 {
+		return nil, b.defaultHandler != nil
+	}
+
+	n := rand.Intn(total)
+	for _, handler := range c.handlers {
+		if _, ok := c.share(handler.name); !ok && handler.isUp() {
+			if n -= handler.weightOr(0); n < 0 {
+				return handler, false
+			}
+		}
+	}
+	for _, s := range c.shares {
+		if h := c.handlers.Weighted(s.name, true); h != nil {
+			if n -= s.share; n < 0 {
+				return h, false
+			}
+		}
+	}
+	return nil, b.defaultHandler != nil
+}
+
+func (b *Balancer) load() *children {
+	if c, ok := b.children.Load().(*children); ok {
+		return c
+	}
+	return &children{defaultWeight: 1}
+}
+
+// update applies fn to a copy of the children which then replaces them,
+// the status change is propagated to the parents.
+func (b *Balancer) update(ctx context.Context, fn func(c *children)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	upBefore := b.isUp()
+	c := b.load().clone()
+	fn(c)
+	b.children.Store(c)
+	b.propagate(ctx, upBefore)
+}
+
+// SetDefaultWeight sets the weight of the default handler, it is 1 by default.
+func (b *Balancer) SetDefaultWeight(weight int) {
+	b.update(context.Background(), func(c *children) {
+		c.defaultWeight = weight
+	})
+}
+
+// SetLabelShare sets the share of the requests without label of the handlers of label,
+// which replaces their weights for these requests, it returns false when there is no such handler.
+// The weights of the handlers still split the requests of label between them.
+func (b *Balancer) SetLabelShare(label string, share int) bool {
+	name := b.serviceName + "-" + label
+	found := false
+	b.update(context.Background(), func(c *children) {
+		for _, h := range c.handlers {
+			if h.name == name {
+				found = true
+			}
+		}
+		if !found {
 			return
 		}
+		for i := range c.shares {
+			if c.shares[i].name == name {
+				c.shares[i].share = share
+				return
+			}
+		}
+		c.shares = append(c.shares, labelShare{name: name, share: share})
+		c.weighted = true
+	})
+	return found
+}
+
+// AddService adds a handler, its label is inferred from fullServiceName.
+// The handler gets requests without label by weight when weight is not nil.
+func (b *Balancer) AddService(fullServiceName string, handler http.Handler, weight *int) {
+	b.addService(removeNsPort(fullServiceName, b.serviceName), fullServiceName, handler, weight)
+}
+
+// AddLabeledService adds a handler of label.
+// The handler gets requests without label by weight when weight is not nil.
+func (b *Balancer) AddLabeledService(label, fullServiceName string, handler http.Handler, weight *int) {
+	b.addService(b.serviceName+"-"+label, fullServiceName, handler, weight)
+}
+
+func (b *Balancer) addService(name, fullServiceName string, handler http.Handler, weight *int) {
+	h := &namedHandler{Handler: handler, name: name, fullName: fullServiceName, weight: weight}
+	b.update(context.Background(), func(c *children) {
+		c.handlers = c.handlers.AppendAndSort(h)
+		if weight != nil {
+			c.weighted = true
+		}
+	})
+}
+
+// RemoveService removes the handlers of fullServiceName, it returns false when there is no such handler.
+// The in-flight requests of the removed handlers are not interrupted.
+func (b *Balancer) RemoveService(fullServiceName string) bool {
+	removed := false
+	b.update(context.Background(), func(c *children) {
+		removed = c.remove(fullServiceName)
+	})
+	return removed
+}
+
+// ReplaceService replaces the handlers of fullServiceName with handler, or adds it when there is no such handler.
+// The label of the replaced handlers is kept, or inferred from fullServiceName.
+// The new handler is up until its status is set.
+func (b *Balancer) ReplaceService(fullServiceName string, handler http.Handler, weight *int) {
+	h := &namedHandler{Handler: handler, name: removeNsPort(fullServiceName, b.serviceName), fullName: fullServiceName, weight: weight}
+	b.update(context.Background(), func(c *children) {
+		for _, old := range c.handlers {
+			if old.fullName == fullServiceName {
+				h.name = old.name
+			}
+		}
+		c.remove(fullServiceName)
+		c.handlers = c.handlers.AppendAndSort(h)
+		if weight != nil {
+			c.weighted = true
+		}
+	})
+}
+
+// SetStatus sets on the balancer that its given labeled service is now of the given status.
+func (b *Balancer) SetStatus(ctx context.Context, childName string, up bool) {
+	b.setStatus(ctx, childName, up, func() {
+		for _, handler := range b.load().handlers {
+			if handler.fullName == childName {
+				atomic.StoreInt32(&handler.down, boolToDown(up))
+			}
+		}
+	})
+}
+
+// SetDefaultStatus sets on the balancer that its default service is now of the given status.
+func (b *Balancer) SetDefaultStatus(ctx context.Context, up bool) {
+	b.setStatus(ctx, "default service", up, func() {
+		atomic.StoreInt32(&b.defaultDown, boolToDown(up))
+	})
+}
+
+func (b *Balancer) setStatus(ctx context.Context, childName string, up bool, update func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	upBefore := b.isUp()
+
+	status := "DOWN"
+	if up {
+		status = "UP"
 	}
+	log.FromContext(ctx).Debugf("Setting status of %s to %v", childName, status)
+	update()
+	b.propagate(ctx, upBefore)
+}
 
-	if b.defaultHandler != nil && (fallback || label == "") {
-		b.defaultHandler.ServeHTTP(w, req)
+// propagate runs the updaters when the status of the balancer is not upBefore anymore.
+func (b *Balancer) propagate(ctx context.Context, upBefore bool) {
+	upAfter := b.isUp()
+	status := "DOWN"
+	if upAfter {
+		status = "UP"
+	}
+
+	// No Status Change
+	if upBefore == upAfter {
+		// We're still with the same status, no need to propagate
+		log.FromContext(ctx).Debugf("Still %s, no need to propagate", status)
 		return
 	}
 
-	http.Error(w, http.StatusText(http.StatusInternalServerError)+": no service found in LRR Balancer", http.StatusInternalServerError)
+	// Status Change
+	log.FromContext(ctx).Debugf("Propagating new %s status", status)
+	for _, fn := range b.updaters {
+		fn(upAfter)
+	}
 }
 
-// AddService adds a handler.
-// It is not thread safe with ServeHTTP.
-func (b *Balancer) AddService(fullServiceName string, handler http.Handler) {
-	h := &namedHandler{Handler: handler, name: removeNsPort(fullServiceName, b.serviceName)}
-	b.handlers = b.handlers.AppendAndSort(h)
+// isUp returns true when the default service or one of the labeled services is up.
+func (b *Balancer) isUp() bool {
+	if b.defaultHandler != nil && atomic.LoadInt32(&b.defaultDown) == 0 {
+		return true
+	}
+	for _, handler := range b.load().handlers {
+		if handler.isUp() {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterStatusUpdater adds fn to the list of hooks that are run when the
+// status of the Balancer changes.
+// Not thread safe.
+func (b *Balancer) RegisterStatusUpdater(fn func(up bool)) error {
+	if !b.wantsHealthCheck {
+		return errors.New("healthCheck not enabled in config for this labeled service")
+	}
+	b.updaters = append(b.updaters, fn)
+	return nil
+}
+
+func (h *namedHandler) isUp() bool {
+	return atomic.LoadInt32(&h.down) == 0
+}
+
+func boolToDown(up bool) int32 {
+	if up {
+		return 0
+	}
+	return 1
+}
+
+func (h *namedHandler) weightOr(def int) int {
+	if h.weight == nil {
+		return def
+	}
+	return *h.weight
 }
 
 var isPortReg = regexp.MustCompile(`^\d+$`)
 
+var validLabelReg = regexp.MustCompile(`^[0-9a-z][0-9a-z-]{0,62}$`)
+
+// ValidLabel returns true when label can be mapped to a service.
+func ValidLabel(label string) bool {
+	return validLabelReg.MatchString(label)
+}
+
+// InferLabel returns the label of fullServiceName, which should be named as `{namespace}-{serviceName}-{label}-{port}`,
+// it returns false when the label cannot be inferred.
+func InferLabel(fullServiceName, serviceName string) (string, bool) {
+	name := removeNsPort(fullServiceName, serviceName)
+	if !strings.HasPrefix(name, serviceName+"-") || len(name) == len(serviceName)+1 {
+		return "", false
+	}
+	return name[len(serviceName)+1:], true
+}
+
 // full service name format (build by fullServiceName function): namespace-serviceName-port
 func removeNsPort(fullServiceName, ServiceName string) string {
 	i := strings.Index(fullServiceName, "-"+ServiceName)
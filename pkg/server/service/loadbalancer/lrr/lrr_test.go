@@ -1,12 +1,60 @@
 package lrr
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 
+	gokitmetrics "github.com/go-kit/kit/metrics"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/stretchr/testify/assert"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	traefikmetrics "github.com/traefik/traefik/v2/pkg/metrics"
+	"github.com/traefik/traefik/v2/pkg/middlewares"
+	"github.com/traefik/traefik/v2/pkg/middlewares/metrics"
 )
 
+// canaryRegistry is a metrics registry which adds the canary labels to the requests counter of services.
+type canaryRegistry struct {
+	traefikmetrics.Registry
+	counter *seriesCounter
+}
+
+func (r *canaryRegistry) IsSvcCanaryEnabled() bool {
+	return true
+}
+
+func (r *canaryRegistry) ServiceReqsCounter() gokitmetrics.Counter {
+	return r.counter
+}
+
+// seriesCounter records the canary labels of the series it is called with.
+type seriesCounter struct {
+	mu     sync.Mutex
+	series map[string]bool
+}
+
+func (c *seriesCounter) With(labelValues ...string) gokitmetrics.Counter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var labels []string
+	for i := 0; i+1 < len(labelValues); i += 2 {
+		if strings.HasPrefix(labelValues[i], "canary") {
+			labels = append(labels, labelValues[i]+"="+labelValues[i+1])
+		}
+	}
+	c.series[strings.Join(labels, ",")] = true
+	return c
+}
+
+func (c *seriesCounter) Add(float64) {}
+
 func TestLRRBalancer(t *testing.T) {
 	t.Run("removeNsPort should work", func(t *testing.T) {
 		a := assert.New(t)
@@ -145,4 +193,319 @@ func TestLRRBalancer(t *testing.T) {
 		a.Equal("beta", label)
 		a.False(fallback)
 	})
+
+	t.Run("Pick should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		b := New("core", http.NotFoundHandler(), nil)
+		b.AddService("ng-core-beta-80", http.NotFoundHandler(), nil)
+		b.AddService("ng-core-canary-80", http.NotFoundHandler(), nil)
+
+		header := http.Header{}
+		name, useDefault := b.Pick(header)
+		a.Equal("", name)
+		a.True(useDefault)
+
+		header.Set("X-Canary", "label=beta")
+		name, useDefault = b.Pick(header)
+		a.Equal("ng-core-beta-80", name)
+		a.False(useDefault)
+
+		header.Set("X-Canary", "label=canary-v1")
+		name, _ = b.Pick(header)
+		a.Equal("ng-core-canary-80", name)
+
+		header.Set("X-Canary", "label=dev")
+		name, useDefault = b.Pick(header)
+		a.Equal("", name)
+		a.True(useDefault)
+
+		header.Set("X-Canary", "label=dev,nofallback")
+		name, useDefault = b.Pick(header)
+		a.Equal("", name)
+		a.False(useDefault)
+
+		b = New("core", nil, nil)
+		header.Set("X-Canary", "label=dev")
+		_, useDefault = b.Pick(header)
+		a.False(useDefault)
+	})
+
+	t.Run("weighted split should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		weight := func(i int) *int { return &i }
+		b := New("core", http.NotFoundHandler(), nil)
+		b.SetDefaultWeight(90)
+		b.AddService("ng-core-beta-80", http.NotFoundHandler(), weight(10))
+		b.AddService("ng-core-dev-80", http.NotFoundHandler(), nil)
+		b.AddService("ng1-core-stable-80", http.NotFoundHandler(), weight(3))
+		b.AddService("ng2-core-stable-80", http.NotFoundHandler(), weight(1))
+		b.AddService("ng3-core-stable-80", http.NotFoundHandler(), weight(0))
+
+		counts := make(map[string]int)
+		header := http.Header{}
+		for i := 0; i < 10000; i++ {
+			name, useDefault := b.Pick(header)
+			if useDefault {
+				name = "default"
+			}
+			counts[name]++
+		}
+		a.Equal(0, counts["ng-core-dev-80"])
+		a.Equal(0, counts["ng3-core-stable-80"])
+		a.InDelta(8654, counts["default"], 300)
+		a.InDelta(962, counts["ng-core-beta-80"], 200)
+		a.InDelta(385, counts["ng1-core-stable-80"]+counts["ng2-core-stable-80"], 120)
+
+		counts = make(map[string]int)
+		header.Set("X-Canary", "label=stable")
+		for i := 0; i < 10000; i++ {
+			name, _ := b.Pick(header)
+			counts[name]++
+		}
+		a.Equal(0, counts["ng3-core-stable-80"])
+		a.InDelta(7500, counts["ng1-core-stable-80"], 300)
+		a.InDelta(2500, counts["ng2-core-stable-80"], 300)
+
+		header.Set("X-Canary", "label=dev")
+		name, _ := b.Pick(header)
+		a.Equal("ng-core-dev-80", name)
+
+		b.SetDefaultWeight(0)
+		header = http.Header{}
+		for i := 0; i < 100; i++ {
+			_, useDefault := b.Pick(header)
+			a.False(useDefault)
+		}
+	})
+
+	t.Run("health-aware fallback should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		b := New("core", http.NotFoundHandler(), nil)
+		a.NotNil(b.RegisterStatusUpdater(func(up bool) {}))
+
+		b = New("core", http.NotFoundHandler(), &dynamic.HealthCheck{})
+		statuses := make([]bool, 0)
+		a.Nil(b.RegisterStatusUpdater(func(up bool) {
+			statuses = append(statuses, up)
+		}))
+		b.AddService("ng1-core-beta-80", http.NotFoundHandler(), nil)
+		b.AddService("ng2-core-beta-80", http.NotFoundHandler(), nil)
+
+		ctx := context.Background()
+		b.SetStatus(ctx, "ng1-core-beta-80", false)
+		header := http.Header{}
+		header.Set("X-Canary", "label=beta")
+		for i := 0; i < 10; i++ {
+			name, _ := b.Pick(header)
+			a.Equal("ng2-core-beta-80", name)
+		}
+
+		b.SetStatus(ctx, "ng2-core-beta-80", false)
+		name, useDefault := b.Pick(header)
+		a.Equal("", name)
+		a.True(useDefault)
+
+		header.Set("X-Canary", "label=beta,nofallback")
+		name, useDefault = b.Pick(header)
+		a.Contains([]string{"ng1-core-beta-80", "ng2-core-beta-80"}, name)
+		a.False(useDefault)
+		a.Equal(0, len(statuses))
+
+		b.SetDefaultStatus(ctx, false)
+		a.Equal([]bool{false}, statuses)
+		b.SetDefaultStatus(ctx, false)
+		a.Equal([]bool{false}, statuses)
+
+		b.SetStatus(ctx, "ng1-core-beta-80", true)
+		a.Equal([]bool{false, true}, statuses)
+		header.Set("X-Canary", "label=beta")
+		name, _ = b.Pick(header)
+		a.Equal("ng1-core-beta-80", name)
+	})
+
+	t.Run("add, remove and replace services should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		handler := func(code int) http.Handler {
+			return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(code)
+			})
+		}
+
+		b := New("core", handler(http.StatusOK), nil)
+		b.AddService("ng-core-beta-80", handler(http.StatusAccepted), nil)
+
+		header := http.Header{}
+		header.Set("X-Canary", "label=canary")
+		name, useDefault := b.Pick(header)
+		a.Equal("", name)
+		a.True(useDefault)
+
+		b.AddService("ng-core-canary-80", handler(http.StatusAccepted), nil)
+		name, _ = b.Pick(header)
+		a.Equal("ng-core-canary-80", name)
+
+		b.ReplaceService("ng-core-canary-80", handler(http.StatusCreated), nil)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Canary", "label=canary")
+		rw := httptest.NewRecorder()
+		b.ServeHTTP(rw, req)
+		a.Equal(http.StatusCreated, rw.Code)
+
+		a.True(b.RemoveService("ng-core-canary-80"))
+		a.False(b.RemoveService("ng-core-canary-80"))
+		rw = httptest.NewRecorder()
+		b.ServeHTTP(rw, req)
+		a.Equal(http.StatusOK, rw.Code)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func(i int) {
+				defer wg.Done()
+				name := fmt.Sprintf("ng%d-core-canary-80", i)
+				b.AddService(name, handler(http.StatusAccepted), nil)
+				b.SetStatus(context.Background(), name, false)
+				b.ReplaceService(name, handler(http.StatusCreated), nil)
+				b.RemoveService(name)
+			}(i)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					req := httptest.NewRequest(http.MethodGet, "/", nil)
+					req.Header.Set("X-Canary", "label=canary")
+					rw := httptest.NewRecorder()
+					b.ServeHTTP(rw, req)
+					a.NotEqual(http.StatusInternalServerError, rw.Code)
+				}
+			}()
+		}
+		wg.Wait()
+
+		name, useDefault = b.Pick(header)
+		a.Equal("", name)
+		a.True(useDefault)
+		header.Set("X-Canary", "label=beta")
+		name, _ = b.Pick(header)
+		a.Equal("ng-core-beta-80", name)
+	})
+
+	t.Run("labels should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		a.True(ValidLabel("beta"))
+		a.True(ValidLabel("canary-v1"))
+		a.False(ValidLabel(""))
+		a.False(ValidLabel("Beta"))
+		a.False(ValidLabel("-beta"))
+		a.False(ValidLabel("beta,dev"))
+
+		label, ok := InferLabel("ng-core-beta-80", "core")
+		a.True(ok)
+		a.Equal("beta", label)
+		label, ok = InferLabel("ng-core-canary-next-80", "core")
+		a.True(ok)
+		a.Equal("canary-next", label)
+		_, ok = InferLabel("ng-other-80", "core")
+		a.False(ok)
+		_, ok = InferLabel("ng-core-80", "core")
+		a.False(ok)
+
+		handler := func(code int) http.Handler {
+			return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(code)
+			})
+		}
+
+		b := New("core", handler(http.StatusOK), nil)
+		b.AddLabeledService("beta", "ng-weird-name-80", handler(http.StatusAccepted), nil)
+
+		header := http.Header{}
+		header.Set("X-Canary", "label=beta")
+		name, _ := b.Pick(header)
+		a.Equal("ng-weird-name-80", name)
+
+		b.ReplaceService("ng-weird-name-80", handler(http.StatusCreated), nil)
+		name, _ = b.Pick(header)
+		a.Equal("ng-weird-name-80", name)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Canary", "label=beta")
+		rw := httptest.NewRecorder()
+		b.ServeHTTP(rw, req)
+		a.Equal(http.StatusCreated, rw.Code)
+	})
+
+	t.Run("served label should work", func(t *testing.T) {
+		a := assert.New(t)
+
+		var served Served
+		handler := func(code int) http.Handler {
+			return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				served, _ = ServedFromContext(req.Context())
+				rw.WriteHeader(code)
+			})
+		}
+
+		b := New("core", handler(http.StatusOK), nil)
+		b.AddService("ng-core-beta-80", handler(http.StatusAccepted), nil)
+
+		span := mocktracer.New().StartSpan("test")
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(opentracing.ContextWithSpan(req.Context(), span))
+		req.Header.Set("X-Canary", "label=beta")
+		b.ServeHTTP(httptest.NewRecorder(), req)
+		a.Equal(Served{Label: "beta"}, served)
+		a.Equal("false", span.BaggageItem("canary-fallback"))
+
+		req.Header.Set("X-Canary", "label=dev")
+		b.ServeHTTP(httptest.NewRecorder(), req)
+		a.Equal(Served{Fallback: true}, served)
+		a.Equal("true", span.BaggageItem("canary-fallback"))
+
+		req.Header.Del("X-Canary")
+		b.ServeHTTP(httptest.NewRecorder(), req)
+		a.Equal(Served{}, served)
+
+		_, ok := ServedFromContext(context.Background())
+		a.False(ok)
+
+		req = req.WithContext(middlewares.WithCanary(req.Context(), "dev"))
+		req.Header.Set("X-Canary", "label=dev")
+		b.ServeHTTP(httptest.NewRecorder(), req)
+		a.True(middlewares.CanaryFromContext(req.Context()).Fallback)
+
+		req = req.WithContext(middlewares.WithCanary(req.Context(), "beta"))
+		req.Header.Set("X-Canary", "label=beta")
+		b.ServeHTTP(httptest.NewRecorder(), req)
+		a.False(middlewares.CanaryFromContext(req.Context()).Fallback)
+		a.Equal("beta", middlewares.CanaryFromContext(req.Context()).Served)
+	})
+
+	t.Run("requests metrics should only have the served labels", func(t *testing.T) {
+		a := assert.New(t)
+
+		b := New("core", http.NotFoundHandler(), nil)
+		b.AddService("ng-core-beta-80", http.NotFoundHandler(), nil)
+
+		registry := &canaryRegistry{Registry: traefikmetrics.NewVoidRegistry(), counter: &seriesCounter{series: map[string]bool{}}}
+		handler := metrics.NewServiceMiddleware(context.Background(), b, registry, "core")
+		for i := 0; i < 100; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Canary", fmt.Sprintf("label=l%d", i))
+			req = req.WithContext(middlewares.WithCanary(req.Context(), fmt.Sprintf("l%d", i)))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Canary", "label=beta")
+		req = req.WithContext(middlewares.WithCanary(req.Context(), "beta"))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		a.Len(registry.counter.series, 2)
+		a.True(registry.counter.series["canary=beta,canary_fallback=false"])
+		a.True(registry.counter.series["canary=,canary_fallback=true"])
+	})
 }
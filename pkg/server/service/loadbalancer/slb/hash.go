@@ -0,0 +1,152 @@
+package slb
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/ip"
+)
+
+// virtualNodes is the number of points of a server of weight 1 on the ring.
+const virtualNodes = 100
+
+// consistentHash picks the servers by the hash of a key of the requests on a ring of virtual nodes,
+// so that a change of the servers only remaps the keys of the changed servers.
+// The load of the servers is bounded, https://arxiv.org/abs/1608.01350:
+// a server which has more requests in flight than its share of loadFactor times all of them is skipped.
+type consistentHash struct {
+	key        func(req *http.Request) string
+	clientIP   ip.Strategy
+	loadFactor float64
+
+	ring atomic.Value // *ring
+}
+
+type ring struct {
+	hashes      []uint64
+	servers     []*server // the server of each hash
+	all         []*server
+	totalWeight int
+}
+
+func newConsistentHash(cfg *dynamic.ConsistentHash) (*consistentHash, error) {
+	if cfg == nil {
+		cfg = &dynamic.ConsistentHash{}
+		cfg.SetDefaults()
+	}
+
+	p := &consistentHash{loadFactor: cfg.LoadFactor}
+	if p.loadFactor == 0 {
+		p.loadFactor = 1.25
+	}
+	if p.loadFactor < 1 {
+		return nil, errors.New("the load factor of the consistent hash must be greater than 1")
+	}
+
+	strategy, err := cfg.IPStrategy.Get()
+	if err != nil {
+		return nil, err
+	}
+	p.clientIP = strategy
+
+	sources := 0
+	for _, set := range []bool{cfg.Header != "", cfg.Cookie != "", cfg.Query != "", cfg.Path, cfg.IPStrategy != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, errors.New("header, cookie, query, path and ipStrategy of the consistent hash are mutually exclusive")
+	}
+
+	switch {
+	case cfg.Header != "":
+		p.key = func(req *http.Request) string { return req.Header.Get(cfg.Header) }
+	case cfg.Cookie != "":
+		p.key = func(req *http.Request) string {
+			if cookie, _ := req.Cookie(cfg.Cookie); cookie != nil {
+				return cookie.Value
+			}
+			return ""
+		}
+	case cfg.Query != "":
+		p.key = func(req *http.Request) string { return req.URL.Query().Get(cfg.Query) }
+	case cfg.Path:
+		p.key = func(req *http.Request) string { return req.URL.Path }
+	default:
+		p.key = strategy.GetIP
+	}
+	return p, nil
+}
+
+func (p *consistentHash) update(servers []*server) {
+	r := &ring{all: servers}
+	for _, s := range servers {
+		r.totalWeight += s.weight
+		name := s.url.String()
+		for i := 0; i < virtualNodes*s.weight; i++ {
+			r.hashes = append(r.hashes, hash(name+"#"+strconv.Itoa(i)))
+			r.servers = append(r.servers, s)
+		}
+	}
+	sort.Sort(r)
+	p.ring.Store(r)
+}
+
+func (p *consistentHash) pick(req *http.Request, servers []*server) *server {
+	r, _ := p.ring.Load().(*ring)
+	if r == nil || len(r.hashes) == 0 {
+		return servers[0]
+	}
+
+	key := p.key(req)
+	if key == "" {
+		key = p.clientIP.GetIP(req)
+	}
+	h := hash(key)
+	start := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+
+	var total int64
+	for _, s := range r.all {
+		total += atomic.LoadInt64(&s.outstanding)
+	}
+	for i := 0; i < len(r.hashes); i++ {
+		s := r.servers[(start+i)%len(r.hashes)]
+		bound := math.Ceil(p.loadFactor * float64(total+1) * float64(s.weight) / float64(r.totalWeight))
+		if float64(atomic.LoadInt64(&s.outstanding)+1) <= bound {
+			return s
+		}
+	}
+	return r.servers[start%len(r.hashes)]
+}
+
+// Len implements sort.Interface.
+func (r *ring) Len() int { return len(r.hashes) }
+
+// Less implements sort.Interface.
+func (r *ring) Less(i, j int) bool { return r.hashes[i] < r.hashes[j] }
+
+// Swap implements sort.Interface.
+func (r *ring) Swap(i, j int) {
+	r.hashes[i], r.hashes[j] = r.hashes[j], r.hashes[i]
+	r.servers[i], r.servers[j] = r.servers[j], r.servers[i]
+}
+
+// hash returns the FNV-1a hash of s, mixed by the finalizer of SplitMix64 to spread the similar strings on the ring.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
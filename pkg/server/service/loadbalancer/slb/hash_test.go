@@ -0,0 +1,196 @@
+package slb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/vulcand/oxy/roundrobin"
+)
+
+func TestNewConsistentHash(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		config        *dynamic.ConsistentHash
+		target        string
+		header        string
+		cookie        string
+		expected      string
+		expectedError bool
+	}{
+		{
+			desc:     "default is the client ip",
+			expected: "192.0.2.1",
+		},
+		{
+			desc:     "header",
+			config:   &dynamic.ConsistentHash{Header: "X-Session"},
+			header:   "s1",
+			expected: "s1",
+		},
+		{
+			desc:     "cookie",
+			config:   &dynamic.ConsistentHash{Cookie: "session"},
+			cookie:   "c1",
+			expected: "c1",
+		},
+		{
+			desc:     "query",
+			config:   &dynamic.ConsistentHash{Query: "id"},
+			target:   "/?id=q1",
+			expected: "q1",
+		},
+		{
+			desc:     "path",
+			config:   &dynamic.ConsistentHash{Path: true},
+			target:   "/foo/bar",
+			expected: "/foo/bar",
+		},
+		{
+			desc:     "ip strategy",
+			config:   &dynamic.ConsistentHash{IPStrategy: &dynamic.IPStrategy{Depth: 1}},
+			header:   "10.0.0.1, 10.0.0.2",
+			expected: "10.0.0.2",
+		},
+		{
+			desc:          "mutually exclusive",
+			config:        &dynamic.ConsistentHash{Header: "X-Session", Path: true},
+			expectedError: true,
+		},
+		{
+			desc:          "invalid load factor",
+			config:        &dynamic.ConsistentHash{Header: "X-Session", LoadFactor: 0.5},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := newConsistentHash(test.config)
+			if test.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, 1.25, p.loadFactor)
+
+			target := test.target
+			if target == "" {
+				target = "/"
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			if test.header != "" {
+				req.Header.Set("X-Session", test.header)
+				req.Header.Set("X-Forwarded-For", test.header)
+			}
+			if test.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "session", Value: test.cookie})
+			}
+			assert.Equal(t, test.expected, p.key(req))
+		})
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	fwd := newForwarder()
+	b, err := New(fwd, &dynamic.ServersLoadBalancer{
+		Strategy:       StrategyConsistentHash,
+		ConsistentHash: &dynamic.ConsistentHash{Header: "X-Session"},
+	}, nil, nil, nil)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, b.UpsertServer(mustParse(t, fmt.Sprintf("http://s%d", i))))
+	}
+
+	p := b.picker.(*consistentHash)
+	picks := func() map[string]string {
+		m := map[string]string{}
+		for i := 0; i < 1000; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Session", fmt.Sprintf("session-%d", i))
+			m[req.Header.Get("X-Session")] = p.pick(req, b.active).url.Host
+		}
+		return m
+	}
+
+	before := picks()
+	assert.Equal(t, before, picks())
+
+	servers := map[string]int{}
+	for _, host := range before {
+		servers[host]++
+	}
+	assert.Len(t, servers, 10)
+	for host, n := range servers {
+		assert.Greater(t, n, 30, host)
+	}
+
+	// only the keys of the removed server are remapped.
+	require.NoError(t, b.RemoveServer(mustParse(t, "http://s3")))
+	after := picks()
+	for key, host := range before {
+		if host != "s3" {
+			assert.Equal(t, host, after[key], key)
+		} else {
+			assert.NotEqual(t, "s3", after[key], key)
+		}
+	}
+
+	// only the keys of the added server are remapped.
+	require.NoError(t, b.UpsertServer(mustParse(t, "http://s3")))
+	assert.Equal(t, before, picks())
+
+	// the keys of a drained server are remapped.
+	require.NoError(t, b.UpsertServer(mustParse(t, "http://s3"), roundrobin.Weight(0)))
+	assert.Equal(t, after, picks())
+}
+
+func TestConsistentHashBoundedLoad(t *testing.T) {
+	fwd := newForwarder()
+	block := make(chan struct{})
+	b, err := New(fwd, &dynamic.ServersLoadBalancer{
+		Strategy:       StrategyConsistentHash,
+		ConsistentHash: &dynamic.ConsistentHash{Header: "X-Session"},
+	}, nil, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, b.UpsertServer(mustParse(t, "http://a")))
+	require.NoError(t, b.UpsertServer(mustParse(t, "http://b")))
+	require.NoError(t, b.UpsertServer(mustParse(t, "http://c")))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Session", "hot")
+	host := b.picker.pick(req, b.active).url.Host
+	fwd.blocked[host] = block
+
+	// the requests of the hot key spill over to the other servers once its server is over its bound.
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Session", "hot")
+			b.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Eventually(t, func() bool {
+		return fwd.count("a")+fwd.count("b")+fwd.count("c") == 12
+	}, time.Second, time.Millisecond)
+	// the bound of a server is ceil(1.25 * (n+1) / 3) for n requests in flight,
+	// which is 1 while the other servers answer at once.
+	assert.LessOrEqual(t, fwd.count(host), 2)
+
+	close(block)
+	wg.Wait()
+}
@@ -0,0 +1,109 @@
+package slb
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/types"
+)
+
+// locality picks the servers of the zone of the instance while their load is under maxLoad,
+// then the servers of the next zones in priority order: the failover zones of the instance,
+// the other zones by name, and the servers without zone.
+// When all the zones are over maxLoad, the servers are picked among all of them.
+type locality struct {
+	next     picker
+	zone     string
+	failover []string
+	maxLoad  float64
+
+	zones atomic.Value // []*zone, in priority order
+}
+
+type zone struct {
+	servers     []*server
+	totalWeight int
+}
+
+func newLocality(next picker, instance *types.Locality, cfg *dynamic.Locality) (*locality, error) {
+	if instance == nil || instance.Zone == "" {
+		return nil, errors.New("the zone of the instance must be set for the locality-aware load balancing")
+	}
+	if _, ok := next.(updater); ok {
+		return nil, errors.New("the locality-aware load balancing does not support the strategies which keep a state of the servers")
+	}
+
+	p := &locality{next: next, zone: instance.Zone, failover: instance.Failover, maxLoad: cfg.MaxLoad}
+	if p.maxLoad == 0 {
+		p.maxLoad = 10
+	}
+	if p.maxLoad < 0 {
+		return nil, errors.New("the max load of the locality must be positive")
+	}
+	return p, nil
+}
+
+func (p *locality) update(servers []*server) {
+	priorities := map[string]int{p.zone: 0}
+	for i, name := range p.failover {
+		if _, ok := priorities[name]; !ok {
+			priorities[name] = i + 1
+		}
+	}
+
+	byName := map[string]*zone{}
+	var names []string
+	for _, s := range servers {
+		z, ok := byName[s.zone]
+		if !ok {
+			z = &zone{}
+			byName[s.zone] = z
+			names = append(names, s.zone)
+		}
+		z.servers = append(z.servers, s)
+		z.totalWeight += s.weight
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		pi, oki := priorities[names[i]]
+		pj, okj := priorities[names[j]]
+		switch {
+		case oki && okj:
+			return pi < pj
+		case oki != okj:
+			return oki
+		case (names[i] == "") != (names[j] == ""):
+			return names[j] == ""
+		default:
+			return names[i] < names[j]
+		}
+	})
+
+	zones := make([]*zone, len(names))
+	for i, name := range names {
+		zones[i] = byName[name]
+	}
+	p.zones.Store(zones)
+}
+
+func (p *locality) pick(req *http.Request, servers []*server) *server {
+	zones, _ := p.zones.Load().([]*zone)
+	for _, z := range zones {
+		if z.load() <= p.maxLoad {
+			return p.next.pick(req, z.servers)
+		}
+	}
+	return p.next.pick(req, servers)
+}
+
+// load returns the average requests in flight of the servers of z, with the one to be picked, relative to their weight.
+func (z *zone) load() float64 {
+	var outstanding int64
+	for _, s := range z.servers {
+		outstanding += atomic.LoadInt64(&s.outstanding)
+	}
+	return float64(outstanding+1) / float64(z.totalWeight)
+}
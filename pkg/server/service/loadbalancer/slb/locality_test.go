@@ -0,0 +1,172 @@
+package slb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/testhelpers"
+	"github.com/traefik/traefik/v2/pkg/types"
+)
+
+func TestNewLocality(t *testing.T) {
+	testCases := []struct {
+		desc            string
+		config          *dynamic.ServersLoadBalancer
+		instance        *types.Locality
+		expectedMaxLoad float64
+		expectedError   bool
+	}{
+		{
+			desc:            "round robin",
+			config:          &dynamic.ServersLoadBalancer{Locality: &dynamic.Locality{}},
+			instance:        &types.Locality{Zone: "zone-a"},
+			expectedMaxLoad: 10,
+		},
+		{
+			desc:            "least request",
+			config:          &dynamic.ServersLoadBalancer{Strategy: StrategyLeastRequest, Locality: &dynamic.Locality{MaxLoad: 2}},
+			instance:        &types.Locality{Zone: "zone-a"},
+			expectedMaxLoad: 2,
+		},
+		{
+			desc:          "no zone",
+			config:        &dynamic.ServersLoadBalancer{Locality: &dynamic.Locality{}},
+			instance:      &types.Locality{},
+			expectedError: true,
+		},
+		{
+			desc:          "no locality",
+			config:        &dynamic.ServersLoadBalancer{Locality: &dynamic.Locality{}},
+			expectedError: true,
+		},
+		{
+			desc:          "negative max load",
+			config:        &dynamic.ServersLoadBalancer{Locality: &dynamic.Locality{MaxLoad: -1}},
+			instance:      &types.Locality{Zone: "zone-a"},
+			expectedError: true,
+		},
+		{
+			desc:          "consistent hash",
+			config:        &dynamic.ServersLoadBalancer{Strategy: StrategyConsistentHash, Locality: &dynamic.Locality{}},
+			instance:      &types.Locality{Zone: "zone-a"},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			b, err := New(http.NotFoundHandler(), test.config, nil, test.instance, nil)
+			if test.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.IsType(t, &locality{}, b.picker)
+			assert.Equal(t, test.expectedMaxLoad, b.picker.(*locality).maxLoad)
+		})
+	}
+}
+
+func newLocalityBalancer(t *testing.T, fwd http.Handler, zoneRequests metrics.Counter) *Balancer {
+	t.Helper()
+
+	config := &dynamic.ServersLoadBalancer{
+		Locality: &dynamic.Locality{MaxLoad: 1},
+		Servers: []dynamic.Server{
+			{URL: "http://a1", Zone: "zone-a"},
+			{URL: "http://a2", Zone: "zone-a"},
+			{URL: "http://b1", Zone: "zone-b"},
+			{URL: "http://c1", Zone: "zone-c"},
+			{URL: "http://n1"},
+		},
+	}
+	instance := &types.Locality{Zone: "zone-a", Failover: []string{"zone-c"}}
+
+	b, err := New(fwd, config, nil, instance, zoneRequests)
+	require.NoError(t, err)
+
+	for _, srv := range config.Servers {
+		require.NoError(t, b.UpsertServer(mustParse(t, srv.URL)))
+	}
+	return b
+}
+
+func TestLocalityZones(t *testing.T) {
+	b := newLocalityBalancer(t, newForwarder(), nil)
+
+	var zones [][]string
+	for _, z := range b.picker.(*locality).zones.Load().([]*zone) {
+		var hosts []string
+		for _, s := range z.servers {
+			hosts = append(hosts, s.url.Host)
+		}
+		zones = append(zones, hosts)
+	}
+
+	// the zone of the instance, the failover zones, the other zones, and the servers without zone.
+	assert.Equal(t, [][]string{{"a1", "a2"}, {"c1"}, {"b1"}, {"n1"}}, zones)
+}
+
+func TestLocalitySpillover(t *testing.T) {
+	fwd := newForwarder()
+	block := make(chan struct{})
+	fwd.blocked["a1"] = block
+	fwd.blocked["a2"] = block
+	fwd.blocked["c1"] = block
+
+	b := newLocalityBalancer(t, fwd, nil)
+
+	// the zone of the instance takes 2 requests in flight, the failover zone 1,
+	// the next zone takes the other ones as they are answered at once.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Eventually(t, func() bool {
+		return fwd.count("a1")+fwd.count("a2")+fwd.count("b1")+fwd.count("c1") == 5
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 1, fwd.count("a1"))
+	assert.Equal(t, 1, fwd.count("a2"))
+	assert.Equal(t, 1, fwd.count("c1"))
+	assert.Equal(t, 2, fwd.count("b1"))
+	assert.Equal(t, 0, fwd.count("n1"))
+
+	close(block)
+	wg.Wait()
+
+	// the zone of the instance is unavailable once its servers are removed.
+	require.NoError(t, b.RemoveServer(mustParse(t, "http://a1")))
+	require.NoError(t, b.RemoveServer(mustParse(t, "http://a2")))
+	b.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, 2, fwd.count("c1"))
+}
+
+func TestLocalityZoneRequests(t *testing.T) {
+	zoneRequests := &testhelpers.CollectingCounter{}
+	b := newLocalityBalancer(t, newForwarder(), zoneRequests)
+
+	b.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, []string{"zone", "zone-a", "cross_zone", "false"}, zoneRequests.LastLabelValues)
+
+	require.NoError(t, b.RemoveServer(mustParse(t, "http://a1")))
+	require.NoError(t, b.RemoveServer(mustParse(t, "http://a2")))
+	b.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, []string{"zone", "zone-c", "cross_zone", "true"}, zoneRequests.LastLabelValues)
+	assert.Equal(t, float64(2), zoneRequests.CounterValue)
+}
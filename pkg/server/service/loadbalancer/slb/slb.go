@@ -0,0 +1,413 @@
+package slb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/types"
+	"github.com/vulcand/oxy/roundrobin"
+	"github.com/vulcand/oxy/utils"
+)
+
+// Strategies of the servers load balancer,
+// RoundRobin is served by the round robin balancer of oxy unless the load balancing is locality-aware.
+const (
+	StrategyRoundRobin     = "RoundRobin"
+	StrategyLeastRequest   = "LeastRequest"
+	StrategyPeakEWMA       = "PeakEWMA"
+	StrategyP2C            = "P2C"
+	StrategyConsistentHash = "ConsistentHash"
+)
+
+const (
+	// decayTime is the time for the peak EWMA latency of a server to decay to 1/e of its value.
+	decayTime = 10 * time.Second
+	// initialLatency is the latency assumed for a server until its first response.
+	initialLatency = 100 * time.Millisecond
+)
+
+var errNoAvailableServer = errors.New("no available server")
+
+// server is never modified once added, an updated server shares the stats of the previous one.
+type server struct {
+	url    *url.URL
+	weight int
+	zone   string
+	*stats
+}
+
+type stats struct {
+	outstanding int64 // the requests in flight, accessed atomically
+
+	mu      sync.Mutex
+	latency float64 // the peak EWMA of the latency, in nanoseconds
+	stamp   time.Time
+
+	currentWeight int // the current weight of the smooth weighted round robin, guarded by the mutex of roundRobin
+}
+
+// load returns the requests in flight of s, with the one to be picked, relative to its weight.
+func (s *server) load() float64 {
+	return float64(atomic.LoadInt64(&s.outstanding)+1) / float64(s.weight)
+}
+
+// cost returns the peak EWMA latency of s, multiplied by its load.
+func (s *server) cost() float64 {
+	s.mu.Lock()
+	latency := s.latency
+	s.mu.Unlock()
+	return latency * s.load()
+}
+
+// observe records the latency of a response at now,
+// the peaks are recorded as is, so that a slowing server is avoided at once.
+func (s *stats) observe(latency time.Duration, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elapsed := now.Sub(s.stamp)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	s.stamp = now
+
+	rtt := float64(latency)
+	if rtt > s.latency {
+		s.latency = rtt
+		return
+	}
+	w := math.Exp(-float64(elapsed) / float64(decayTime))
+	s.latency = s.latency*w + rtt*(1-w)
+}
+
+// picker picks one of the servers which have a positive weight.
+type picker interface {
+	pick(req *http.Request, servers []*server) *server
+}
+
+// updater is implemented by the pickers which keep a state of the servers,
+// it is called with the servers which have a positive weight each time they change.
+type updater interface {
+	update(servers []*server)
+}
+
+// Balancer is a load balancer of servers which picks the servers by their load,
+// it implements healthcheck.BalancerHandler.
+type Balancer struct {
+	next    http.Handler
+	picker  picker
+	sticky  *roundrobin.StickySession
+	latency bool // whether the latencies of the servers are observed
+
+	zones        map[string]string // the zones of the servers of the configuration, by server key
+	zone         string            // the zone of the instance, when the load balancing is locality-aware
+	zoneRequests metrics.Counter
+
+	mutex   sync.RWMutex
+	servers []*server
+	active  []*server // the servers which have a positive weight
+}
+
+// New creates a load balancer of the strategy of config, which forwards the requests to next.
+// When config enables the locality-aware load balancing, the servers of the zone of instance are preferred,
+// and the requests are counted by zone in zoneRequests, which can be nil.
+func New(next http.Handler, config *dynamic.ServersLoadBalancer, sticky *roundrobin.StickySession, instance *types.Locality, zoneRequests metrics.Counter) (*Balancer, error) {
+	b := &Balancer{next: next, sticky: sticky, zones: map[string]string{}}
+	for _, srv := range config.Servers {
+		u, err := url.Parse(srv.URL)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing server URL %s: %w", srv.URL, err)
+		}
+		b.zones[serverKey(u)] = srv.Zone
+	}
+
+	switch config.Strategy {
+	case "", StrategyRoundRobin:
+		if config.Locality == nil {
+			return nil, fmt.Errorf("the %s strategy is served by the round robin balancer", StrategyRoundRobin)
+		}
+		b.picker = &roundRobin{}
+	case StrategyLeastRequest:
+		b.picker = &leastRequest{}
+	case StrategyP2C:
+		b.picker = p2c{cost: (*server).load}
+	case StrategyPeakEWMA:
+		b.picker = p2c{cost: (*server).cost}
+		b.latency = true
+	case StrategyConsistentHash:
+		p, err := newConsistentHash(config.ConsistentHash)
+		if err != nil {
+			return nil, err
+		}
+		b.picker = p
+	default:
+		return nil, fmt.Errorf("unsupported load balancing strategy %q", config.Strategy)
+	}
+
+	if config.Locality != nil {
+		p, err := newLocality(b.picker, instance, config.Locality)
+		if err != nil {
+			return nil, err
+		}
+		b.picker = p
+		b.zone = instance.Zone
+		b.zoneRequests = zoneRequests
+	}
+	return b, nil
+}
+
+func (b *Balancer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	b.mutex.RLock()
+	servers, active := b.servers, b.active
+	b.mutex.RUnlock()
+
+	var srv *server
+	if b.sticky != nil {
+		srv = b.stuck(req, servers)
+	}
+	if srv == nil && len(active) > 0 {
+		srv = b.picker.pick(req, active)
+		if b.sticky != nil {
+			b.sticky.StickBackend(srv.url, &rw)
+		}
+	}
+	if srv == nil {
+		http.Error(rw, errNoAvailableServer.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	if b.zoneRequests != nil {
+		b.zoneRequests.With("zone", srv.zone, "cross_zone", strconv.FormatBool(srv.zone != b.zone)).Add(1)
+	}
+
+	// make shallow copy of request before changing anything to avoid side effects
+	newReq := *req
+	newReq.URL = utils.CopyURL(srv.url)
+
+	atomic.AddInt64(&srv.outstanding, 1)
+	defer atomic.AddInt64(&srv.outstanding, -1)
+
+	start := time.Now()
+	b.next.ServeHTTP(rw, &newReq)
+	if b.latency {
+		now := time.Now()
+		srv.observe(now.Sub(start), now)
+	}
+}
+
+// stuck returns the server of the sticky cookie of req.
+func (b *Balancer) stuck(req *http.Request, servers []*server) *server {
+	urls := make([]*url.URL, len(servers))
+	for i, s := range servers {
+		urls[i] = s.url
+	}
+	u, present, err := b.sticky.GetBackend(req, urls)
+	if err != nil || !present {
+		return nil
+	}
+	if i := indexOf(servers, u); i >= 0 && servers[i].weight > 0 {
+		return servers[i]
+	}
+	return nil
+}
+
+// Servers returns the URLs of the servers.
+func (b *Balancer) Servers() []*url.URL {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	urls := make([]*url.URL, len(b.servers))
+	for i, s := range b.servers {
+		urls[i] = utils.CopyURL(s.url)
+	}
+	return urls
+}
+
+// ServerWeight returns the weight of the server of u.
+func (b *Balancer) ServerWeight(u *url.URL) (int, bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if i := indexOf(b.servers, u); i >= 0 {
+		return b.servers[i].weight, true
+	}
+	return -1, false
+}
+
+// RemoveServer removes the server of u.
+func (b *Balancer) RemoveServer(u *url.URL) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	i := indexOf(b.servers, u)
+	if i < 0 {
+		return errors.New("server not found")
+	}
+	servers := make([]*server, 0, len(b.servers)-1)
+	servers = append(servers, b.servers[:i]...)
+	b.setServers(append(servers, b.servers[i+1:]...))
+	return nil
+}
+
+// UpsertServer adds the server of u, or updates its weight when it exists.
+// The server options are the ones of the round robin balancer of oxy.
+func (b *Balancer) UpsertServer(u *url.URL, options ...roundrobin.ServerOption) error {
+	weight := 1
+	if len(options) > 0 {
+		var err error
+		if weight, err = serverWeight(u, options...); err != nil {
+			return err
+		}
+	}
+	return b.UpsertWeightedServer(u, weight)
+}
+
+// UpsertWeightedServer adds or updates the server of u with weight, a server with a weight of 0 is drained.
+func (b *Balancer) UpsertWeightedServer(u *url.URL, weight int) error {
+	if u == nil {
+		return errors.New("server URL can't be nil")
+	}
+	if weight < 0 {
+		return fmt.Errorf("invalid weight %d of server %s", weight, u)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	servers := make([]*server, 0, len(b.servers)+1)
+	servers = append(servers, b.servers...)
+	if i := indexOf(servers, u); i >= 0 {
+		// the load and latency of the server are kept
+		servers[i] = &server{url: servers[i].url, weight: weight, zone: servers[i].zone, stats: servers[i].stats}
+	} else {
+		servers = append(servers, &server{
+			url:    utils.CopyURL(u),
+			weight: weight,
+			zone:   b.zones[serverKey(u)],
+			stats:  &stats{latency: float64(initialLatency), stamp: time.Now()},
+		})
+	}
+	b.setServers(servers)
+	return nil
+}
+
+// setServers replaces the servers, the slices are never modified once set, so that they are read without lock.
+func (b *Balancer) setServers(servers []*server) {
+	active := make([]*server, 0, len(servers))
+	for _, s := range servers {
+		if s.weight > 0 {
+			active = append(active, s)
+		}
+	}
+	b.servers, b.active = servers, active
+	if u, ok := b.picker.(updater); ok {
+		u.update(active)
+	}
+}
+
+// serverWeight returns the weight set by options, which can only be applied on the servers of oxy.
+// The weight is 1 when not set, and can be 0.
+// It is only used for the callers of UpsertServer with options, the weights are otherwise given to UpsertWeightedServer.
+func serverWeight(u *url.URL, options ...roundrobin.ServerOption) (int, error) {
+	rr, err := roundrobin.New(nil)
+	if err != nil {
+		return 0, err
+	}
+	// the options are applied on an existing server, so that a weight of 0 is not replaced by the default one.
+	if err = rr.UpsertServer(u); err != nil {
+		return 0, err
+	}
+	if err = rr.UpsertServer(u, options...); err != nil {
+		return 0, err
+	}
+	weight, _ := rr.ServerWeight(u)
+	return weight, nil
+}
+
+// serverKey returns the key of the server of u, which matches the servers as indexOf does.
+func serverKey(u *url.URL) string {
+	return u.Scheme + "://" + u.Host + u.Path
+}
+
+func indexOf(servers []*server, u *url.URL) int {
+	for i, s := range servers {
+		if s.url.Path == u.Path && s.url.Host == u.Host && s.url.Scheme == u.Scheme {
+			return i
+		}
+	}
+	return -1
+}
+
+// roundRobin picks the servers in turn with the smooth weighted round robin of oxy,
+// which interleaves the servers instead of picking them as many times in a row as their weight.
+type roundRobin struct {
+	mu sync.Mutex // guards the current weights of the servers
+}
+
+func (p *roundRobin) pick(_ *http.Request, servers []*server) *server {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	totalWeight := 0
+	var best *server
+	for _, s := range servers {
+		s.currentWeight += s.weight
+		totalWeight += s.weight
+		if best == nil || s.currentWeight > best.currentWeight {
+			best = s
+		}
+	}
+	best.currentWeight -= totalWeight
+	return best
+}
+
+// leastRequest picks the server which has the least requests in flight relative to its weight,
+// the ties are broken in round robin.
+type leastRequest struct {
+	cursor uint32 // accessed atomically
+}
+
+func (p *leastRequest) pick(_ *http.Request, servers []*server) *server {
+	start := int(atomic.AddUint32(&p.cursor, 1) % uint32(len(servers)))
+
+	var best *server
+	var bestLoad float64
+	for i := range servers {
+		s := servers[(start+i)%len(servers)]
+		if load := s.load(); best == nil || load < bestLoad {
+			best, bestLoad = s, load
+		}
+	}
+	return best
+}
+
+// p2c picks the server of the least cost between two servers picked at random,
+// https://www.eecs.harvard.edu/~michaelm/postscripts/mythesis.pdf.
+type p2c struct {
+	cost func(*server) float64
+}
+
+func (p p2c) pick(_ *http.Request, servers []*server) *server {
+	if len(servers) == 1 {
+		return servers[0]
+	}
+	i := rand.Intn(len(servers))
+	j := rand.Intn(len(servers) - 1)
+	if j >= i {
+		j++
+	}
+	if p.cost(servers[j]) < p.cost(servers[i]) {
+		return servers[j]
+	}
+	return servers[i]
+}
@@ -0,0 +1,211 @@
+package slb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/vulcand/oxy/roundrobin"
+)
+
+// forwarder records the hosts of the forwarded requests, and blocks the requests of the blocked hosts.
+type forwarder struct {
+	mu      sync.Mutex
+	hosts   map[string]int
+	blocked map[string]chan struct{}
+}
+
+func newForwarder() *forwarder {
+	return &forwarder{hosts: map[string]int{}, blocked: map[string]chan struct{}{}}
+}
+
+func (f *forwarder) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	f.hosts[req.URL.Host]++
+	block := f.blocked[req.URL.Host]
+	f.mu.Unlock()
+
+	if block != nil {
+		<-block
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (f *forwarder) count(host string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hosts[host]
+}
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	t.Helper()
+
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	return u
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(http.NotFoundHandler(), &dynamic.ServersLoadBalancer{Strategy: StrategyRoundRobin}, nil, nil, nil)
+	assert.Error(t, err)
+	_, err = New(http.NotFoundHandler(), &dynamic.ServersLoadBalancer{Strategy: "Random"}, nil, nil, nil)
+	assert.Error(t, err)
+
+	for _, strategy := range []string{StrategyLeastRequest, StrategyP2C, StrategyPeakEWMA, StrategyConsistentHash} {
+		b, err := New(http.NotFoundHandler(), &dynamic.ServersLoadBalancer{Strategy: strategy}, nil, nil, nil)
+		require.NoError(t, err)
+		assert.NotNil(t, b.picker)
+	}
+}
+
+func TestBalancerServers(t *testing.T) {
+	b, err := New(newForwarder(), &dynamic.ServersLoadBalancer{Strategy: StrategyLeastRequest}, nil, nil, nil)
+	require.NoError(t, err)
+
+	rw := httptest.NewRecorder()
+	b.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code)
+
+	assert.Error(t, b.UpsertServer(nil))
+	assert.Error(t, b.UpsertServer(mustParse(t, "http://a"), roundrobin.Weight(-1)))
+	require.NoError(t, b.UpsertServer(mustParse(t, "http://a")))
+	require.NoError(t, b.UpsertServer(mustParse(t, "http://b"), roundrobin.Weight(3)))
+	require.NoError(t, b.UpsertServer(mustParse(t, "http://a"), roundrobin.Weight(2)))
+	assert.Equal(t, []*url.URL{mustParse(t, "http://a"), mustParse(t, "http://b")}, b.Servers())
+
+	weight, ok := b.ServerWeight(mustParse(t, "http://a"))
+	assert.True(t, ok)
+	assert.Equal(t, 2, weight)
+
+	assert.Error(t, b.RemoveServer(mustParse(t, "http://c")))
+	require.NoError(t, b.RemoveServer(mustParse(t, "http://a")))
+	assert.Equal(t, []*url.URL{mustParse(t, "http://b")}, b.Servers())
+	_, ok = b.ServerWeight(mustParse(t, "http://a"))
+	assert.False(t, ok)
+
+	assert.Error(t, b.UpsertWeightedServer(mustParse(t, "http://b"), -1))
+	require.NoError(t, b.UpsertWeightedServer(mustParse(t, "http://b"), 5))
+	weight, ok = b.ServerWeight(mustParse(t, "http://b"))
+	assert.True(t, ok)
+	assert.Equal(t, 5, weight)
+
+	// a server of weight 0 is drained
+	require.NoError(t, b.UpsertServer(mustParse(t, "http://b"), roundrobin.Weight(0)))
+	rw = httptest.NewRecorder()
+	b.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code)
+}
+
+func TestRoundRobin(t *testing.T) {
+	servers := []*server{
+		{url: mustParse(t, "http://a"), weight: 5, stats: &stats{}},
+		{url: mustParse(t, "http://b"), weight: 1, stats: &stats{}},
+		{url: mustParse(t, "http://c"), weight: 1, stats: &stats{}},
+	}
+
+	p := &roundRobin{}
+	var hosts []string
+	for i := 0; i < 14; i++ {
+		hosts = append(hosts, p.pick(nil, servers).url.Host)
+	}
+
+	// the servers are interleaved, rather than picked as many times in a row as their weight.
+	assert.Equal(t, []string{"a", "a", "b", "a", "c", "a", "a", "a", "a", "b", "a", "c", "a", "a"}, hosts)
+}
+
+func TestBalancerStrategies(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		strategy string
+	}{
+		{desc: "least request", strategy: StrategyLeastRequest},
+		{desc: "p2c", strategy: StrategyP2C},
+		{desc: "peak EWMA", strategy: StrategyPeakEWMA},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			fwd := newForwarder()
+			block := make(chan struct{})
+			fwd.blocked["slow"] = block
+
+			b, err := New(fwd, &dynamic.ServersLoadBalancer{Strategy: test.strategy}, nil, nil, nil)
+			require.NoError(t, err)
+			require.NoError(t, b.UpsertServer(mustParse(t, "http://slow")))
+			require.NoError(t, b.UpsertServer(mustParse(t, "http://fast")))
+
+			// the requests of the slow server are in flight until it is unblocked.
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					b.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+				}()
+				time.Sleep(time.Millisecond)
+			}
+
+			assert.Eventually(t, func() bool {
+				return fwd.count("slow")+fwd.count("fast") == 20
+			}, time.Second, time.Millisecond)
+			assert.LessOrEqual(t, fwd.count("slow"), 2)
+
+			close(block)
+			wg.Wait()
+		})
+	}
+}
+
+func TestBalancerSticky(t *testing.T) {
+	fwd := newForwarder()
+	b, err := New(fwd, &dynamic.ServersLoadBalancer{Strategy: StrategyP2C}, roundrobin.NewStickySession("sticky"), nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, b.UpsertServer(mustParse(t, "http://a")))
+	require.NoError(t, b.UpsertServer(mustParse(t, "http://b")))
+
+	rw := httptest.NewRecorder()
+	b.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := rw.Result().Cookies()
+	require.Len(t, cookies, 1)
+
+	host := "a"
+	if fwd.count("b") == 1 {
+		host = "b"
+	}
+	for i := 0; i < 10; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(cookies[0])
+		b.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Equal(t, 11, fwd.count(host))
+
+	// the sticky server is not used once it is drained.
+	require.NoError(t, b.UpsertServer(mustParse(t, "http://"+host), roundrobin.Weight(0)))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookies[0])
+	b.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, 11, fwd.count(host))
+}
+
+func TestPeakEWMA(t *testing.T) {
+	now := time.Now()
+	s := &stats{latency: float64(initialLatency), stamp: now}
+
+	s.observe(10*time.Millisecond, now.Add(time.Minute))
+	assert.InDelta(t, float64(10*time.Millisecond), s.latency, float64(time.Millisecond))
+
+	// peaks are recorded at once, and decay with time.
+	s.observe(time.Second, now.Add(time.Minute))
+	assert.Equal(t, float64(time.Second), s.latency)
+	s.observe(10*time.Millisecond, now.Add(time.Minute+decayTime))
+	assert.InDelta(t, float64(time.Second)/2.718+float64(10*time.Millisecond)*(1-1/2.718), s.latency, float64(time.Millisecond))
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/traefik/traefik/v2/pkg/config/static"
 	"github.com/traefik/traefik/v2/pkg/metrics"
 	"github.com/traefik/traefik/v2/pkg/safe"
+	"github.com/traefik/traefik/v2/pkg/types"
 )
 
 // ManagerFactory a factory of service manager.
@@ -24,6 +25,7 @@ type ManagerFactory struct {
 	acmeHTTPHandler  http.Handler
 
 	routinesPool *safe.Pool
+	locality     *types.Locality
 }
 
 // NewManagerFactory creates a new ManagerFactory.
@@ -33,6 +35,7 @@ func NewManagerFactory(staticConfiguration static.Configuration, routinesPool *s
 		routinesPool:        routinesPool,
 		roundTripperManager: roundTripperManager,
 		acmeHTTPHandler:     acmeHTTPHandler,
+		locality:            staticConfiguration.Locality,
 	}
 
 	if staticConfiguration.API != nil {
@@ -64,6 +67,7 @@ func NewManagerFactory(staticConfiguration static.Configuration, routinesPool *s
 // Build creates a service manager.
 func (f *ManagerFactory) Build(configuration *runtime.Configuration) *InternalHandlers {
 	svcManager := NewManager(configuration.Services, f.metricsRegistry, f.routinesPool, f.roundTripperManager)
+	svcManager.locality = f.locality
 
 	var apiHandler http.Handler
 	if f.api != nil {
@@ -8,9 +8,11 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"reflect"
+	"sort"
 	"time"
 
 	"github.com/containous/alice"
+	gokitmetrics "github.com/go-kit/kit/metrics"
 	"github.com/traefik/traefik/v2/pkg/config/dynamic"
 	"github.com/traefik/traefik/v2/pkg/config/runtime"
 	"github.com/traefik/traefik/v2/pkg/healthcheck"
@@ -25,7 +27,9 @@ import (
 	"github.com/traefik/traefik/v2/pkg/server/provider"
 	"github.com/traefik/traefik/v2/pkg/server/service/loadbalancer/lrr"
 	"github.com/traefik/traefik/v2/pkg/server/service/loadbalancer/mirror"
+	"github.com/traefik/traefik/v2/pkg/server/service/loadbalancer/slb"
 	"github.com/traefik/traefik/v2/pkg/server/service/loadbalancer/wrr"
+	"github.com/traefik/traefik/v2/pkg/types"
 	"github.com/vulcand/oxy/roundrobin"
 	"github.com/vulcand/oxy/roundrobin/stickycookie"
 )
@@ -37,6 +41,9 @@ const (
 
 const defaultMaxBodySize int64 = -1
 
+// serverDrained is the status of the servers of weight 0.
+const serverDrained = "DRAINED"
+
 // RoundTripperGetter is a roundtripper getter interface.
 type RoundTripperGetter interface {
 	Get(name string) (http.RoundTripper, error)
@@ -50,6 +57,8 @@ func NewManager(configs map[string]*runtime.ServiceInfo, metricsRegistry metrics
 		bufferPool:          newBufferPool(),
 		roundTripperManager: roundTripperManager,
 		balancers:           make(map[string]healthcheck.Balancers),
+		analyses:            make(map[string]*lrr.Analysis),
+		outlierDetections:   make(map[string]*healthcheck.OutlierDetection),
 		configs:             configs,
 	}
 }
@@ -65,7 +74,15 @@ type Manager struct {
 	// (e.g. if 2 routers refer to the same service name, 2 service handlers are created),
 	// which is why there is not just one Balancer per service name.
 	balancers map[string]healthcheck.Balancers
-	configs   map[string]*runtime.ServiceInfo
+	// analyses is the map of the analyses of the labeled services, keyed by service name.
+	// An analysis is shared by all the Balancers of a service name.
+	analyses map[string]*lrr.Analysis
+	// outlierDetections is the map of the outlier detections of the servers load-balancers, keyed by service name.
+	// An outlier detection is shared by all the Balancers of a service name.
+	outlierDetections map[string]*healthcheck.OutlierDetection
+	configs           map[string]*runtime.ServiceInfo
+	// locality is the locality of the instance, used by the locality-aware load balancers.
+	locality *types.Locality
 }
 
 // BuildHTTP Creates a http.Handler for a service configuration.
@@ -119,7 +136,7 @@ func (m *Manager) BuildHTTP(rootCtx context.Context, serviceName string) (http.H
 		}
 	case conf.Labeled != nil:
 		var err error
-		lb, err = m.getLRRServiceHandler(ctx, serviceName, conf.Labeled)
+		lb, err = m.getLRRServiceHandler(ctx, serviceName, conf)
 		if err != nil {
 			conf.AddError(err, true)
 			return nil, err
@@ -194,8 +211,8 @@ func (m *Manager) getWRRServiceHandler(ctx context.Context, serviceName string,
 	return balancer, nil
 }
 
-func (m *Manager) getLRRServiceHandler(ctx context.Context, serviceName string, config *dynamic.LabeledRoundRobin) (http.Handler, error) {
-
+func (m *Manager) getLRRServiceHandler(ctx context.Context, serviceName string, info *runtime.ServiceInfo) (http.Handler, error) {
+	config := info.Labeled
 	if config.Default == "" {
 		err := errors.New("cannot create labeled service: default service required")
 		return nil, err
@@ -205,20 +222,129 @@ func (m *Manager) getLRRServiceHandler(ctx context.Context, serviceName string,
 		return nil, err
 	}
 
-	balancer := lrr.New(config.ServiceName, defaultHandler)
-	logger := log.FromContext(ctx)
+	for name, weight := range config.Weights {
+		if weight < 0 {
+			return nil, fmt.Errorf("cannot create labeled service: invalid weight %d of %s", weight, name)
+		}
+	}
+
+	balancer := lrr.New(config.ServiceName, defaultHandler, config.HealthCheck)
+	if weight, ok := config.Weights[config.Default]; ok {
+		balancer.SetDefaultWeight(weight)
+	}
+	if config.HealthCheck != nil {
+		if err := registerStatusUpdater(ctx, serviceName, config.Default, defaultHandler, func(up bool) {
+			balancer.SetDefaultStatus(ctx, up)
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	// the explicit labels go first, then the labels inferred from the names of the other services.
+	children := make([]lrrChild, 0, len(config.Labels)+len(config.Services))
+	mapped := make(map[string]bool, len(config.Labels))
+	labels := make([]string, 0, len(config.Labels))
+	for label := range config.Labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		fullServiceName := config.Labels[label]
+		switch {
+		case !lrr.ValidLabel(label):
+			info.AddError(fmt.Errorf("labeled service: invalid label %q of %s", label, fullServiceName), false)
+		case fullServiceName == "":
+			info.AddError(fmt.Errorf("labeled service: service of label %q required", label), false)
+		default:
+			children = append(children, lrrChild{label: label, name: fullServiceName})
+			mapped[fullServiceName] = true
+		}
+	}
 	for _, fullServiceName := range config.Services {
+		if mapped[fullServiceName] {
+			continue
+		}
+		label, ok := lrr.InferLabel(fullServiceName, config.ServiceName)
+		if !ok {
+			info.AddError(fmt.Errorf("labeled service: cannot infer the label of %s, it should be named as {%s}-{label}", fullServiceName, config.ServiceName), false)
+		}
+		children = append(children, lrrChild{label: label, name: fullServiceName, inferred: true})
+	}
+
+	logger := log.FromContext(ctx)
+	for _, child := range children {
+		fullServiceName := child.name
 		serviceHandler, err := m.BuildHTTP(ctx, fullServiceName)
 		if err != nil {
 			logger.Errorf("getLRRServiceHandler %s failed: %s,", fullServiceName, err.Error())
+			info.AddError(fmt.Errorf("labeled service %s: %w", fullServiceName, err), false)
 			continue // should fallback to defaultHandler
 		}
 
-		balancer.AddService(fullServiceName, serviceHandler)
+		var weight *int
+		if w, ok := config.Weights[fullServiceName]; ok {
+			weight = &w
+		}
+		if child.inferred {
+			balancer.AddService(fullServiceName, serviceHandler, weight)
+		} else {
+			balancer.AddLabeledService(child.label, fullServiceName, serviceHandler, weight)
+		}
+		if config.HealthCheck == nil {
+			continue
+		}
+
+		childName := fullServiceName
+		if err := registerStatusUpdater(ctx, serviceName, childName, serviceHandler, func(up bool) {
+			balancer.SetStatus(ctx, childName, up)
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Analysis != nil {
+		if err := m.attachAnalysis(serviceName, *config.Analysis, balancer); err != nil {
+			info.AddError(fmt.Errorf("labeled service analysis: %w", err), false)
+		}
 	}
 	return balancer, nil
 }
 
+// attachAnalysis attaches the analysis of serviceName to balancer, the analysis is created by the first Balancer of serviceName.
+func (m *Manager) attachAnalysis(serviceName string, config dynamic.LabeledAnalysis, balancer *lrr.Balancer) error {
+	analysis, ok := m.analyses[serviceName]
+	if !ok {
+		var err error
+		if analysis, err = lrr.NewAnalysis(serviceName, config); err != nil {
+			return err
+		}
+		m.analyses[serviceName] = analysis
+	}
+	return analysis.Attach(balancer)
+}
+
+// lrrChild is a labeled service of a labeled load-balancer.
+type lrrChild struct {
+	label    string
+	name     string
+	inferred bool
+}
+
+// registerStatusUpdater registers fn to be run when the status of the child service changes.
+func registerStatusUpdater(ctx context.Context, serviceName, childName string, handler http.Handler, fn func(up bool)) error {
+	updater, ok := handler.(healthcheck.StatusUpdater)
+	if !ok {
+		return fmt.Errorf("child service %v of %v not a healthcheck.StatusUpdater (%T)", childName, serviceName, handler)
+	}
+
+	if err := updater.RegisterStatusUpdater(fn); err != nil {
+		return fmt.Errorf("cannot register %v as updater for %v: %w", childName, serviceName, err)
+	}
+
+	log.FromContext(ctx).Debugf("Child service %v will update parent %v on status change", childName, serviceName)
+	return nil
+}
+
 func (m *Manager) getLoadBalancerServiceHandler(ctx context.Context, serviceName string, service *dynamic.ServersLoadBalancer) (http.Handler, error) {
 	if service.PassHostHeader == nil {
 		defaultPassHostHeader := true
@@ -234,6 +360,12 @@ func (m *Manager) getLoadBalancerServiceHandler(ctx context.Context, serviceName
 		return nil, err
 	}
 
+	var outlierDetection *healthcheck.OutlierDetection
+	if service.OutlierDetection != nil {
+		outlierDetection = m.getOutlierDetection(serviceName, *service.OutlierDetection)
+		roundTripper = outlierDetection.RoundTripper(roundTripper)
+	}
+
 	fwd, err := buildProxy(service.PassHostHeader, service.ResponseForwarding, roundTripper, m.bufferPool)
 	if err != nil {
 		return nil, err
@@ -259,12 +391,15 @@ func (m *Manager) getLoadBalancerServiceHandler(ctx context.Context, serviceName
 
 	// TODO rename and checks
 	m.balancers[serviceName] = append(m.balancers[serviceName], balancer)
+	if outlierDetection != nil {
+		outlierDetection.SetBalancer(m.balancers[serviceName])
+	}
 
 	// Empty (backend with no servers)
 	return emptybackendhandler.New(balancer), nil
 }
 
-// LaunchHealthCheck launches the health checks.
+// LaunchHealthCheck launches the health checks, the outlier detections, and the analyses of the labeled services.
 func (m *Manager) LaunchHealthCheck() {
 	backendConfigs := make(map[string]*healthcheck.BackendConfig)
 
@@ -282,9 +417,29 @@ func (m *Manager) LaunchHealthCheck() {
 		log.FromContext(ctx).Debugf("Setting up healthcheck for service %s with %s", serviceName, *hcOpts)
 
 		backendConfigs[serviceName] = healthcheck.NewBackendConfig(*hcOpts, serviceName)
+		if outlierDetection, ok := m.outlierDetections[serviceName]; ok {
+			outlierDetection.SetBackendConfig(backendConfigs[serviceName])
+		}
 	}
 
 	healthcheck.GetHealthCheck(m.metricsRegistry).SetBackendsConfiguration(context.Background(), backendConfigs)
+	healthcheck.LaunchOutlierDetections(context.Background(), m.outlierDetections)
+	lrr.LaunchAnalyses(context.Background(), m.analyses)
+}
+
+// getOutlierDetection returns the outlier detection of serviceName, it is created by the first Balancer of serviceName.
+func (m *Manager) getOutlierDetection(serviceName string, config dynamic.OutlierDetection) *healthcheck.OutlierDetection {
+	if outlierDetection, ok := m.outlierDetections[serviceName]; ok {
+		return outlierDetection
+	}
+
+	registry := m.metricsRegistry
+	if registry == nil {
+		registry = metrics.NewVoidRegistry()
+	}
+	outlierDetection := healthcheck.NewOutlierDetection(serviceName, config, m.configs[serviceName], registry.ServiceServerUpGauge())
+	m.outlierDetections[serviceName] = outlierDetection
+	return outlierDetection
 }
 
 func buildHealthCheckOptions(ctx context.Context, lb healthcheck.Balancer, backend string, hc *dynamic.ServerHealthCheck) *healthcheck.Options {
@@ -346,7 +501,7 @@ func (m *Manager) getLoadBalancer(ctx context.Context, serviceName string, servi
 	logger := log.FromContext(ctx)
 	logger.Debug("Creating load-balancer")
 
-	var options []roundrobin.LBOption
+	var sticky *roundrobin.StickySession
 
 	var cookieName string
 	if service.Sticky != nil && service.Sticky.Cookie != nil {
@@ -364,25 +519,50 @@ func (m *Manager) getLoadBalancer(ctx context.Context, serviceName string, servi
 			return nil, err
 		}
 
-		options = append(options, roundrobin.EnableStickySession(roundrobin.NewStickySessionWithOptions(cookieName, opts).SetCookieValue(cv)))
+		sticky = roundrobin.NewStickySessionWithOptions(cookieName, opts).SetCookieValue(cv)
 
 		logger.Debugf("Sticky session cookie name: %v", cookieName)
 	}
 
-	lb, err := roundrobin.New(fwd, options...)
-	if err != nil {
-		return nil, err
+	var lb healthcheck.BalancerHandler
+	switch {
+	case (service.Strategy == "" || service.Strategy == slb.StrategyRoundRobin) && service.Locality == nil:
+		var options []roundrobin.LBOption
+		if sticky != nil {
+			options = append(options, roundrobin.EnableStickySession(sticky))
+		}
+
+		rr, err := roundrobin.New(fwd, options...)
+		if err != nil {
+			return nil, err
+		}
+		lb = rr
+	default:
+		logger.Debugf("Load balancing strategy: %s", service.Strategy)
+
+		var zoneRequests gokitmetrics.Counter
+		if service.Locality != nil && m.metricsRegistry != nil && m.metricsRegistry.IsSvcEnabled() {
+			zoneRequests = m.metricsRegistry.ServiceZoneReqsCounter().With("service", serviceName)
+		}
+
+		nlb, err := slb.New(fwd, service, sticky, m.locality, zoneRequests)
+		if err != nil {
+			return nil, err
+		}
+		lb = nlb
 	}
 
 	lbsu := healthcheck.NewLBStatusUpdater(lb, m.configs[serviceName], service.HealthCheck)
-	if err := m.upsertServers(ctx, lbsu, service.Servers); err != nil {
+	if err := m.upsertServers(ctx, lbsu, m.configs[serviceName], service.Servers); err != nil {
 		return nil, fmt.Errorf("error configuring load balancer for service %s: %w", serviceName, err)
 	}
 
 	return lbsu, nil
 }
 
-func (m *Manager) upsertServers(ctx context.Context, lb healthcheck.BalancerHandler, servers []dynamic.Server) error {
+// upsertServers adds the servers to lb with their weight, the servers of weight 0 are drained: they are not added.
+// info can be nil.
+func (m *Manager) upsertServers(ctx context.Context, lb healthcheck.BalancerHandler, info *runtime.ServiceInfo, servers []dynamic.Server) error {
 	logger := log.FromContext(ctx)
 
 	for name, srv := range servers {
@@ -391,9 +571,24 @@ func (m *Manager) upsertServers(ctx context.Context, lb healthcheck.BalancerHand
 			return fmt.Errorf("error parsing server URL %s: %w", srv.URL, err)
 		}
 
+		weight := 1
+		if srv.Weight != nil {
+			weight = *srv.Weight
+		}
+		if weight < 0 {
+			return fmt.Errorf("invalid weight %d of server %s", weight, srv.URL)
+		}
+		if weight == 0 {
+			logger.WithField(log.ServerName, name).Debugf("Draining server %d %s", name, u)
+			if info != nil {
+				info.UpdateServerStatus(u.String(), serverDrained)
+			}
+			continue
+		}
+
 		logger.WithField(log.ServerName, name).Debugf("Creating server %d %s", name, u)
 
-		if err := lb.UpsertServer(u, roundrobin.Weight(1)); err != nil {
+		if err := healthcheck.UpsertWeightedServer(lb, u, weight); err != nil {
 			return fmt.Errorf("error adding server %s to load balancer: %w", srv.URL, err)
 		}
 
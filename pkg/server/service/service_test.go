@@ -60,6 +60,45 @@ func TestGetLoadBalancer(t *testing.T) {
 			fwd:         &MockForwarder{},
 			expectError: false,
 		},
+		{
+			desc:        "Succeeds when strategy is set",
+			serviceName: "test",
+			service: &dynamic.ServersLoadBalancer{
+				Strategy: "PeakEWMA",
+				Sticky:   &dynamic.Sticky{Cookie: &dynamic.Cookie{}},
+				Servers:  []dynamic.Server{{URL: "http://foo"}},
+			},
+			fwd:         &MockForwarder{},
+			expectError: false,
+		},
+		{
+			desc:        "Fails when a server weight is negative",
+			serviceName: "test",
+			service: &dynamic.ServersLoadBalancer{
+				Servers: []dynamic.Server{{URL: "http://foo", Weight: func(i int) *int { return &i }(-1)}},
+			},
+			fwd:         &MockForwarder{},
+			expectError: true,
+		},
+		{
+			desc:        "Fails when locality is set without the locality of the instance",
+			serviceName: "test",
+			service: &dynamic.ServersLoadBalancer{
+				Locality: &dynamic.Locality{},
+				Servers:  []dynamic.Server{{URL: "http://foo", Zone: "zone-a"}},
+			},
+			fwd:         &MockForwarder{},
+			expectError: true,
+		},
+		{
+			desc:        "Fails when strategy is not supported",
+			serviceName: "test",
+			service: &dynamic.ServersLoadBalancer{
+				Strategy: "Random",
+			},
+			fwd:         &MockForwarder{},
+			expectError: true,
+		},
 	}
 
 	for _, test := range testCases {
@@ -148,6 +187,60 @@ func TestGetLoadBalancerServiceHandler(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc:        "Load balances between the two servers by their weight",
+			serviceName: "test",
+			service: &dynamic.ServersLoadBalancer{
+				Servers: []dynamic.Server{
+					{
+						URL:    server1.URL,
+						Weight: func(i int) *int { return &i }(2),
+					},
+					{
+						URL: server2.URL,
+					},
+				},
+			},
+			expected: []ExpectedResult{
+				{
+					StatusCode: http.StatusOK,
+					XFrom:      "first",
+				},
+				{
+					StatusCode: http.StatusOK,
+					XFrom:      "first",
+				},
+				{
+					StatusCode: http.StatusOK,
+					XFrom:      "second",
+				},
+			},
+		},
+		{
+			desc:        "Never calls a drained server",
+			serviceName: "test",
+			service: &dynamic.ServersLoadBalancer{
+				Servers: []dynamic.Server{
+					{
+						URL:    server1.URL,
+						Weight: func(i int) *int { return &i }(0),
+					},
+					{
+						URL: server2.URL,
+					},
+				},
+			},
+			expected: []ExpectedResult{
+				{
+					StatusCode: http.StatusOK,
+					XFrom:      "second",
+				},
+				{
+					StatusCode: http.StatusOK,
+					XFrom:      "second",
+				},
+			},
+		},
 		{
 			desc:        "StatusBadGateway when the server is not reachable",
 			serviceName: "test",
@@ -406,3 +499,49 @@ func TestMultipleTypeOnBuildHTTP(t *testing.T) {
 	_, err := manager.BuildHTTP(context.Background(), "test@file")
 	assert.Error(t, err, "cannot create service: multi-types service not supported, consider declaring two different pieces of service instead")
 }
+
+func TestOutlierDetectionOnBuildHTTP(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(bad.Close)
+	good := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(good.Close)
+
+	services := map[string]*runtime.ServiceInfo{
+		"test@file": {
+			Service: &dynamic.Service{
+				LoadBalancer: &dynamic.ServersLoadBalancer{
+					OutlierDetection: &dynamic.OutlierDetection{ConsecutiveGatewayErrors: 2},
+					Servers:          []dynamic.Server{{URL: bad.URL}, {URL: good.URL}},
+				},
+			},
+		},
+	}
+
+	manager := NewManager(services, nil, nil, &RoundTripperManager{
+		roundTrippers: map[string]http.RoundTripper{
+			"default@internal": http.DefaultTransport,
+		},
+	})
+
+	// the outlier detection is shared by the handlers of the service.
+	first, err := manager.BuildHTTP(context.Background(), "test@file")
+	require.NoError(t, err)
+	second, err := manager.BuildHTTP(context.Background(), "test@file")
+	require.NoError(t, err)
+	assert.Len(t, manager.outlierDetections, 1)
+
+	for _, handler := range []http.Handler{first, second, first, second} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://foo.com/", nil))
+	}
+	assert.Equal(t, "EJECTED", services["test@file"].GetAllStatus()[bad.URL])
+
+	for _, handler := range []http.Handler{first, second} {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://foo.com/", nil))
+		assert.Equal(t, http.StatusOK, rw.Code)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"reflect"
 	"time"
 
 	"github.com/traefik/traefik/v2/pkg/config/runtime"
@@ -36,7 +37,14 @@ func (m *Manager) BuildTCP(rootCtx context.Context, serviceName string) (tcp.Han
 		return nil, fmt.Errorf("the service %q does not exist", serviceQualifiedName)
 	}
 
-	if conf.LoadBalancer != nil && conf.Weighted != nil {
+	value := reflect.ValueOf(*conf.TCPService)
+	var count int
+	for i := 0; i < value.NumField(); i++ {
+		if !value.Field(i).IsNil() {
+			count++
+		}
+	}
+	if count > 1 {
 		err := errors.New("cannot create service: multi-types service not supported, consider declaring two different pieces of service instead")
 		conf.AddError(err, true)
 		return nil, err
@@ -80,6 +88,26 @@ func (m *Manager) BuildTCP(rootCtx context.Context, serviceName string) (tcp.Han
 			loadBalancer.AddWeightServer(handler, service.Weight)
 		}
 		return loadBalancer, nil
+	case conf.Labeled != nil:
+		defaultHandler, err := m.BuildTCP(rootCtx, conf.Labeled.Default)
+		if err != nil {
+			logger.Errorf("In service %q: %v", serviceQualifiedName, err)
+			return nil, err
+		}
+		loadBalancer, err := tcp.NewLRRLoadBalancer(conf.Labeled, defaultHandler)
+		if err != nil {
+			conf.AddError(err, true)
+			return nil, err
+		}
+		for label, service := range conf.Labeled.Labels {
+			handler, err := m.BuildTCP(rootCtx, service)
+			if err != nil {
+				logger.Errorf("In service %q: %v", serviceQualifiedName, err)
+				return nil, err
+			}
+			loadBalancer.AddLabeledServer(label, handler)
+		}
+		return loadBalancer, nil
 	default:
 		err := fmt.Errorf("the service %q does not have any type defined", serviceQualifiedName)
 		conf.AddError(err, true)
@@ -171,6 +171,72 @@ func TestManager_BuildTCP(t *testing.T) {
 			},
 			providerName: "provider-1",
 		},
+		{
+			desc:        "labeled service",
+			serviceName: "test",
+			configs: map[string]*runtime.TCPServiceInfo{
+				"test": {
+					TCPService: &dynamic.TCPService{
+						Labeled: &dynamic.TCPLabeledRoundRobin{
+							Default: "stable",
+							Labels:  map[string]string{"beta": "beta"},
+							SourceRanges: []dynamic.LabeledSourceRange{
+								{Label: "beta", SourceRange: []string{"10.0.0.0/8"}},
+							},
+						},
+					},
+				},
+				"stable": {
+					TCPService: &dynamic.TCPService{
+						LoadBalancer: &dynamic.TCPServersLoadBalancer{
+							Servers: []dynamic.TCPServer{{Address: "192.168.0.12:80"}},
+						},
+					},
+				},
+				"beta": {
+					TCPService: &dynamic.TCPService{
+						LoadBalancer: &dynamic.TCPServersLoadBalancer{
+							Servers: []dynamic.TCPServer{{Address: "192.168.0.13:80"}},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc:        "labeled service with missing service",
+			serviceName: "test",
+			configs: map[string]*runtime.TCPServiceInfo{
+				"test": {
+					TCPService: &dynamic.TCPService{
+						Labeled: &dynamic.TCPLabeledRoundRobin{
+							Default: "stable",
+							Labels:  map[string]string{"beta": "beta"},
+						},
+					},
+				},
+				"stable": {
+					TCPService: &dynamic.TCPService{
+						LoadBalancer: &dynamic.TCPServersLoadBalancer{
+							Servers: []dynamic.TCPServer{{Address: "192.168.0.12:80"}},
+						},
+					},
+				},
+			},
+			expectedError: `the service "beta" does not exist`,
+		},
+		{
+			desc:        "multi-types service",
+			serviceName: "test",
+			configs: map[string]*runtime.TCPServiceInfo{
+				"test": {
+					TCPService: &dynamic.TCPService{
+						Weighted: &dynamic.TCPWeightedRoundRobin{},
+						Labeled:  &dynamic.TCPLabeledRoundRobin{Default: "stable"},
+					},
+				},
+			},
+			expectedError: "cannot create service: multi-types service not supported, consider declaring two different pieces of service instead",
+		},
 	}
 
 	for _, test := range testCases {
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"reflect"
 
 	"github.com/traefik/traefik/v2/pkg/config/runtime"
 	"github.com/traefik/traefik/v2/pkg/log"
@@ -35,7 +36,14 @@ func (m *Manager) BuildUDP(rootCtx context.Context, serviceName string) (udp.Han
 		return nil, fmt.Errorf("the udp service %q does not exist", serviceQualifiedName)
 	}
 
-	if conf.LoadBalancer != nil && conf.Weighted != nil {
+	value := reflect.ValueOf(*conf.UDPService)
+	var count int
+	for i := 0; i < value.NumField(); i++ {
+		if !value.Field(i).IsNil() {
+			count++
+		}
+	}
+	if count > 1 {
 		err := errors.New("cannot create service: multi-types service not supported, consider declaring two different pieces of service instead")
 		conf.AddError(err, true)
 		return nil, err
@@ -73,6 +81,26 @@ func (m *Manager) BuildUDP(rootCtx context.Context, serviceName string) (udp.Han
 			loadBalancer.AddWeightedServer(handler, service.Weight)
 		}
 		return loadBalancer, nil
+	case conf.Labeled != nil:
+		defaultHandler, err := m.BuildUDP(rootCtx, conf.Labeled.Default)
+		if err != nil {
+			logger.Errorf("In udp service %q: %v", serviceQualifiedName, err)
+			return nil, err
+		}
+		loadBalancer, err := udp.NewLRRLoadBalancer(conf.Labeled, defaultHandler)
+		if err != nil {
+			conf.AddError(err, true)
+			return nil, err
+		}
+		for label, service := range conf.Labeled.Labels {
+			handler, err := m.BuildUDP(rootCtx, service)
+			if err != nil {
+				logger.Errorf("In udp service %q: %v", serviceQualifiedName, err)
+				return nil, err
+			}
+			loadBalancer.AddLabeledServer(label, handler)
+		}
+		return loadBalancer, nil
 	default:
 		err := fmt.Errorf("the udp service %q does not have any type defined", serviceQualifiedName)
 		conf.AddError(err, true)
@@ -171,6 +171,72 @@ func TestManager_BuildUDP(t *testing.T) {
 			},
 			providerName: "provider-1",
 		},
+		{
+			desc:        "labeled service",
+			serviceName: "test",
+			configs: map[string]*runtime.UDPServiceInfo{
+				"test": {
+					UDPService: &dynamic.UDPService{
+						Labeled: &dynamic.UDPLabeledRoundRobin{
+							Default: "stable",
+							Labels:  map[string]string{"beta": "beta"},
+							SourceRanges: []dynamic.LabeledSourceRange{
+								{Label: "beta", SourceRange: []string{"10.0.0.0/8"}},
+							},
+						},
+					},
+				},
+				"stable": {
+					UDPService: &dynamic.UDPService{
+						LoadBalancer: &dynamic.UDPServersLoadBalancer{
+							Servers: []dynamic.UDPServer{{Address: "192.168.0.12:80"}},
+						},
+					},
+				},
+				"beta": {
+					UDPService: &dynamic.UDPService{
+						LoadBalancer: &dynamic.UDPServersLoadBalancer{
+							Servers: []dynamic.UDPServer{{Address: "192.168.0.13:80"}},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc:        "labeled service with missing service",
+			serviceName: "test",
+			configs: map[string]*runtime.UDPServiceInfo{
+				"test": {
+					UDPService: &dynamic.UDPService{
+						Labeled: &dynamic.UDPLabeledRoundRobin{
+							Default: "stable",
+							Labels:  map[string]string{"beta": "beta"},
+						},
+					},
+				},
+				"stable": {
+					UDPService: &dynamic.UDPService{
+						LoadBalancer: &dynamic.UDPServersLoadBalancer{
+							Servers: []dynamic.UDPServer{{Address: "192.168.0.12:80"}},
+						},
+					},
+				},
+			},
+			expectedError: `the udp service "beta" does not exist`,
+		},
+		{
+			desc:        "multi-types service",
+			serviceName: "test",
+			configs: map[string]*runtime.UDPServiceInfo{
+				"test": {
+					UDPService: &dynamic.UDPService{
+						Weighted: &dynamic.UDPWeightedRoundRobin{},
+						Labeled:  &dynamic.UDPLabeledRoundRobin{Default: "stable"},
+					},
+				},
+			},
+			expectedError: "cannot create service: multi-types service not supported, consider declaring two different pieces of service instead",
+		},
 	}
 
 	for _, test := range testCases {
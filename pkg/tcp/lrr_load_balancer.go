@@ -0,0 +1,100 @@
+package tcp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/ip"
+)
+
+type labeledSourceRange struct {
+	label   string
+	checker *ip.Checker
+}
+
+// LRRLoadBalancer is a labeled load balancer for TCP services,
+// it forwards the connections to the service of their label, or to the default service.
+type LRRLoadBalancer struct {
+	defaultHandler   Handler
+	handlers         map[string]Handler
+	sniPrefix        bool
+	sourceRanges     []labeledSourceRange
+	proxyProtocolTLV proxyproto.PP2Type
+}
+
+// NewLRRLoadBalancer creates a new LRRLoadBalancer.
+func NewLRRLoadBalancer(config *dynamic.TCPLabeledRoundRobin, defaultHandler Handler) (*LRRLoadBalancer, error) {
+	b := &LRRLoadBalancer{
+		defaultHandler: defaultHandler,
+		handlers:       make(map[string]Handler),
+		sniPrefix:      config.SNIPrefix,
+	}
+
+	if config.ProxyProtocolTLV != 0 {
+		if config.ProxyProtocolTLV < 0 || config.ProxyProtocolTLV > 0xFF || !proxyproto.PP2Type(config.ProxyProtocolTLV).App() {
+			return nil, fmt.Errorf("invalid PROXY protocol TLV type %#x, should be from 0xE0 to 0xEF", config.ProxyProtocolTLV)
+		}
+		b.proxyProtocolTLV = proxyproto.PP2Type(config.ProxyProtocolTLV)
+	}
+
+	for _, sr := range config.SourceRanges {
+		checker, err := ip.NewChecker(sr.SourceRange)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse source range %s of label %q: %w", sr.SourceRange, sr.Label, err)
+		}
+		b.sourceRanges = append(b.sourceRanges, labeledSourceRange{label: sr.Label, checker: checker})
+	}
+	return b, nil
+}
+
+// AddLabeledServer adds the handler of label.
+func (b *LRRLoadBalancer) AddLabeledServer(label string, handler Handler) {
+	b.handlers[label] = handler
+}
+
+// ServeTCP forwards the connection to the service of its label.
+func (b *LRRLoadBalancer) ServeTCP(conn WriteCloser) {
+	if handler, ok := b.handlers[b.label(conn)]; ok {
+		handler.ServeTCP(conn)
+		return
+	}
+	b.defaultHandler.ServeTCP(conn)
+}
+
+// label returns the label of conn, from the PROXY protocol TLV, the SNI or the source ranges in that order,
+// the labels without a handler are skipped.
+func (b *LRRLoadBalancer) label(conn WriteCloser) string {
+	if b.proxyProtocolTLV != 0 {
+		if header := ProxyHeader(conn); header != nil {
+			tlvs, err := header.TLVs()
+			if err == nil {
+				for _, tlv := range tlvs {
+					if tlv.Type == b.proxyProtocolTLV && b.handlers[string(tlv.Value)] != nil {
+						return string(tlv.Value)
+					}
+				}
+			}
+		}
+	}
+
+	if b.sniPrefix {
+		serverName := ServerName(conn)
+		if i := strings.IndexByte(serverName, '.'); i > 0 {
+			if label := strings.ToLower(serverName[:i]); b.handlers[label] != nil {
+				return label
+			}
+		}
+	}
+
+	if len(b.sourceRanges) > 0 {
+		addr := conn.RemoteAddr().String()
+		for _, sr := range b.sourceRanges {
+			if b.handlers[sr.label] != nil && sr.checker.IsAuthorized(addr) == nil {
+				return sr.label
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package tcp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+)
+
+type labeledConn struct {
+	WriteCloser
+	remoteAddr net.Addr
+	header     *proxyproto.Header
+}
+
+func (c *labeledConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *labeledConn) ProxyHeader() *proxyproto.Header {
+	return c.header
+}
+
+func TestLRRLoadBalancer(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		config     dynamic.TCPLabeledRoundRobin
+		remoteAddr string
+		serverName string
+		tlv        string
+		expected   string
+	}{
+		{
+			desc:       "without label",
+			config:     dynamic.TCPLabeledRoundRobin{SNIPrefix: true},
+			remoteAddr: "192.168.1.1:31000",
+			expected:   "default",
+		},
+		{
+			desc:       "label from SNI",
+			config:     dynamic.TCPLabeledRoundRobin{SNIPrefix: true},
+			remoteAddr: "192.168.1.1:31000",
+			serverName: "beta.example.com",
+			expected:   "beta",
+		},
+		{
+			desc:       "SNI prefix disabled",
+			config:     dynamic.TCPLabeledRoundRobin{},
+			remoteAddr: "192.168.1.1:31000",
+			serverName: "beta.example.com",
+			expected:   "default",
+		},
+		{
+			desc: "SNI prefix is not a label",
+			config: dynamic.TCPLabeledRoundRobin{
+				SNIPrefix:    true,
+				SourceRanges: []dynamic.LabeledSourceRange{{Label: "dev", SourceRange: []string{"10.0.0.0/8"}}},
+			},
+			remoteAddr: "10.0.0.1:31000",
+			serverName: "www.example.com",
+			expected:   "dev",
+		},
+		{
+			desc: "label from source range",
+			config: dynamic.TCPLabeledRoundRobin{
+				SourceRanges: []dynamic.LabeledSourceRange{
+					{Label: "beta", SourceRange: []string{"192.168.1.0/24"}},
+					{Label: "dev", SourceRange: []string{"192.168.0.0/16"}},
+				},
+			},
+			remoteAddr: "192.168.1.1:31000",
+			expected:   "beta",
+		},
+		{
+			desc: "label from PROXY protocol TLV",
+			config: dynamic.TCPLabeledRoundRobin{
+				SNIPrefix:        true,
+				ProxyProtocolTLV: 0xE0,
+			},
+			remoteAddr: "192.168.1.1:31000",
+			serverName: "beta.example.com",
+			tlv:        "dev",
+			expected:   "dev",
+		},
+		{
+			desc: "unknown label",
+			config: dynamic.TCPLabeledRoundRobin{
+				ProxyProtocolTLV: 0xE0,
+			},
+			remoteAddr: "192.168.1.1:31000",
+			tlv:        "canary",
+			expected:   "default",
+		},
+		{
+			desc: "unknown label from PROXY protocol TLV falls through to SNI",
+			config: dynamic.TCPLabeledRoundRobin{
+				SNIPrefix:        true,
+				ProxyProtocolTLV: 0xE0,
+			},
+			remoteAddr: "192.168.1.1:31000",
+			serverName: "beta.example.com",
+			tlv:        "canary",
+			expected:   "beta",
+		},
+		{
+			desc: "unknown label from source range falls through to the next source range",
+			config: dynamic.TCPLabeledRoundRobin{
+				SourceRanges: []dynamic.LabeledSourceRange{
+					{Label: "canary", SourceRange: []string{"192.168.1.0/24"}},
+					{Label: "dev", SourceRange: []string{"192.168.0.0/16"}},
+				},
+			},
+			remoteAddr: "192.168.1.1:31000",
+			expected:   "dev",
+		},
+	}
+
+	for _, test := range testCases {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var served string
+			handler := func(name string) Handler {
+				return HandlerFunc(func(conn WriteCloser) {
+					served = name
+				})
+			}
+
+			balancer, err := NewLRRLoadBalancer(&test.config, handler("default"))
+			require.NoError(t, err)
+			balancer.AddLabeledServer("beta", handler("beta"))
+			balancer.AddLabeledServer("dev", handler("dev"))
+
+			remoteAddr, err := net.ResolveTCPAddr("tcp", test.remoteAddr)
+			require.NoError(t, err)
+			lc := &labeledConn{remoteAddr: remoteAddr}
+			if test.tlv != "" {
+				lc.header = proxyproto.HeaderProxyFromAddrs(2, remoteAddr, remoteAddr)
+				require.NoError(t, lc.header.SetTLVs([]proxyproto.TLV{{Type: 0xE0, Value: []byte(test.tlv)}}))
+			}
+
+			var conn WriteCloser = lc
+			if test.serverName != "" {
+				conn = &Conn{ServerName: test.serverName, WriteCloser: lc}
+			}
+
+			balancer.ServeTCP(conn)
+			assert.Equal(t, test.expected, served)
+		})
+	}
+}
+
+func TestNewLRRLoadBalancer(t *testing.T) {
+	_, err := NewLRRLoadBalancer(&dynamic.TCPLabeledRoundRobin{ProxyProtocolTLV: 0x01}, HandlerFunc(func(conn WriteCloser) {}))
+	assert.Error(t, err)
+
+	_, err = NewLRRLoadBalancer(&dynamic.TCPLabeledRoundRobin{
+		SourceRanges: []dynamic.LabeledSourceRange{{Label: "beta", SourceRange: []string{"foo"}}},
+	}, HandlerFunc(func(conn WriteCloser) {}))
+	assert.Error(t, err)
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/pires/go-proxyproto"
 	"github.com/traefik/traefik/v2/pkg/log"
 	"github.com/traefik/traefik/v2/pkg/types"
 )
@@ -82,19 +83,19 @@ func (r *Router) ServeTCP(conn WriteCloser) {
 	serverName = types.CanonicalDomain(serverName)
 	if r.routingTable != nil && serverName != "" {
 		if target, ok := r.routingTable[serverName]; ok {
-			target.ServeTCP(r.GetConn(conn, peeked))
+			target.ServeTCP(r.getTLSConn(conn, peeked, serverName))
 			return
 		}
 	}
 
 	// FIXME Needs tests
 	if target, ok := r.routingTable["*"]; ok {
-		target.ServeTCP(r.GetConn(conn, peeked))
+		target.ServeTCP(r.getTLSConn(conn, peeked, serverName))
 		return
 	}
 
 	if r.httpsForwarder != nil {
-		r.httpsForwarder.ServeTCP(r.GetConn(conn, peeked))
+		r.httpsForwarder.ServeTCP(r.getTLSConn(conn, peeked, serverName))
 	} else {
 		conn.Close()
 	}
@@ -139,6 +140,15 @@ func (r *Router) GetConn(conn WriteCloser, peeked string) WriteCloser {
 	return conn
 }
 
+// getTLSConn creates a connection proxy with a peeked string and the SNI of the connection.
+func (r *Router) getTLSConn(conn WriteCloser, peeked, serverName string) WriteCloser {
+	return &Conn{
+		Peeked:      []byte(peeked),
+		ServerName:  serverName,
+		WriteCloser: conn,
+	}
+}
+
 // GetHTTPHandler gets the attached http handler.
 func (r *Router) GetHTTPHandler() http.Handler {
 	return r.httpHandler
@@ -184,6 +194,9 @@ type Conn struct {
 	// by Read calls. It set to nil by Read when fully consumed.
 	Peeked []byte
 
+	// ServerName is the SNI of the connection, if any.
+	ServerName string
+
 	// Conn is the underlying connection.
 	// It can be type asserted against *net.TCPConn or other types
 	// as needed. It should not be read from directly unless
@@ -204,6 +217,42 @@ func (c *Conn) Read(p []byte) (n int, err error) {
 	return c.WriteCloser.Read(p)
 }
 
+// ServerName returns the SNI of conn, if any.
+// The TLS handshake of conn is done when the TLS connection is terminated by Traefik.
+func ServerName(conn net.Conn) string {
+	switch c := conn.(type) {
+	case *Conn:
+		return c.ServerName
+	case *tls.Conn:
+		if err := c.Handshake(); err != nil {
+			return ""
+		}
+		return c.ConnectionState().ServerName
+	default:
+		return ""
+	}
+}
+
+// proxyHeaderConn is implemented by the connections which may have received a PROXY protocol header.
+type proxyHeaderConn interface {
+	ProxyHeader() *proxyproto.Header
+}
+
+// ProxyHeader returns the PROXY protocol header of conn, if any.
+// The header cannot be found when the TLS connection is terminated by Traefik.
+func ProxyHeader(conn net.Conn) *proxyproto.Header {
+	for {
+		switch c := conn.(type) {
+		case proxyHeaderConn:
+			return c.ProxyHeader()
+		case *Conn:
+			conn = c.WriteCloser
+		default:
+			return nil
+		}
+	}
+}
+
 // clientHelloServerName returns the SNI server name inside the TLS ClientHello,
 // without consuming any bytes from br.
 // On any error, the empty string is returned.
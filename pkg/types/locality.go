@@ -0,0 +1,7 @@
+package types
+
+// Locality holds the locality of the Traefik instance, which is used by the locality-aware load balancers.
+type Locality struct {
+	Zone     string   `description:"Zone of the Traefik instance." json:"zone,omitempty" toml:"zone,omitempty" yaml:"zone,omitempty" export:"true"`
+	Failover []string `description:"Zones to spill the requests over to, in priority order, when the servers of the zone are unavailable or overloaded." json:"failover,omitempty" toml:"failover,omitempty" yaml:"failover,omitempty" export:"true"`
+}
@@ -0,0 +1,65 @@
+package udp
+
+import (
+	"fmt"
+
+	"github.com/traefik/traefik/v2/pkg/config/dynamic"
+	"github.com/traefik/traefik/v2/pkg/ip"
+)
+
+type labeledSourceRange struct {
+	label   string
+	checker *ip.Checker
+}
+
+// LRRLoadBalancer is a labeled load balancer for UDP services,
+// it forwards the sessions to the service of their label, or to the default service.
+type LRRLoadBalancer struct {
+	defaultHandler Handler
+	handlers       map[string]Handler
+	sourceRanges   []labeledSourceRange
+}
+
+// NewLRRLoadBalancer creates a new LRRLoadBalancer.
+func NewLRRLoadBalancer(config *dynamic.UDPLabeledRoundRobin, defaultHandler Handler) (*LRRLoadBalancer, error) {
+	b := &LRRLoadBalancer{
+		defaultHandler: defaultHandler,
+		handlers:       make(map[string]Handler),
+	}
+
+	for _, sr := range config.SourceRanges {
+		checker, err := ip.NewChecker(sr.SourceRange)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse source range %s of label %q: %w", sr.SourceRange, sr.Label, err)
+		}
+		b.sourceRanges = append(b.sourceRanges, labeledSourceRange{label: sr.Label, checker: checker})
+	}
+	return b, nil
+}
+
+// AddLabeledServer adds the handler of label.
+func (b *LRRLoadBalancer) AddLabeledServer(label string, handler Handler) {
+	b.handlers[label] = handler
+}
+
+// ServeUDP forwards the session to the service of its label.
+func (b *LRRLoadBalancer) ServeUDP(conn *Conn) {
+	if handler, ok := b.handlers[b.label(conn)]; ok {
+		handler.ServeUDP(conn)
+		return
+	}
+	b.defaultHandler.ServeUDP(conn)
+}
+
+// label returns the label of the first source range of conn with a handler.
+func (b *LRRLoadBalancer) label(conn *Conn) string {
+	if len(b.sourceRanges) > 0 && conn.rAddr != nil {
+		addr := conn.rAddr.String()
+		for _, sr := range b.sourceRanges {
+			if b.handlers[sr.label] != nil && sr.checker.IsAuthorized(addr) == nil {
+				return sr.label
+			}
+		}
+	}
+	return ""
+}